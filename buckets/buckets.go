@@ -0,0 +1,170 @@
+// Package buckets keeps the collections which store files instead of documents.
+// Each bucket has its own limits and may have its own storage provider.
+package buckets
+
+import (
+	"io"
+	"mime"
+	"sync"
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+type Bucket struct {
+	Name string
+
+	// MaxSize is the maximum size of a file in bytes. Zero means no limit.
+	MaxSize int64
+
+	// AllowedTypes are the accepted content types. A type may end with '/*' to
+	// accept every subtype, ex: image/*. Empty means every type is accepted.
+	AllowedTypes []string
+
+	// Provider stores the files of the bucket. If nil, the data provider of the request is used.
+	Provider dataprovider.Provider
+}
+
+// Registry is safe for concurrent use. Its zero value is an empty registry.
+type Registry struct {
+	mutex   sync.RWMutex
+	buckets map[string]Bucket
+}
+
+// NewRegistry returns a registry with the given buckets.
+func NewRegistry(buckets ...Bucket) *Registry {
+	registry := &Registry{}
+	for _, bucket := range buckets {
+		registry.Add(bucket)
+	}
+	return registry
+}
+
+// Add registers the bucket, replacing the bucket with the same name.
+func (r *Registry) Add(bucket Bucket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.buckets == nil {
+		r.buckets = make(map[string]Bucket)
+	}
+	r.buckets[bucket.Name] = bucket
+}
+
+func (r *Registry) Remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.buckets, name)
+}
+
+func (r *Registry) Get(name string) (bucket Bucket, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	bucket, exists = r.buckets[name]
+	return
+}
+
+var errTooLarge = errors.New("file is too large")
+
+// MultipartMargin is the number of bytes multipart uploads may have in addition to the maximum
+// size of the bucket, for the boundaries, the headers and the other fields of the form.
+var MultipartMargin int64 = 64 << 10
+
+// LimitMultipart limits the body of a multipart upload to the maximum size of the bucket and
+// MultipartMargin, so that larger uploads are not buffered before they are rejected. Reading
+// more fails; CheckRead converts the error of the reader to 413 Request Entity Too Large.
+func (b Bucket) LimitMultipart(body io.ReadCloser) io.ReadCloser {
+	if b.MaxSize <= 0 || body == nil {
+		return body
+	}
+	return &limitedReadCloser{body, b.MaxSize + MultipartMargin}
+}
+
+// Accept checks the file against the limits of the bucket. If the content type is
+// unknown, it is detected from the content. The returned data must be read instead of
+// the given one, since it enforces the size limit when the size is unknown beforehand.
+func (b Bucket) Accept(info files.Info, data io.ReadCloser) (accepted files.Info, acceptedData io.ReadCloser, err *utils.Error) {
+
+	accepted = info
+	acceptedData = data
+
+	if b.MaxSize > 0 && info.Size > b.MaxSize {
+		err = b.tooLarge()
+		return
+	}
+
+	if len(b.AllowedTypes) > 0 {
+		if accepted.ContentType == "" && data != nil {
+			buffered := bufio.NewReader(data)
+			head, _ := buffered.Peek(512)
+			accepted.ContentType = http.DetectContentType(head)
+			acceptedData = &readCloser{buffered, data}
+		}
+		if !b.allows(accepted.ContentType) {
+			err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Content type '" + accepted.ContentType + "' is not allowed in bucket '" + b.Name + "'."}
+			return
+		}
+	}
+
+	if b.MaxSize > 0 && acceptedData != nil {
+		acceptedData = &limitedReadCloser{acceptedData, b.MaxSize}
+	}
+	return
+}
+
+// CheckRead converts the error of a provider which failed reading the accepted data
+// because of the size limit to 413 Request Entity Too Large. Returns err otherwise.
+func (b Bucket) CheckRead(acceptedData io.ReadCloser, err *utils.Error) *utils.Error {
+	if limited, isLimited := acceptedData.(*limitedReadCloser); isLimited && limited.remaining < 0 {
+		return b.tooLarge()
+	}
+	return err
+}
+
+func (b Bucket) allows(contentType string) bool {
+	mediaType, _, parseErr := mime.ParseMediaType(contentType)
+	if parseErr != nil {
+		return false
+	}
+	for _, allowed := range b.AllowedTypes {
+		if strings.EqualFold(allowed, mediaType) {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(strings.ToLower(mediaType), strings.ToLower(strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Bucket) tooLarge() *utils.Error {
+	return &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "File exceeds the maximum size of " + strconv.FormatInt(b.MaxSize, 10) + " bytes."}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitedReadCloser fails the read once more than the remaining bytes are read.
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (n int, err error) {
+	if l.remaining < 0 {
+		return 0, errTooLarge
+	}
+	n, err = l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errTooLarge
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package buckets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func content(data string) *readCloser {
+	return &readCloser{strings.NewReader(data), ioutil.NopCloser(nil)}
+}
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given a registry with a bucket", t, func() {
+		registry := NewRegistry(Bucket{Name: "avatars", MaxSize: 10})
+
+		Convey("Get should return the registered bucket", func() {
+			bucket, exists := registry.Get("avatars")
+			So(exists, ShouldBeTrue)
+			So(bucket.MaxSize, ShouldEqual, 10)
+
+			_, exists = registry.Get("attachments")
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("Add should replace the bucket with the same name", func() {
+			registry.Add(Bucket{Name: "avatars", MaxSize: 20})
+			bucket, _ := registry.Get("avatars")
+			So(bucket.MaxSize, ShouldEqual, 20)
+		})
+
+		Convey("Remove should unregister the bucket", func() {
+			registry.Remove("avatars")
+			_, exists := registry.Get("avatars")
+			So(exists, ShouldBeFalse)
+		})
+	})
+
+	Convey("The zero value of a registry should be usable", t, func() {
+		var registry Registry
+		_, exists := registry.Get("files")
+		So(exists, ShouldBeFalse)
+		registry.Add(Bucket{Name: "files"})
+		_, exists = registry.Get("files")
+		So(exists, ShouldBeTrue)
+	})
+}
+
+func TestAccept(t *testing.T) {
+
+	Convey("Given a bucket with limits", t, func() {
+		bucket := Bucket{Name: "avatars", MaxSize: 8, AllowedTypes: []string{"image/*", "text/plain"}}
+
+		Convey("A file with an allowed type should be accepted", func() {
+			_, _, err := bucket.Accept(files.Info{ContentType: "image/png", Size: 4}, content("data"))
+			So(err, ShouldBeNil)
+
+			_, _, err = bucket.Accept(files.Info{ContentType: "text/plain; charset=utf-8", Size: 4}, content("data"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("A file with another type should be rejected", func() {
+			_, _, err := bucket.Accept(files.Info{ContentType: "application/pdf", Size: 4}, content("data"))
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+
+		Convey("The type of a file without a content type should be detected", func() {
+			info, data, err := bucket.Accept(files.Info{Size: -1}, content("hello"))
+			So(err, ShouldBeNil)
+			So(info.ContentType, ShouldStartWith, "text/plain")
+
+			read, _ := ioutil.ReadAll(data)
+			So(string(read), ShouldEqual, "hello")
+		})
+
+		Convey("A file with a known size over the limit should be rejected", func() {
+			_, _, err := bucket.Accept(files.Info{ContentType: "text/plain", Size: 9}, content("too large"))
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("Reading a file with an unknown size over the limit should fail", func() {
+			_, data, err := bucket.Accept(files.Info{ContentType: "text/plain", Size: -1}, content("too large"))
+			So(err, ShouldBeNil)
+
+			_, readErr := ioutil.ReadAll(data)
+			So(readErr, ShouldNotBeNil)
+
+			err = bucket.CheckRead(data, &utils.Error{Code: http.StatusInternalServerError})
+			So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("Reading a file within the limit should not change the error", func() {
+			_, data, _ := bucket.Accept(files.Info{ContentType: "text/plain", Size: -1}, content("small"))
+			read, _ := ioutil.ReadAll(data)
+			So(bytes.Equal(read, []byte("small")), ShouldBeTrue)
+			So(bucket.CheckRead(data, nil), ShouldBeNil)
+		})
+	})
+}
+
+func TestLimitMultipart(t *testing.T) {
+
+	Convey("Given a bucket with a maximum size", t, func() {
+		bucket := Bucket{Name: "avatars", MaxSize: 8}
+
+		Convey("Reading a body over the size and the margin should fail", func() {
+			body := bucket.LimitMultipart(content(strings.Repeat("x", int(8+MultipartMargin+1))))
+			_, readErr := ioutil.ReadAll(body)
+			So(readErr, ShouldNotBeNil)
+			So(bucket.CheckRead(body, &utils.Error{Code: http.StatusBadRequest}).Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("A body within the size and the margin should be read", func() {
+			body := bucket.LimitMultipart(content(strings.Repeat("x", int(8+MultipartMargin))))
+			_, readErr := ioutil.ReadAll(body)
+			So(readErr, ShouldBeNil)
+		})
+	})
+
+	Convey("A bucket without a maximum size should not limit the body", t, func() {
+		body := content("data")
+		So(Bucket{Name: "files"}.LimitMultipart(body), ShouldEqual, body)
+	})
+}
@@ -0,0 +1,199 @@
+// Package cache caches the documents and the query results read from a data provider in a
+// bounded in-memory LRU with a time to live. Writes made through the cache invalidate the
+// cached reads of their collection, so the cache only serves stale results for changes made
+// by other processes or directly on the underlying provider, until the results expire.
+//
+// Usage:
+//
+//   core.DataProvider = cache.New(provider, 10000, time.Minute)
+//   core.DataProvider = cache.NewTransactional(transactionalProvider, 10000, time.Minute)
+package cache
+
+import (
+	"sync"
+	"time"
+	"container/list"
+	"encoding/json"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// DefaultSize is the number of entries kept when the given size is not positive.
+var DefaultSize = 1000
+
+// Stats are the counters of a cache. Expired entries are counted as misses.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// HitRatio returns the ratio of the hits to all lookups.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type entry struct {
+	key        string
+	collection string
+	value      map[string]interface{}
+	expiresAt  time.Time
+}
+
+// lru is a least recently used list of entries with a time to live. Every collection has a
+// generation which is increased when the collection is invalidated, so that the reads which
+// started before a write don't store their results after it.
+type lru struct {
+	mutex       sync.Mutex
+	size        int
+	ttl         time.Duration
+	entries     map[string]*list.Element
+	order       *list.List
+	collections map[string]map[*list.Element]bool
+	generations map[string]uint64
+	stats       Stats
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &lru{
+		size:        size,
+		ttl:         ttl,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		collections: make(map[string]map[*list.Element]bool),
+		generations: make(map[string]uint64),
+	}
+}
+
+// get returns a copy of the cached value.
+func (c *lru) get(key string) (value map[string]interface{}, hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		c.stats.Misses++
+		return
+	}
+	cached := element.Value.(*entry)
+	if c.ttl > 0 && time.Now().After(cached.expiresAt) {
+		c.remove(element)
+		c.stats.Misses++
+		return
+	}
+
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return utils.CopyDocument(cached.value), true
+}
+
+// generation returns the current generation of the collection, which is passed to put.
+func (c *lru) generation(collection string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generations[collection]
+}
+
+// put stores a copy of the value unless the collection is invalidated after the generation.
+func (c *lru) put(key, collection string, generation uint64, value map[string]interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.generations[collection] != generation {
+		return
+	}
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+
+	element := c.order.PushFront(&entry{key: key, collection: collection, value: utils.CopyDocument(value), expiresAt: time.Now().Add(c.ttl)})
+	c.entries[key] = element
+	if c.collections[collection] == nil {
+		c.collections[collection] = make(map[*list.Element]bool)
+	}
+	c.collections[collection][element] = true
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes the entries of the collections.
+func (c *lru) invalidate(collections ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, collection := range collections {
+		c.generations[collection]++
+		for element := range c.collections[collection] {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *lru) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for collection := range c.collections {
+		c.generations[collection]++
+	}
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.collections = make(map[string]map[*list.Element]bool)
+}
+
+func (c *lru) statistics() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// remove removes the entry. Callers must hold the lock.
+func (c *lru) remove(element *list.Element) {
+	cached := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, cached.key)
+	delete(c.collections[cached.collection], element)
+	if len(c.collections[cached.collection]) == 0 {
+		delete(c.collections, cached.collection)
+	}
+}
+
+func getKey(collection, id string) string {
+	return "get\x00" + collection + "\x00" + id
+}
+
+func queryKey(collection string, parameters map[string][]string) string {
+	// maps are encoded with sorted keys
+	encoded, _ := json.Marshal(parameters)
+	return "query\x00" + collection + "\x00" + string(encoded)
+}
+
+func countKey(collection string, where query.Condition) string {
+	q := query.New()
+	q.Where = where
+	encoded, _ := json.Marshal(q.Parameters()[query.WhereParameter])
+	return "count\x00" + collection + "\x00" + string(encoded)
+}
+
+func searchKey(collection string, text string, parameters map[string][]string) string {
+	encoded, _ := json.Marshal(parameters)
+	return "search\x00" + collection + "\x00" + text + "\x00" + string(encoded)
+}
+
+func aggregateKey(collection string, aggregation query.Aggregation) string {
+	encoded, _ := json.Marshal(aggregation)
+	return "aggregate\x00" + collection + "\x00" + string(encoded)
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"time"
+	"testing"
+	"context"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+
+	Convey("Given a cache of a provider", t, func() {
+		provider := &memory.Provider{}
+		cache := New(provider, 2, time.Hour)
+
+		created, _ := cache.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Reading twice should hit the cache", func() {
+			cache.Get("users", id)
+			object, err := cache.Get("users", id)
+			So(err, ShouldBeNil)
+			So(object["name"], ShouldEqual, "alice")
+			So(cache.Stats().Hits, ShouldEqual, 1)
+			So(cache.Stats().Misses, ShouldEqual, 1)
+		})
+
+		Convey("Changes made directly on the provider should not be seen until invalidated", func() {
+			cache.Get("users", id)
+			provider.Update("users", id, map[string]interface{}{"name": "bob"})
+
+			object, _ := cache.Get("users", id)
+			So(object["name"], ShouldEqual, "alice")
+
+			cache.Invalidate("users")
+			object, _ = cache.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+		})
+
+		Convey("Writing through the cache should invalidate the collection", func() {
+			cache.Get("users", id)
+			cache.Query("users", nil)
+			cache.Count("users", nil)
+
+			cache.Update("users", id, map[string]interface{}{"name": "bob"})
+			So(cache.Stats().Entries, ShouldEqual, 0)
+
+			object, _ := cache.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+
+			cache.Create("users", map[string]interface{}{"name": "carol"})
+			count, _ := cache.Count("users", nil)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("Modifying a response should not modify the cached one", func() {
+			response, _ := cache.Query("users", nil)
+			results, _ := dataprovider.Results(response)
+			results[0]["name"] = "mallory"
+
+			response, _ = cache.Query("users", nil)
+			results, _ = dataprovider.Results(response)
+			So(results[0]["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Structured queries should be cached", func() {
+			q := query.New()
+			q.Where = query.Comparison{Field: "name", Operator: query.Equal, Value: "alice"}
+			cache.QueryStructured("users", q)
+			response, _ := cache.QueryStructured("users", q)
+			results, _ := dataprovider.Results(response)
+			So(len(results), ShouldEqual, 1)
+			So(cache.Stats().Hits, ShouldEqual, 1)
+		})
+
+		Convey("The least recently used entries should be evicted", func() {
+			cache.Get("users", id)
+			cache.Query("users", nil)
+			cache.Get("users", id)
+			cache.Count("users", nil)
+
+			stats := cache.Stats()
+			So(stats.Entries, ShouldEqual, 2)
+			So(stats.Evictions, ShouldEqual, 1)
+
+			cache.Get("users", id)
+			So(cache.Stats().Hits, ShouldEqual, 2)
+		})
+
+		Convey("Expired entries should be read again", func() {
+			cache := New(provider, 10, time.Millisecond)
+			cache.Get("users", id)
+			time.Sleep(5 * time.Millisecond)
+			cache.Get("users", id)
+			So(cache.Stats().Hits, ShouldEqual, 0)
+			So(cache.Stats().Misses, ShouldEqual, 2)
+		})
+
+		Convey("Errors should not be cached", func() {
+			cache.Get("users", "unknown")
+			cache.Get("users", "unknown")
+			So(cache.Stats().Entries, ShouldEqual, 0)
+		})
+
+		Convey("The context the cache is bound to should reach the provider", func() {
+			var counted context.Context
+			cache := New(contextRecorder{Delegate: dataprovider.Delegate{Provider: provider}, counted: &counted}, 2, time.Hour)
+			ctx := context.WithValue(context.Background(), contextKey{}, "request")
+
+			_, err := dataprovider.Count(dataprovider.WithContext(ctx, cache), "users", nil)
+			So(err, ShouldBeNil)
+			So(counted, ShouldEqual, ctx)
+		})
+	})
+
+	Convey("Given a cache of a transactional provider", t, func() {
+		provider := &memory.Provider{}
+		cache := NewTransactional(provider, 10, time.Hour)
+
+		created, _ := cache.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+		cache.Get("users", id)
+
+		Convey("Reads in a transaction should hit the cache until it writes to the collection", func() {
+			transaction, _ := cache.Begin(context.Background())
+			transaction.Get("users", id)
+			So(cache.Stats().Hits, ShouldEqual, 1)
+
+			transaction.Update("users", id, map[string]interface{}{"name": "bob"})
+			object, _ := transaction.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+			So(cache.Stats().Hits, ShouldEqual, 1)
+			transaction.Rollback()
+		})
+
+		Convey("Committing a transaction should invalidate the collections it wrote to", func() {
+			transaction, err := cache.Begin(context.Background())
+			So(err, ShouldBeNil)
+			transaction.Update("users", id, map[string]interface{}{"name": "bob"})
+
+			object, _ := cache.Get("users", id)
+			So(object["name"], ShouldEqual, "alice")
+
+			So(transaction.Commit(), ShouldBeNil)
+			object, _ = cache.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+		})
+	})
+}
+
+type contextKey struct{}
+
+// contextRecorder records the context it is bound to when it counts.
+type contextRecorder struct {
+	dataprovider.Delegate
+	ctx     context.Context
+	counted *context.Context
+}
+
+func (r contextRecorder) BindContext(ctx context.Context) dataprovider.Provider {
+	return contextRecorder{r.Delegate.Bind(ctx), ctx, r.counted}
+}
+
+func (r contextRecorder) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	*r.counted = r.ctx
+	return r.Delegate.Count(collection, where)
+}
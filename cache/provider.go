@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"time"
+	"context"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Provider caches the reads of the provider it wraps. Get, Query, Count, Aggregate and Search
+// results are cached; files and errors are not. Create, Update, Modify and Delete invalidate the
+// collection they write to. It is a dataprovider.ContextBinder, so the contexts of the requests
+// are passed to the wrapped provider.
+type Provider struct {
+	dataprovider.Delegate
+	cache *lru
+}
+
+// New returns a cache of the provider keeping at most size entries for ttl. Entries don't
+// expire if ttl is zero. Use NewTransactional to keep the transactions of the provider.
+func New(provider dataprovider.Provider, size int, ttl time.Duration) *Provider {
+	return &Provider{Delegate: dataprovider.Delegate{Provider: provider}, cache: newLRU(size, ttl)}
+}
+
+// Stats returns the counters of the cache.
+func (p *Provider) Stats() Stats {
+	return p.cache.statistics()
+}
+
+// Invalidate removes the cached reads of the collections, ex: after they are changed
+// by another process.
+func (p *Provider) Invalidate(collections ...string) {
+	p.cache.invalidate(collections...)
+}
+
+// Clear removes every cached read.
+func (p *Provider) Clear() {
+	p.cache.clear()
+}
+
+// BindContext returns the provider sharing the cache, which passes the context to the wrapped provider.
+func (p *Provider) BindContext(ctx context.Context) dataprovider.Provider {
+	return p.bind(ctx)
+}
+
+func (p *Provider) bind(ctx context.Context) *Provider {
+	return &Provider{Delegate: p.Delegate.Bind(ctx), cache: p.cache}
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	defer p.cache.invalidate(collection)
+	return p.Provider.Create(collection, data)
+}
+
+func (p *Provider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	key := getKey(collection, id)
+	if cached, hit := p.cache.get(key); hit {
+		return cached, nil
+	}
+
+	generation := p.cache.generation(collection)
+	if response, err = p.Provider.Get(collection, id); err == nil {
+		p.cache.put(key, collection, generation, response)
+	}
+	return
+}
+
+func (p *Provider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+
+	key := queryKey(collection, parameters)
+	if cached, hit := p.cache.get(key); hit {
+		return cached, nil
+	}
+
+	generation := p.cache.generation(collection)
+	if response, err = p.Provider.Query(collection, parameters); err == nil {
+		p.cache.put(key, collection, generation, response)
+	}
+	return
+}
+
+func (p *Provider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+
+	key := queryKey(collection, q.Parameters())
+	if cached, hit := p.cache.get(key); hit {
+		return cached, nil
+	}
+
+	generation := p.cache.generation(collection)
+	if response, err = dataprovider.QueryStructured(p.Provider, collection, q); err == nil {
+		p.cache.put(key, collection, generation, response)
+	}
+	return
+}
+
+func (p *Provider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+
+	key := countKey(collection, where)
+	if cached, hit := p.cache.get(key); hit {
+		return cached["count"].(int), nil
+	}
+
+	generation := p.cache.generation(collection)
+	if count, err = dataprovider.Count(p.Provider, collection, where); err == nil {
+		p.cache.put(key, collection, generation, map[string]interface{}{"count": count})
+	}
+	return
+}
+
+func (p *Provider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+
+	key := aggregateKey(collection, aggregation)
+	if cached, hit := p.cache.get(key); hit {
+		return cached, nil
+	}
+
+	generation := p.cache.generation(collection)
+	if response, err = dataprovider.Aggregate(p.Provider, collection, aggregation); err == nil {
+		p.cache.put(key, collection, generation, response)
+	}
+	return
+}
+
+func (p *Provider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+
+	key := searchKey(collection, text, q.Parameters())
+	if cached, hit := p.cache.get(key); hit {
+		return cached, nil
+	}
+
+	generation := p.cache.generation(collection)
+	if response, err = dataprovider.Search(p.Provider, collection, text, q); err == nil {
+		p.cache.put(key, collection, generation, response)
+	}
+	return
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	defer p.cache.invalidate(collection)
+	return p.Provider.Update(collection, id, data)
+}
+
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	defer p.cache.invalidate(collection)
+	return dataprovider.Modify(p.Provider, collection, id, modify)
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	defer p.cache.invalidate(collection)
+	return p.Provider.Delete(collection, id)
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	defer p.cache.invalidate(collection)
+	return dataprovider.DeleteIf(p.Provider, collection, id, check)
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"sync"
+	"time"
+	"context"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// TransactionalProvider is a cache of a transactional provider. Reads in a transaction are served
+// from the cache until the transaction writes to their collection, and are not cached after it,
+// since they see its changes. The collections it writes to are invalidated when it is committed.
+type TransactionalProvider struct {
+	*Provider
+	transactional dataprovider.TransactionalProvider
+}
+
+// NewTransactional returns a cache of the transactional provider like New.
+func NewTransactional(provider dataprovider.TransactionalProvider, size int, ttl time.Duration) *TransactionalProvider {
+	return &TransactionalProvider{New(provider, size, ttl), provider}
+}
+
+func (p *TransactionalProvider) Begin(ctx context.Context) (transaction dataprovider.Transaction, err *utils.Error) {
+	if transaction, err = p.transactional.Begin(ctx); err != nil {
+		return
+	}
+	transaction = &cachedTransaction{
+		Delegate:    dataprovider.Delegate{Provider: transaction},
+		transaction: transaction,
+		cached:      p.bind(ctx),
+		written:     make(map[string]bool),
+	}
+	return
+}
+
+// cachedTransaction reads from the cache, bound to the context of the transaction, until it
+// writes to the collection.
+type cachedTransaction struct {
+	dataprovider.Delegate
+	transaction dataprovider.Transaction
+	cached      *Provider
+
+	mutex   sync.Mutex
+	written map[string]bool
+}
+
+func (t *cachedTransaction) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	t.write(collection)
+	return t.transaction.Create(collection, data)
+}
+
+func (t *cachedTransaction) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.Get(collection, id)
+	}
+	return t.transaction.Get(collection, id)
+}
+
+func (t *cachedTransaction) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.Query(collection, parameters)
+	}
+	return t.transaction.Query(collection, parameters)
+}
+
+func (t *cachedTransaction) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.QueryStructured(collection, q)
+	}
+	return dataprovider.QueryStructured(t.transaction, collection, q)
+}
+
+func (t *cachedTransaction) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.Count(collection, where)
+	}
+	return dataprovider.Count(t.transaction, collection, where)
+}
+
+func (t *cachedTransaction) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.Aggregate(collection, aggregation)
+	}
+	return dataprovider.Aggregate(t.transaction, collection, aggregation)
+}
+
+func (t *cachedTransaction) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if !t.wrote(collection) {
+		return t.cached.Search(collection, text, q)
+	}
+	return dataprovider.Search(t.transaction, collection, text, q)
+}
+
+func (t *cachedTransaction) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	t.write(collection)
+	return t.transaction.Update(collection, id, data)
+}
+
+func (t *cachedTransaction) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	t.write(collection)
+	return dataprovider.Modify(t.transaction, collection, id, modify)
+}
+
+func (t *cachedTransaction) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	t.write(collection)
+	return t.transaction.Delete(collection, id)
+}
+
+func (t *cachedTransaction) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	t.write(collection)
+	return dataprovider.DeleteIf(t.transaction, collection, id, check)
+}
+
+// Commit commits the transaction and invalidates the collections it wrote to. They are
+// invalidated even if committing fails, since the changes may be partially applied.
+func (t *cachedTransaction) Commit() (err *utils.Error) {
+	defer t.invalidate()
+	return t.transaction.Commit()
+}
+
+func (t *cachedTransaction) Rollback() (err *utils.Error) {
+	return t.transaction.Rollback()
+}
+
+func (t *cachedTransaction) write(collection string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.written[collection] = true
+}
+
+func (t *cachedTransaction) wrote(collection string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.written[collection]
+}
+
+func (t *cachedTransaction) invalidate() {
+	t.mutex.Lock()
+	collections := make([]string, 0, len(t.written))
+	for collection := range t.written {
+		collections = append(collections, collection)
+	}
+	t.mutex.Unlock()
+
+	t.cached.cache.invalidate(collections...)
+}
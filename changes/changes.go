@@ -0,0 +1,173 @@
+// Package changes publishes the changes made to documents to subscribers. A subscription
+// receives the events of a collection, optionally only of the documents matching a condition.
+package changes
+
+import (
+	"sync"
+	"context"
+	"github.com/rihtim/core/query"
+)
+
+// Types of the events.
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// Event is a change of a document. Document is the document after the change, or
+// the last version of the document for deleted events.
+type Event struct {
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection"`
+	Id         string                 `json:"id"`
+	Document   map[string]interface{} `json:"document,omitempty"`
+
+	// previous is the document before an update, which is matched against the
+	// subscriptions too, so that subscribers see the documents leaving a query.
+	previous map[string]interface{}
+}
+
+// SubscriptionBuffer is the number of events kept for a subscriber which is slower than the
+// changes. Subscriptions which fall further behind are closed.
+var SubscriptionBuffer = 256
+
+// Feed delivers the published events to the subscriptions. It is safe for concurrent
+// use and its zero value is a feed without subscriptions.
+type Feed struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]bool
+	collections   map[string]int
+}
+
+type Subscription struct {
+	Collection string
+	Where      query.Condition
+
+	feed   *Feed
+	events chan Event
+}
+
+// Subscribe subscribes to the events of the documents of the collection which match the
+// condition. A nil condition matches every document. The subscription must be closed.
+func (f *Feed) Subscribe(collection string, where query.Condition) *Subscription {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.subscriptions == nil {
+		f.subscriptions = make(map[*Subscription]bool)
+		f.collections = make(map[string]int)
+	}
+	subscription := &Subscription{Collection: collection, Where: where, feed: f, events: make(chan Event, SubscriptionBuffer)}
+	f.subscriptions[subscription] = true
+	f.collections[collection]++
+	return subscription
+}
+
+// Watches tells whether there are subscriptions to the collection.
+func (f *Feed) Watches(collection string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.collections[collection] > 0
+}
+
+// Publish delivers the events to the matching subscriptions without blocking.
+func (f *Feed) Publish(events ...Event) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, event := range events {
+		for subscription := range f.subscriptions {
+			if !subscription.matches(event) {
+				continue
+			}
+			select {
+			case subscription.events <- event:
+			default:
+				f.remove(subscription)
+			}
+		}
+	}
+}
+
+// Events returns the channel of the events. It is closed when the subscription is
+// closed, or when the subscriber falls behind more than SubscriptionBuffer events.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close closes the subscription. It can be called more than once.
+func (s *Subscription) Close() {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	s.feed.remove(s)
+}
+
+func (s *Subscription) matches(event Event) bool {
+	if event.Collection != s.Collection {
+		return false
+	}
+	if s.Where == nil {
+		return true
+	}
+	return (event.Document != nil && s.Where.Match(event.Document)) || (event.previous != nil && s.Where.Match(event.previous))
+}
+
+// remove closes the subscription. Callers must hold the lock of the feed.
+func (f *Feed) remove(subscription *Subscription) {
+	if !f.subscriptions[subscription] {
+		return
+	}
+	delete(f.subscriptions, subscription)
+	f.collections[subscription.Collection]--
+	close(subscription.events)
+}
+
+type pendingKey struct{}
+
+// Pending keeps the events published with a context until they are flushed,
+// ex: the events of a transaction until it is committed.
+type Pending struct {
+	mutex     sync.Mutex
+	published []published
+}
+
+type published struct {
+	feed   *Feed
+	events []Event
+}
+
+// Defer returns a context in which published events are kept in the returned Pending.
+func Defer(ctx context.Context) (context.Context, *Pending) {
+	pending := &Pending{}
+	return context.WithValue(ctx, pendingKey{}, pending), pending
+}
+
+// Flush publishes the kept events. Does nothing if pending is nil.
+func (p *Pending) Flush() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	list := p.published
+	p.published = nil
+	p.mutex.Unlock()
+
+	for _, item := range list {
+		item.feed.Publish(item.events...)
+	}
+}
+
+// Publish publishes the events to the feed, or keeps them if the context is deferred.
+func Publish(ctx context.Context, feed *Feed, events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	if pending, isDeferred := ctx.Value(pendingKey{}).(*Pending); isDeferred {
+		pending.mutex.Lock()
+		pending.published = append(pending.published, published{feed, events})
+		pending.mutex.Unlock()
+		return
+	}
+	feed.Publish(events...)
+}
@@ -0,0 +1,144 @@
+package changes
+
+import (
+	"context"
+	"testing"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func received(subscription *Subscription) (events []Event) {
+	for {
+		select {
+		case event, open := <-subscription.Events():
+			if !open {
+				return
+			}
+			events = append(events, event)
+		default:
+			return
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+
+	Convey("Given a feed with subscriptions", t, func() {
+		feed := &Feed{}
+		all := feed.Subscribe("users", nil)
+		red := feed.Subscribe("users", query.Comparison{Field: "team", Operator: query.Equal, Value: "red"})
+		defer all.Close()
+		defer red.Close()
+
+		Convey("Events should be delivered to the matching subscriptions", func() {
+			feed.Publish(
+				Event{Type: Created, Collection: "users", Id: "1", Document: map[string]interface{}{"team": "red"}},
+				Event{Type: Created, Collection: "users", Id: "2", Document: map[string]interface{}{"team": "blue"}},
+				Event{Type: Created, Collection: "books", Id: "3", Document: map[string]interface{}{"team": "red"}},
+			)
+			So(len(received(all)), ShouldEqual, 2)
+
+			events := received(red)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Id, ShouldEqual, "1")
+		})
+
+		Convey("Documents leaving the condition should be delivered", func() {
+			feed.Publish(Event{Type: Updated, Collection: "users", Id: "1", Document: map[string]interface{}{"team": "blue"}, previous: map[string]interface{}{"team": "red"}})
+			So(len(received(red)), ShouldEqual, 1)
+		})
+
+		Convey("Closed subscriptions should not receive events", func() {
+			red.Close()
+			So(feed.Watches("users"), ShouldBeTrue)
+			all.Close()
+			So(feed.Watches("users"), ShouldBeFalse)
+
+			feed.Publish(Event{Type: Created, Collection: "users", Id: "1"})
+			_, open := <-all.Events()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("Subscriptions falling behind should be closed", func() {
+			for i := 0; i <= SubscriptionBuffer; i++ {
+				feed.Publish(Event{Type: Created, Collection: "users", Id: "1"})
+			}
+			So(len(received(all)), ShouldEqual, SubscriptionBuffer)
+			_, open := <-all.Events()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("Events published with a deferred context should wait until they are flushed", func() {
+			ctx, pending := Defer(context.Background())
+			Publish(ctx, feed, Event{Type: Created, Collection: "users", Id: "1"})
+			So(len(received(all)), ShouldEqual, 0)
+
+			pending.Flush()
+			So(len(received(all)), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRecord(t *testing.T) {
+
+	Convey("Given a recorder of a watched collection", t, func() {
+		provider := &memory.Provider{}
+		feed := &Feed{}
+		subscription := feed.Subscribe("users", nil)
+		defer subscription.Close()
+		recorder := Record(provider, feed)
+
+		created, _ := recorder.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Creating should record the created document", func() {
+			events := recorder.Events()
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Type, ShouldEqual, Created)
+			So(events[0].Document["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Updating should record the updated document", func() {
+			_, err := recorder.Update("users", id, map[string]interface{}{"name": "bob"})
+			So(err, ShouldBeNil)
+
+			events := recorder.Events()
+			So(len(events), ShouldEqual, 2)
+			So(events[1].Type, ShouldEqual, Updated)
+			So(events[1].Document["name"], ShouldEqual, "bob")
+			So(events[1].previous["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Setting the deletion time should record a deleted event", func() {
+			_, err := recorder.Modify("users", id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				document[dataprovider.DeletedAtField] = "2020-01-01T00:00:00Z"
+				return document, nil
+			})
+			So(err, ShouldBeNil)
+			So(recorder.Events()[1].Type, ShouldEqual, Deleted)
+
+			Convey("Deleting it permanently should not record another event", func() {
+				_, err := recorder.Delete("users", id)
+				So(err, ShouldBeNil)
+				So(len(recorder.Events()), ShouldEqual, 2)
+			})
+		})
+
+		Convey("Deleting should record the last version of the document", func() {
+			_, err := recorder.Delete("users", id)
+			So(err, ShouldBeNil)
+
+			events := recorder.Events()
+			So(events[1].Type, ShouldEqual, Deleted)
+			So(events[1].Document["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Changes of collections without subscriptions should not be recorded", func() {
+			recorder.Create("books", map[string]interface{}{"title": "dune"})
+			So(len(recorder.Events()), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package changes
+
+import (
+	"sync"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Recorder is a provider which records the events of the changes made through it. Changes of
+// collections without subscriptions are not recorded, since the documents are read again to
+// create the events. Setting and removing the deletion time of soft deleted documents are
+// recorded as deleted and created events.
+type Recorder struct {
+	dataprovider.Delegate
+	feed *Feed
+
+	mutex  sync.Mutex
+	events []Event
+}
+
+// Record returns a recorder for the collections with subscriptions in the feed.
+func Record(provider dataprovider.Provider, feed *Feed) *Recorder {
+	return &Recorder{Delegate: dataprovider.Delegate{Provider: provider}, feed: feed}
+}
+
+// Events returns the recorded events in order.
+func (r *Recorder) Events() []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+func (r *Recorder) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if response, err = r.Provider.Create(collection, data); err != nil || !r.feed.Watches(collection) {
+		return
+	}
+	id, _ := response[dataprovider.IdField].(string)
+	document, _ := r.Provider.Get(collection, id)
+	r.record(Event{Type: Created, Collection: collection, Id: id, Document: document})
+	return
+}
+
+func (r *Recorder) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if !r.feed.Watches(collection) {
+		return r.Provider.Update(collection, id, data)
+	}
+	previous, _ := r.Provider.Get(collection, id)
+	if response, err = r.Provider.Update(collection, id, data); err != nil {
+		return
+	}
+	r.recordUpdate(collection, id, previous)
+	return
+}
+
+func (r *Recorder) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if !r.feed.Watches(collection) {
+		return dataprovider.Modify(r.Provider, collection, id, modify)
+	}
+	var previous map[string]interface{}
+	response, err = dataprovider.Modify(r.Provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		previous = make(map[string]interface{}, len(document))
+		for key, value := range document {
+			previous[key] = value
+		}
+		return modify(document)
+	})
+	if err != nil {
+		return
+	}
+	r.recordUpdate(collection, id, previous)
+	return
+}
+
+func (r *Recorder) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if !r.feed.Watches(collection) {
+		return r.Provider.Delete(collection, id)
+	}
+	previous, _ := r.Provider.Get(collection, id)
+	if response, err = r.Provider.Delete(collection, id); err != nil {
+		return
+	}
+	// soft deleted documents are published as deleted already
+	if previous == nil || previous[dataprovider.DeletedAtField] == nil {
+		r.record(Event{Type: Deleted, Collection: collection, Id: id, Document: previous})
+	}
+	return
+}
+
+func (r *Recorder) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if !r.feed.Watches(collection) {
+		return dataprovider.DeleteIf(r.Provider, collection, id, check)
+	}
+	var previous map[string]interface{}
+	response, err = dataprovider.DeleteIf(r.Provider, collection, id, func(document map[string]interface{}) *utils.Error {
+		previous = utils.CopyDocument(document)
+		return check(document)
+	})
+	if err != nil {
+		return
+	}
+	// soft deleted documents are published as deleted already
+	if previous[dataprovider.DeletedAtField] == nil {
+		r.record(Event{Type: Deleted, Collection: collection, Id: id, Document: previous})
+	}
+	return
+}
+
+func (r *Recorder) recordUpdate(collection, id string, previous map[string]interface{}) {
+
+	document, _ := r.Provider.Get(collection, id)
+
+	eventType := Updated
+	wasDeleted := previous != nil && previous[dataprovider.DeletedAtField] != nil
+	isDeleted := document != nil && document[dataprovider.DeletedAtField] != nil
+	if !wasDeleted && isDeleted {
+		eventType = Deleted
+	} else if wasDeleted && !isDeleted {
+		eventType = Created
+	}
+
+	r.record(Event{Type: eventType, Collection: collection, Id: id, Document: document, previous: previous})
+}
+
+func (r *Recorder) record(event Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
@@ -2,12 +2,24 @@ package core
 
 import (
 	"io"
+	"mime"
+	"context"
 	"strings"
 	"net/http"
 	"encoding/json"
 
 	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/patch"
 	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/buckets"
+	"github.com/rihtim/core/schema"
+	"github.com/rihtim/core/indexes"
+	"github.com/rihtim/core/history"
+	"github.com/rihtim/core/changes"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/softdelete"
 	"github.com/rihtim/core/messages"
 	"github.com/rihtim/core/requestscope"
 	"github.com/rihtim/core/interceptors"
@@ -21,6 +33,59 @@ var DataProvider dataprovider.Provider
 
 var BodyParserExcludedPaths map[string]bool
 
+// FileBuckets are the collections which store files. Posting to a bucket uploads a file
+// and getting a model of it downloads the file. The 'files' bucket is registered by default.
+var FileBuckets = buckets.NewRegistry(buckets.Bucket{Name: "files"})
+
+// Relations are served as nested resources. A relation from users to books with the foreign
+// key userId serves the books whose userId is {id} at /users/{id}/books[/{bookId}].
+// Relations and references can be expanded in the responses with the expand parameter.
+// Nested requests execute the interceptors of the nested resource and then the ones of the
+// child resource, ex: /books/{bookId}, so that the rules of the child collection apply.
+var Relations = &relations.Registry{}
+
+// Schemas are the JSON Schemas of the collections. Documents which don't match the schema of
+// their collection are rejected with 422 Unprocessable Entity, listing every violation.
+var Schemas = &schema.Registry{}
+
+// Indexes are the indexes of the collections. Objects whose values of the fields of a unique index
+// are the same as another object's are rejected with 409 Conflict, naming the fields. Connect
+// creates the indexes on data providers which implement dataprovider.IndexEnsurer.
+var Indexes = &indexes.Registry{}
+
+// SoftDelete keeps the collections whose objects are moved to a trash when they are deleted.
+// The trash is listed with ?deleted=true, objects are restored with the restore command or
+// with POST /{class}/{id}/_restore and deleted permanently with DELETE ?deleted=true.
+// Restores execute the interceptors of PUT /{class}/{id} too, since they change the object.
+// Use softdelete.StartPurging to purge the objects which are kept longer than the retention.
+var SoftDelete = &softdelete.Registry{}
+
+// History keeps the collections whose objects are recorded before they are updated or deleted.
+// Versions are listed at /{class}/{id}/_history, read at /{class}/{id}/_history/{version} and
+// reverted with the revert command or POST /{class}/{id}/_history/{version}/_revert. Objects
+// are read as they were at a time with ?at={time}. The collections keeping the versions cannot
+// be requested directly. Versions are read with the interceptors of GET /{class}/{id}, whose
+// AFTER_EXEC interceptors receive the documents of the snapshots, and reverted with the ones
+// of PUT /{class}/{id}.
+var History = &history.Registry{}
+
+// Changes publishes the objects created, updated and deleted by the requests. Socket clients
+// subscribe to it with the subscribe command and HTTP clients with GET requests accepting
+// text/event-stream. Changes made in a transaction are published after it is committed.
+var Changes = &changes.Feed{}
+
+// MultipartMaxMemory is the maximum number of bytes of a multipart request kept in
+// memory. The rest of the files are stored in temporary files until the request ends.
+var MultipartMaxMemory int64 = 32 << 20
+
+// Connect connects the data provider and ensures the indexes of the collections on it.
+func Connect() (err *utils.Error) {
+	if err = DataProvider.Connect(); err != nil {
+		return
+	}
+	return indexes.Ensure(DataProvider, Indexes)
+}
+
 func HandleHttpRequest(w http.ResponseWriter, r *http.Request) {
 
 	// parse request
@@ -30,24 +95,84 @@ func HandleHttpRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, _, err := HandleRequest(request, requestscope.Init())
-	buildResponse(w, response, err)
+	// remove the temporary files of the multipart form
+	if request.MultipartForm != nil {
+		defer request.MultipartForm.RemoveAll()
+	}
+
+	// GET requests accepting an event stream receive the changes after the response
+	if acceptsEventStream(r) {
+		handleEventStream(w, r, request)
+		return
+	}
+
+	response, _, err := HandleRequestContext(r.Context(), request, requestscope.Init())
+	buildResponse(w, r, response, err)
 }
 
 func HandleRequest(request messages.Message, requestScope requestscope.RequestScope) (response messages.Message, updatedRequestScope requestscope.RequestScope, err *utils.Error) {
+	return HandleRequestContext(context.Background(), request, requestScope)
+}
+
+// HandleRequestContext handles the request like HandleRequest and cancels it when the
+// given context is done. The context is stored in the request scope for the functions
+// and interceptors, and the data provider they receive is bound to it. The context
+// carries the request scope, see requestscope.FromContext.
+//
+// If the data provider is a TransactionalProvider, BEFORE_EXEC interceptors, the execution
+// and AFTER_EXEC interceptors run in a single transaction. The transaction is rolled back
+// before ON_ERROR interceptors run, which receive the data provider outside the transaction.
+func HandleRequestContext(ctx context.Context, request messages.Message, requestScope requestscope.RequestScope) (response messages.Message, updatedRequestScope requestscope.RequestScope, err *utils.Error) {
+	return handleRequest(ctx, request, requestScope, nil)
+}
+
+// handleRequest handles the request like HandleRequestContext. If prepare is given, it receives
+// the request and the scope edited by the BEFORE_EXEC interceptors before the request is executed,
+// and its error fails the request.
+func handleRequest(ctx context.Context, request messages.Message, requestScope requestscope.RequestScope, prepare func(request messages.Message, requestScope requestscope.RequestScope) *utils.Error) (response messages.Message, updatedRequestScope requestscope.RequestScope, err *utils.Error) {
 
 	var editedRequest, editedResponse messages.Message
 	var editedRequestScope requestscope.RequestScope
 
+	// changes made in a transaction are published when it is committed
+	var pendingChanges *changes.Pending
+	if _, isTransactional := DataProvider.(dataprovider.TransactionalProvider); isTransactional {
+		ctx, pendingChanges = changes.Defer(ctx)
+	}
+
+	// the scope is set up before the context carries it, since zero value scopes have no data
+	requestScope.SetContext(ctx)
+	ctx = requestscope.NewContext(ctx, requestScope)
+	requestScope.SetContext(ctx)
+	db := dataprovider.WithContext(ctx, DataProvider)
+
+	// begin a transaction if the data provider supports it
+	var transaction dataprovider.Transaction
+	transactionDb := db
+	if transactionalProvider, isTransactional := DataProvider.(dataprovider.TransactionalProvider); isTransactional {
+		transaction, err = transactionalProvider.Begin(ctx)
+		if err != nil {
+			response, err = handleError(request, response, requestScope, db, err)
+			return
+		}
+		transactionDb = dataprovider.WithContext(ctx, transaction)
+	}
+
 	// execute BEFORE_EXEC interceptors
-	editedRequest, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.BEFORE_EXEC, requestScope, request, response, DataProvider)
+	editedRequest, editedResponse, editedRequestScope, err = executeInterceptors(interceptors.BEFORE_EXEC, requestScope, request, response, transactionDb)
 	if err != nil {
-		response, err = handleError(request, editedResponse, requestScope, err)
+		err = endTransaction(transaction, err)
+		response, err = handleError(request, editedResponse, requestScope, db, err)
 		return
 	}
 
 	if !editedResponse.IsEmpty() {
 		response = editedResponse
+		if err = endTransaction(transaction, nil); err != nil {
+			response, err = handleError(request, messages.Message{}, requestScope, db, err)
+			return
+		}
+		pendingChanges.Flush()
 		return
 	}
 
@@ -61,15 +186,31 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 		requestScope = editedRequestScope
 	}
 
+	// stop if the request is canceled or timed out during the interceptors
+	if err = utils.ContextError(ctx.Err()); err != nil {
+		err = endTransaction(transaction, err)
+		response, err = handleError(request, editedResponse, requestScope, db, err)
+		return
+	}
+
+	if prepare != nil {
+		if err = prepare(request, requestScope); err != nil {
+			err = endTransaction(transaction, err)
+			response, err = handleError(request, editedResponse, requestScope, db, err)
+			return
+		}
+	}
+
 	// execute the request
 	if Functions.Contains(request.Res, request.Command) {
-		response, editedRequestScope, err = Functions.Execute(request, requestScope, DataProvider)
+		response, editedRequestScope, err = Functions.Execute(request, requestScope, transactionDb)
 	} else {
-		response, editedRequestScope, err = Execute(request, DataProvider)
+		response, editedRequestScope, err = Execute(request, transactionDb)
 	}
 
 	if err != nil {
-		response, err = handleError(request, editedResponse, requestScope, err)
+		err = endTransaction(transaction, err)
+		response, err = handleError(request, editedResponse, requestScope, db, err)
 		return
 	}
 
@@ -79,7 +220,12 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 	}
 
 	// execute AFTER_EXEC interceptors
-	_, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.AFTER_EXEC, requestScope, request, response, DataProvider)
+	_, editedResponse, editedRequestScope, err = executeInterceptors(interceptors.AFTER_EXEC, requestScope, request, response, transactionDb)
+	if err != nil {
+		err = endTransaction(transaction, err)
+		response, err = handleError(request, messages.Message{}, requestScope, db, err)
+		return
+	}
 
 	// update response if interceptor returned an edited response
 	if !editedResponse.IsEmpty() {
@@ -91,13 +237,102 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 		requestScope = editedRequestScope
 	}
 
-	// execute FINAL interceptors in goroutine
-	go Interceptors.Execute(request.Res, request.Command, interceptors.FINAL, requestScope, request, response, DataProvider)
+	if err = endTransaction(transaction, nil); err != nil {
+		response, err = handleError(request, messages.Message{}, requestScope, db, err)
+		return
+	}
+	pendingChanges.Flush()
+
+	// execute FINAL interceptors in goroutine. they run after the response is
+	// returned, so they get a scope and provider detached from the request context,
+	// and the registries as they are now, since they may be replaced in the meantime
+	finalRequestScope := requestScope.Copy()
+	finalRequestScope.SetContext(context.Background())
+	go executeRegisteredInterceptors(Interceptors, Relations, interceptors.FINAL, finalRequestScope, request, finalResponse(response), DataProvider)
+
+	return
+}
+
+// executeInterceptors executes the interceptors of the resource of the request. Nested resources
+// are executed as their child collection, so the interceptors of the child resource are executed
+// after the ones of the nested resource, ex: the interceptors of /books/{bookId} are executed for
+// /users/{id}/books/{bookId}. They receive the request with the resource of the child collection.
+func executeInterceptors(interceptorType interceptors.InterceptorType, requestScope requestscope.RequestScope, request, response messages.Message, db dataprovider.Provider) (editedRequest, editedResponse messages.Message, editedRequestScope requestscope.RequestScope, err *utils.Error) {
+	return executeRegisteredInterceptors(Interceptors, Relations, interceptorType, requestScope, request, response, db)
+}
+
+// executeRegisteredInterceptors executes the interceptors like executeInterceptors, taking them
+// and the relations of the nested resources from the given registries.
+func executeRegisteredInterceptors(controller interceptors.InterceptorController, registry *relations.Registry, interceptorType interceptors.InterceptorType, requestScope requestscope.RequestScope, request, response messages.Message, db dataprovider.Provider) (editedRequest, editedResponse messages.Message, editedRequestScope requestscope.RequestScope, err *utils.Error) {
+
+	editedRequest, editedResponse, editedRequestScope, err = controller.Execute(request.Res, request.Command, interceptorType, requestScope, request, response, db)
+	if err != nil || (interceptorType == interceptors.BEFORE_EXEC && !editedResponse.IsEmpty()) {
+		return
+	}
+
+	// the interceptors of the child resource continue with the edits of the nested ones
+	if editedRequest.IsEmpty() {
+		editedRequest = request
+	}
+	if editedResponse.IsEmpty() {
+		editedResponse = response
+	}
+	if editedRequestScope.IsEmpty() {
+		editedRequestScope = requestScope
+	}
+	childRes, isNested := childResource(registry, editedRequest.Res)
+	if !isNested {
+		return
+	}
 
+	childRequest := editedRequest
+	childRequest.Res = childRes
+	childRequest, childResponse, childRequestScope, err := controller.Execute(childRes, childRequest.Command, interceptorType, editedRequestScope, childRequest, editedResponse, db)
+	if err != nil {
+		return
+	}
+	if !childRequest.IsEmpty() {
+		if childRequest.Res == childRes {
+			childRequest.Res = editedRequest.Res
+		}
+		editedRequest = childRequest
+	}
+	if !childResponse.IsEmpty() {
+		editedResponse = childResponse
+	}
+	if !childRequestScope.IsEmpty() {
+		editedRequestScope = childRequestScope
+	}
 	return
 }
 
-func handleError(request, response messages.Message, requestScope requestscope.RequestScope, err *utils.Error) (returnedResponse messages.Message, returnedErr *utils.Error) {
+// finalResponse returns the response FINAL interceptors receive. Streamed files are closed
+// once they are written, so the interceptors receive their metadata without the content.
+func finalResponse(response messages.Message) messages.Message {
+	if response.File != nil {
+		file := *response.File
+		file.Content = nil
+		response.File = &file
+	}
+	return response
+}
+
+// endTransaction rolls the transaction back if err is not nil, commits it otherwise.
+// Returns the given error or the commit error. Does nothing if there is no transaction.
+func endTransaction(transaction dataprovider.Transaction, err *utils.Error) *utils.Error {
+	if transaction == nil {
+		return err
+	}
+	if err != nil {
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			log.Error("Rolling back transaction failed. Reason: " + rollbackErr.Error())
+		}
+		return err
+	}
+	return transaction.Commit()
+}
+
+func handleError(request, response messages.Message, requestScope requestscope.RequestScope, db dataprovider.Provider, err *utils.Error) (returnedResponse messages.Message, returnedErr *utils.Error) {
 
 	returnedErr = err
 	returnedResponse = response
@@ -105,7 +340,7 @@ func handleError(request, response messages.Message, requestScope requestscope.R
 	requestScope.Set("error", err)
 
 	var editedResponse messages.Message
-	_, editedResponse, _, err = Interceptors.Execute(request.Res, request.Command, interceptors.ON_ERROR, requestScope, request, response, DataProvider)
+	_, editedResponse, _, err = executeInterceptors(interceptors.ON_ERROR, requestScope, request, response, db)
 
 	if err != nil {
 		returnedErr = err
@@ -154,6 +389,32 @@ func parseRequest(r *http.Request) (request messages.Message, err *utils.Error)
 		return
 	}
 
+	// uploads to the buckets are read by the post handler, multipart forms are limited to their size
+	bucket, isUpload := uploadBucket(request.Command, res)
+	if isUpload {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+			return
+		}
+		r.Body = bucket.LimitMultipart(r.Body)
+	}
+
+	// JSON Patch documents are arrays, they are decoded by the patch handler
+	if patch.IsJSONPatch(r.Header.Get("Content-Type")) {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		if parseErr := r.ParseMultipartForm(MultipartMaxMemory); parseErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parsing multipart form failed. Reason: " + parseErr.Error()}
+			if isUpload {
+				err = bucket.CheckRead(r.Body, err)
+			}
+			return
+		}
+		request.MultipartForm = r.MultipartForm
+		return
+	}
+
 	readErr := json.NewDecoder(r.Body).Decode(&request.Body)
 	if readErr != nil && readErr != io.EOF {
 		err = &utils.Error{Code: http.StatusBadRequest, Message: "Parsing request body failed. Reason: " + readErr.Error()}
@@ -161,19 +422,36 @@ func parseRequest(r *http.Request) (request messages.Message, err *utils.Error)
 	return
 }
 
-func buildResponse(w http.ResponseWriter, response messages.Message, err *utils.Error) {
+// uploadBucket returns the bucket the request uploads a file to, if it posts to a bucket.
+func uploadBucket(command, res string) (bucket buckets.Bucket, isUpload bool) {
+	parts := strings.Split(res, "/")
+	if !strings.EqualFold(command, methods.Post) || len(parts) != 2 {
+		return
+	}
+	return FileBuckets.Get(parts[1])
+}
+
+func buildResponse(w http.ResponseWriter, r *http.Request, response messages.Message, err *utils.Error) {
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	for k, v := range response.Headers {
 		w.Header().Set(k, v[0])
 	}
 
+	if response.File != nil {
+		defer response.File.Close()
+		if err == nil {
+			serveFile(w, r, *response.File)
+			return
+		}
+	}
+
 	if err != nil {
 		if response.Status == 0 {
 			response.Status = err.Code
 		}
 		if response.Body == nil {
-			response.Body = map[string]interface{}{"code": err.Code, "message": err.Message}
+			response.Body = errorBody(err)
 		}
 	}
 
@@ -199,3 +477,26 @@ func buildResponse(w http.ResponseWriter, response messages.Message, err *utils.
 		io.WriteString(w, string(bytes))
 	}
 }
+
+// serveFile streams the file. Range requests are answered with 206 Partial Content. Clients
+// are told not to sniff the content type, and files which are not safe to display are served
+// as attachments, since they are uploaded by the clients.
+func serveFile(w http.ResponseWriter, r *http.Request, file files.File) {
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = files.DefaultContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", file.ContentDisposition())
+	http.ServeContent(w, r, "", file.ModTime, file.Content)
+}
+
+func errorBody(err *utils.Error) map[string]interface{} {
+	body := map[string]interface{}{"code": err.Code, "message": err.Message}
+	if err.Details != nil {
+		body["details"] = err.Details
+	}
+	return body
+}
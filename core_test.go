@@ -0,0 +1,870 @@
+package core
+
+import (
+	"io"
+	"time"
+	"bufio"
+	"context"
+	"bytes"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"net/http"
+	"encoding/json"
+	"mime/multipart"
+	"net/textproto"
+	"net/http/httptest"
+	"github.com/rihtim/core/etag"
+	"github.com/rihtim/core/patch"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/schema"
+	"github.com/rihtim/core/history"
+	"github.com/rihtim/core/buckets"
+	"github.com/rihtim/core/changes"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/softdelete"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reset replaces the data provider and the interceptors with new ones for a test.
+func reset() *memory.Provider {
+	provider := &memory.Provider{}
+	DataProvider = provider
+	Interceptors = &interceptors.CoreInterceptorController{}
+	return provider
+}
+
+func TestHandleRequestContext(t *testing.T) {
+
+	Convey("Given the memory provider", t, func() {
+		reset()
+		request := messages.Message{Res: "/users", Command: methods.Post, Body: map[string]interface{}{"name": "alice"}}
+
+		Convey("A zero value request scope should be accepted", func() {
+			response, _, err := HandleRequest(request, requestscope.RequestScope{})
+			So(err, ShouldBeNil)
+			So(response.Status, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("A canceled context should return 499", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, _, err := HandleRequestContext(ctx, request, requestscope.Init())
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, 499)
+		})
+
+		Convey("An expired context should return 504", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+			defer cancel()
+			_, _, err := HandleRequestContext(ctx, request, requestscope.Init())
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusGatewayTimeout)
+		})
+	})
+}
+
+func TestTransactions(t *testing.T) {
+
+	Convey("Given a BEFORE_EXEC interceptor writing to the transaction", t, func() {
+		provider := reset()
+		Interceptors.Add(interceptors.AnyPath, "*", interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			_, err = dp.Create("logs", map[string]interface{}{"command": req.Command})
+			return
+		}, nil)
+
+		Convey("The write should be committed if the request succeeds", func() {
+			_, _, err := HandleRequest(messages.Message{Res: "/users", Command: methods.Post, Body: map[string]interface{}{"name": "alice"}}, requestscope.Init())
+			So(err, ShouldBeNil)
+
+			logs, _ := provider.Query("logs", nil)
+			So(logs["results"], ShouldHaveLength, 1)
+		})
+
+		Convey("The write should be rolled back if the execution fails", func() {
+			_, _, err := HandleRequest(messages.Message{Res: "/users/missing", Command: methods.Put, Body: map[string]interface{}{"name": "alice"}}, requestscope.Init())
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			logs, _ := provider.Query("logs", nil)
+			So(logs["results"], ShouldBeEmpty)
+		})
+
+		Convey("ON_ERROR interceptors should receive the provider outside the transaction", func() {
+			Interceptors.Add("/users/{id}", "*", interceptors.ON_ERROR, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				dp.Create("errors", map[string]interface{}{"command": req.Command})
+				return
+			}, nil)
+			HandleRequest(messages.Message{Res: "/users/missing", Command: methods.Put, Body: map[string]interface{}{"name": "alice"}}, requestscope.Init())
+
+			errors, _ := provider.Query("errors", nil)
+			So(errors["results"], ShouldHaveLength, 1)
+		})
+	})
+}
+
+// serve handles the request with HandleHttpRequest and decodes the JSON body of the response.
+func serve(method, target string, body interface{}) (recorder *httptest.ResponseRecorder, decoded map[string]interface{}) {
+	return serveWithHeaders(method, target, nil, body)
+}
+
+// serveWithHeaders serves the request like serve with the headers.
+func serveWithHeaders(method, target string, headers map[string]string, body interface{}) (recorder *httptest.ResponseRecorder, decoded map[string]interface{}) {
+	var request *http.Request
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		request = httptest.NewRequest(method, target, strings.NewReader(string(encoded)))
+	} else {
+		request = httptest.NewRequest(method, target, nil)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	recorder = httptest.NewRecorder()
+	HandleHttpRequest(recorder, request)
+	json.Unmarshal(recorder.Body.Bytes(), &decoded)
+	return
+}
+
+func TestPaging(t *testing.T) {
+
+	Convey("Given objects some of which are missing the sort field", t, func() {
+		reset()
+		serve(http.MethodPost, "/items", map[string]interface{}{"n": 1})
+		serve(http.MethodPost, "/items", map[string]interface{}{"n": 2})
+		serve(http.MethodPost, "/items", map[string]interface{}{})
+		serve(http.MethodPost, "/items", map[string]interface{}{})
+
+		Convey("Every object should be returned once across the pages", func() {
+			seen := map[interface{}]bool{}
+			target := "/items?sort=n&limit=1"
+			for pages := 0; pages < 10; pages++ {
+				_, body := serve(http.MethodGet, target, nil)
+				for _, result := range body["results"].([]interface{}) {
+					seen[result.(map[string]interface{})["_id"]] = true
+				}
+				next, hasNext := body["next"].(string)
+				if !hasNext {
+					break
+				}
+				target = "/items?sort=n&limit=1&after=" + next
+			}
+			So(seen, ShouldHaveLength, 4)
+		})
+	})
+}
+
+func TestPatch(t *testing.T) {
+
+	Convey("Given an object", t, func() {
+		reset()
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice", "age": 30})
+		target := "/users/" + user["_id"].(string)
+
+		Convey("Merge patches should change the fields of the object", func() {
+			recorder, body := serveWithHeaders(http.MethodPatch, target, map[string]string{"Content-Type": patch.MergePatchContentType}, map[string]interface{}{"age": 31, "name": nil})
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+
+			_, body = serve(http.MethodGet, target, nil)
+			So(body["age"], ShouldEqual, 31)
+			So(body, ShouldNotContainKey, "name")
+		})
+
+		Convey("JSON Patch documents should be applied to the object", func() {
+			operations := []interface{}{map[string]interface{}{"op": "replace", "path": "/age", "value": 32}}
+			recorder, _ := serveWithHeaders(http.MethodPatch, target, map[string]string{"Content-Type": patch.JSONPatchContentType}, operations)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+
+			_, body := serve(http.MethodGet, target, nil)
+			So(body["age"], ShouldEqual, 32)
+		})
+
+		Convey("Other content types should return 415", func() {
+			recorder, _ := serveWithHeaders(http.MethodPatch, target, map[string]string{"Content-Type": "application/json"}, map[string]interface{}{"age": 31})
+			So(recorder.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+
+			recorder, _ = serve(http.MethodPatch, target, map[string]interface{}{"age": 31})
+			So(recorder.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+
+		Convey("Interceptors rejecting PUT requests should reject patches", func() {
+			Interceptors.Add("/users/{id}", methods.Put, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be changed."}
+				return
+			}, nil)
+			recorder, _ := serveWithHeaders(http.MethodPatch, target, map[string]string{"Content-Type": patch.MergePatchContentType}, map[string]interface{}{"age": 31})
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+
+			_, body := serve(http.MethodGet, target, nil)
+			So(body["age"], ShouldEqual, 30)
+		})
+	})
+}
+
+func TestConditionalRequests(t *testing.T) {
+
+	Convey("Given an object and its entity tag", t, func() {
+		reset()
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice"})
+		target := "/users/" + user["_id"].(string)
+
+		recorder, _ := serve(http.MethodGet, target, nil)
+		tag := recorder.Header().Get(etag.ETagHeader)
+
+		Convey("Responses of the object should have the entity tag", func() {
+			So(tag, ShouldNotBeEmpty)
+		})
+
+		Convey("Matching If-None-Match headers should return 304", func() {
+			recorder, _ := serveWithHeaders(http.MethodGet, target, map[string]string{etag.IfNoneMatchHeader: tag}, nil)
+			So(recorder.Code, ShouldEqual, http.StatusNotModified)
+			So(recorder.Header().Get(etag.ETagHeader), ShouldEqual, tag)
+		})
+
+		Convey("When the object is changed", func() {
+			serve(http.MethodPut, target, map[string]interface{}{"name": "bob"})
+
+			Convey("Stale If-Match headers should return 412 for PUT", func() {
+				recorder, _ := serveWithHeaders(http.MethodPut, target, map[string]string{etag.IfMatchHeader: tag}, map[string]interface{}{"name": "carol"})
+				So(recorder.Code, ShouldEqual, http.StatusPreconditionFailed)
+			})
+
+			Convey("Stale If-Match headers should return 412 for PATCH", func() {
+				headers := map[string]string{etag.IfMatchHeader: tag, "Content-Type": patch.MergePatchContentType}
+				recorder, _ := serveWithHeaders(http.MethodPatch, target, headers, map[string]interface{}{"name": "carol"})
+				So(recorder.Code, ShouldEqual, http.StatusPreconditionFailed)
+			})
+
+			Convey("Stale If-Match headers should return 412 for DELETE", func() {
+				recorder, _ := serveWithHeaders(http.MethodDelete, target, map[string]string{etag.IfMatchHeader: tag}, nil)
+				So(recorder.Code, ShouldEqual, http.StatusPreconditionFailed)
+
+				_, body := serve(http.MethodGet, target, nil)
+				So(body["name"], ShouldEqual, "bob")
+			})
+
+			Convey("Current If-Match headers should be accepted", func() {
+				recorder, _ := serve(http.MethodGet, target, nil)
+				recorder, _ = serveWithHeaders(http.MethodDelete, target, map[string]string{etag.IfMatchHeader: recorder.Header().Get(etag.ETagHeader)}, nil)
+				So(recorder.Code, ShouldEqual, http.StatusNoContent)
+			})
+		})
+	})
+}
+
+func TestFiles(t *testing.T) {
+
+	Convey("Given an uploaded file", t, func() {
+		reset()
+		request := httptest.NewRequest(http.MethodPost, "/files", strings.NewReader("0123456789"))
+		request.Header.Set("Content-Type", "text/plain")
+		recorder := httptest.NewRecorder()
+		HandleHttpRequest(recorder, request)
+		So(recorder.Code, ShouldEqual, http.StatusCreated)
+
+		var created map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &created)
+		target := "/files/" + created["_id"].(string)
+
+		Convey("Getting it should stream the whole content", func() {
+			recorder, _ := serve(http.MethodGet, target, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Body.String(), ShouldEqual, "0123456789")
+			So(recorder.Header().Get("Accept-Ranges"), ShouldEqual, "bytes")
+		})
+
+		Convey("Range requests should return 206 with the range", func() {
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			request.Header.Set("Range", "bytes=2-5")
+			recorder := httptest.NewRecorder()
+			HandleHttpRequest(recorder, request)
+			So(recorder.Code, ShouldEqual, http.StatusPartialContent)
+			So(recorder.Body.String(), ShouldEqual, "2345")
+			So(recorder.Header().Get("Content-Range"), ShouldEqual, "bytes 2-5/10")
+		})
+
+		Convey("Unsatisfiable ranges should return 416", func() {
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			request.Header.Set("Range", "bytes=20-30")
+			recorder := httptest.NewRecorder()
+			HandleHttpRequest(recorder, request)
+			So(recorder.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+		})
+
+		Convey("FINAL interceptors should receive the metadata without the closed content", func() {
+			received := make(chan messages.Message, 1)
+			// the FINAL interceptors of the upload may still be running, so only the download is received
+			Interceptors.Add(interceptors.AnyPath, methods.Get, interceptors.FINAL, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				received <- resp
+				return
+			}, nil)
+			serve(http.MethodGet, target, nil)
+
+			response := <-received
+			So(response.File, ShouldNotBeNil)
+			So(response.File.Content, ShouldBeNil)
+			So(response.File.Size, ShouldEqual, 10)
+		})
+	})
+}
+
+// upload posts the content as a multipart form file with the name and the content type.
+func upload(target, name, contentType, content string) (recorder *httptest.ResponseRecorder, created map[string]interface{}) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+name+`"`)
+	header.Set("Content-Type", contentType)
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte(content))
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, target, &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	recorder = httptest.NewRecorder()
+	HandleHttpRequest(recorder, request)
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+	return
+}
+
+func TestUploads(t *testing.T) {
+
+	Convey("Given the memory provider", t, func() {
+		reset()
+
+		Convey("The metadata of multipart uploads should be stored with the file", func() {
+			recorder, created := upload("/files", "notes.txt", "text/plain", "hello")
+			So(recorder.Code, ShouldEqual, http.StatusCreated)
+			So(created["name"], ShouldEqual, "notes.txt")
+			So(created["contentType"], ShouldEqual, "text/plain")
+			So(created["size"], ShouldEqual, 5)
+
+			recorder, _ = serve(http.MethodGet, "/files/"+created["_id"].(string), nil)
+			So(recorder.Body.String(), ShouldEqual, "hello")
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, "text/plain")
+			So(recorder.Header().Get("Content-Disposition"), ShouldEqual, `inline; filename=notes.txt`)
+			So(recorder.Header().Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+		})
+
+		Convey("Multipart uploads over the size of the bucket should return 413", func() {
+			FileBuckets.Add(buckets.Bucket{Name: "avatars", MaxSize: 8})
+			defer FileBuckets.Remove("avatars")
+
+			recorder, _ := upload("/avatars", "large.txt", "text/plain", strings.Repeat("x", int(buckets.MultipartMargin)+16))
+			So(recorder.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+			recorder, _ = upload("/avatars", "small.txt", "text/plain", "small")
+			So(recorder.Code, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("Files which are not safe to display should be served as attachments", func() {
+			_, created := upload("/files", "page.html", "text/html", "<script>alert(1)</script>")
+			recorder, _ := serve(http.MethodGet, "/files/"+created["_id"].(string), nil)
+			So(recorder.Header().Get("Content-Disposition"), ShouldEqual, `attachment; filename=page.html`)
+			So(recorder.Header().Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+
+			_, created = upload("/files", "image.svg", "image/svg+xml", "<svg/>")
+			recorder, _ = serve(http.MethodGet, "/files/"+created["_id"].(string), nil)
+			So(recorder.Header().Get("Content-Disposition"), ShouldEqual, `attachment; filename=image.svg`)
+		})
+	})
+}
+
+// boundStorage records the contexts it is bound to.
+type boundStorage struct {
+	*memory.Provider
+	contexts []context.Context
+}
+
+func (b *boundStorage) BindContext(ctx context.Context) dataprovider.Provider {
+	b.contexts = append(b.contexts, ctx)
+	return b.Provider
+}
+
+type testKey struct{}
+
+func TestBucketStorage(t *testing.T) {
+
+	Convey("Given a bucket with its own storage provider", t, func() {
+		reset()
+		storage := &boundStorage{Provider: &memory.Provider{}}
+		FileBuckets.Add(buckets.Bucket{Name: "avatars", Provider: storage})
+		defer FileBuckets.Remove("avatars")
+
+		Convey("The storage provider should be bound to the context of the request", func() {
+			ctx := context.WithValue(context.Background(), testKey{}, "request")
+			request := messages.Message{Res: "/avatars", Command: methods.Post, ReqBodyRaw: ioutil.NopCloser(strings.NewReader("data"))}
+			response, _, err := HandleRequestContext(ctx, request, requestscope.Init())
+			So(err, ShouldBeNil)
+
+			_, _, err = HandleRequestContext(ctx, messages.Message{Res: "/avatars/" + response.Body["_id"].(string), Command: methods.Get}, requestscope.Init())
+			So(err, ShouldBeNil)
+
+			So(storage.contexts, ShouldHaveLength, 2)
+			for _, bound := range storage.contexts {
+				So(bound.Value(testKey{}), ShouldEqual, "request")
+			}
+		})
+	})
+}
+
+func TestNestedInterceptors(t *testing.T) {
+
+	Convey("Given books nested under users with an interceptor on books", t, func() {
+		reset()
+		Relations = relations.NewRegistry(relations.Relation{Parent: "users", Name: "books", ForeignKey: "userId"})
+		defer func() { Relations = &relations.Registry{} }()
+
+		var resources []string
+		Interceptors.Add("/books/{id}", "*", interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			resources = append(resources, req.Res)
+			if req.Command == methods.Delete {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Books cannot be deleted."}
+			}
+			return
+		}, nil)
+
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice"})
+		userId := user["_id"].(string)
+		_, book := serve(http.MethodPost, "/users/"+userId+"/books", map[string]interface{}{"title": "dune"})
+		bookId := book["_id"].(string)
+
+		Convey("Nested requests should execute the interceptors of the child resource", func() {
+			recorder, _ := serve(http.MethodGet, "/users/"+userId+"/books/"+bookId, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(resources, ShouldResemble, []string{"/books/" + bookId})
+
+			recorder, _ = serve(http.MethodDelete, "/users/"+userId+"/books/"+bookId, nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+
+			recorder, _ = serve(http.MethodGet, "/users/"+userId+"/books/"+bookId, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestExpansionInterceptors(t *testing.T) {
+
+	Convey("Given posts referencing users with an interceptor hiding passwords", t, func() {
+		reset()
+		Relations = &relations.Registry{}
+		Relations.AddReference(relations.Reference{Collection: "posts", Field: "author", Target: "users"})
+		defer func() { Relations = &relations.Registry{} }()
+
+		Interceptors.Add("/users", methods.Get, interceptors.AFTER_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			results, _ := dataprovider.Results(resp.Body)
+			for _, result := range results {
+				delete(result, "password")
+			}
+			return
+		}, nil)
+
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice", "password": "secret"})
+		_, post := serve(http.MethodPost, "/posts", map[string]interface{}{"title": "first", "author": user["_id"]})
+		target := "/posts/" + post["_id"].(string)
+
+		Convey("Expanded documents should pass through the interceptors of their collection", func() {
+			_, body := serve(http.MethodGet, target+"?expand=author", nil)
+			So(body["author"], ShouldContainKey, "name")
+			So(body["author"], ShouldNotContainKey, "password")
+
+			_, body = serve(http.MethodGet, "/posts?expand=author", nil)
+			author := body["results"].([]interface{})[0].(map[string]interface{})["author"]
+			So(author, ShouldNotContainKey, "password")
+		})
+
+		Convey("Interceptors rejecting reads of the collection should fail the expansion", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be read."}
+				return
+			}, nil)
+			recorder, _ := serve(http.MethodGet, target+"?expand=author", nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Fields of expanded documents should be selected with dots", func() {
+			_, body := serve(http.MethodGet, "/posts?expand=author&fields=title,author.name", nil)
+			result := body["results"].([]interface{})[0].(map[string]interface{})
+			So(result["title"], ShouldEqual, "first")
+			So(result["author"], ShouldResemble, map[string]interface{}{"name": "alice"})
+		})
+	})
+}
+
+func TestSoftDeleteHistory(t *testing.T) {
+
+	Convey("Given a soft deleted collection with history and a schema added after its objects", t, func() {
+		reset()
+		SoftDelete.Add(softdelete.Policy{Collection: "users"})
+		History.Add(history.Policy{Collection: "users"})
+		defer func() {
+			SoftDelete, History, Schemas = &softdelete.Registry{}, &history.Registry{}, &schema.Registry{}
+		}()
+
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice"})
+		target := "/users/" + user["_id"].(string)
+
+		required, _ := schema.Compile(map[string]interface{}{"required": []interface{}{"email"}})
+		Schemas.Add("users", required)
+
+		Convey("Objects which don't match the schema should still be deleted and restored", func() {
+			recorder, _ := serve(http.MethodPut, target, map[string]interface{}{"name": "bob"})
+			So(recorder.Code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			recorder, _ = serve(http.MethodDelete, target, nil)
+			So(recorder.Code, ShouldEqual, http.StatusNoContent)
+			recorder, _ = serve(http.MethodPost, target+"/"+softdelete.RestoreSegment, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+
+			_, body := serve(http.MethodGet, target, nil)
+			So(body["name"], ShouldEqual, "alice")
+
+			Convey("And the history should record them as a delete and a restore", func() {
+				_, body := serve(http.MethodGet, target+"/"+history.Segment+"?sort=version", nil)
+				results := body["results"].([]interface{})
+				So(results, ShouldHaveLength, 2)
+				So(results[0].(map[string]interface{})[history.OperationField], ShouldEqual, history.DeleteOperation)
+				So(results[1].(map[string]interface{})[history.OperationField], ShouldEqual, history.RestoreOperation)
+			})
+		})
+
+		Convey("The history collection should not be accessible directly", func() {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				recorder, _ := serve(method, "/users_history", map[string]interface{}{"objectId": user["_id"]})
+				So(recorder.Code, ShouldEqual, http.StatusForbidden)
+			}
+			recorder, _ := serve(http.MethodPut, "/users_history/any", map[string]interface{}{"version": 1})
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestRestoreInterceptors(t *testing.T) {
+
+	Convey("Given a deleted object of a soft deleted collection", t, func() {
+		reset()
+		SoftDelete.Add(softdelete.Policy{Collection: "users"})
+		defer func() { SoftDelete = &softdelete.Registry{} }()
+
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice"})
+		target := "/users/" + user["_id"].(string)
+		serve(http.MethodDelete, target, nil)
+
+		Convey("Interceptors rejecting PUT requests should reject restores", func() {
+			Interceptors.Add("/users/{id}", methods.Put, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be changed."}
+				return
+			}, nil)
+
+			recorder, _ := serve(http.MethodPost, target+"/"+softdelete.RestoreSegment, nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+
+			_, _, err := HandleRequest(messages.Message{Res: target, Command: methods.Restore}, requestscope.Init())
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+
+			recorder, _ = serve(http.MethodGet, target, nil)
+			So(recorder.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestHistoryInterceptors(t *testing.T) {
+
+	Convey("Given an object with history and an interceptor hiding passwords", t, func() {
+		reset()
+		History.Add(history.Policy{Collection: "users"})
+		defer func() { History = &history.Registry{} }()
+
+		Interceptors.Add("/users/{id}", methods.Get, interceptors.AFTER_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			delete(resp.Body, "password")
+			return
+		}, nil)
+
+		_, user := serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice", "password": "secret"})
+		target := "/users/" + user["_id"].(string)
+		serve(http.MethodPut, target, map[string]interface{}{"name": "bob"})
+
+		Convey("Versions should pass through the interceptors of the object", func() {
+			recorder, body := serve(http.MethodGet, target+"/"+history.Segment, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			snapshot := body["results"].([]interface{})[0].(map[string]interface{})
+			So(snapshot[history.DocumentField], ShouldContainKey, "name")
+			So(snapshot[history.DocumentField], ShouldNotContainKey, "password")
+
+			recorder, body = serve(http.MethodGet, target+"/"+history.Segment+"/1", nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(body[history.DocumentField], ShouldContainKey, "name")
+			So(body[history.DocumentField], ShouldNotContainKey, "password")
+		})
+
+		Convey("Interceptors rejecting reads of the object should reject reads of its versions", func() {
+			Interceptors.Add("/users/{id}", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be read."}
+				return
+			}, nil)
+			recorder, _ := serve(http.MethodGet, target+"/"+history.Segment, nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+			recorder, _ = serve(http.MethodGet, target+"/"+history.Segment+"/1", nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Interceptors rejecting PUT requests should reject reverts", func() {
+			Interceptors.Add("/users/{id}", methods.Put, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be changed."}
+				return
+			}, nil)
+			recorder, _ := serve(http.MethodPost, target+"/"+history.Segment+"/1/"+history.RevertSegment, nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+
+			_, body := serve(http.MethodGet, target, nil)
+			So(body["name"], ShouldEqual, "bob")
+		})
+	})
+}
+
+func TestSubscriptions(t *testing.T) {
+
+	Convey("Given subscriptions to a collection", t, func() {
+		reset()
+		Changes = &changes.Feed{}
+		request := messages.Message{Res: "/users", Command: methods.Subscribe}
+
+		// next returns the next event of the subscription which passes its filter
+		next := func(subscription *changes.Subscription, filter eventFilter) changes.Event {
+			for event := range subscription.Events() {
+				if event, send := filter(event); send {
+					return event
+				}
+			}
+			return changes.Event{}
+		}
+
+		Convey("Changes should be filtered by the request edited by the interceptors", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				editedReq = req
+				editedReq.Parameters = map[string][]string{"where": {`{"team":"a"}`}}
+				return
+			}, nil)
+			_, subscription, filter, err := subscribe(context.Background(), request, requestscope.Init())
+			So(err, ShouldBeNil)
+			defer subscription.Close()
+
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob", "team": "b"})
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "carol", "team": "a"})
+			event := next(subscription, filter)
+			So(event.Type, ShouldEqual, changes.Created)
+			So(event.Document["name"], ShouldEqual, "carol")
+		})
+
+		Convey("Changes should go through the interceptors and the fields parameter", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.AFTER_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				results, _ := dataprovider.Results(resp.Body)
+				for _, result := range results {
+					delete(result, "password")
+				}
+				return
+			}, nil)
+			request.Parameters = map[string][]string{"fields": {"name,password,team"}}
+			_, subscription, filter, err := subscribe(context.Background(), request, requestscope.Init())
+			So(err, ShouldBeNil)
+			defer subscription.Close()
+
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob", "team": "b", "age": 30, "password": "secret"})
+			event := next(subscription, filter)
+			So(event.Document["name"], ShouldEqual, "bob")
+			So(event.Document, ShouldContainKey, "team")
+			So(event.Document, ShouldNotContainKey, "age")
+			So(event.Document, ShouldNotContainKey, "password")
+		})
+	})
+}
+
+
+// pipeWriter streams the response to a pipe, so that writes block until they are read.
+type pipeWriter struct {
+	*io.PipeWriter
+	header http.Header
+}
+
+func (pw *pipeWriter) Header() http.Header {
+	return pw.header
+}
+
+func (pw *pipeWriter) WriteHeader(statusCode int) {}
+
+func (pw *pipeWriter) Flush() {}
+
+// stream opens an event stream and returns the reader of the stream, a channel which
+// is closed once the handler returns and the function reading the next event.
+func stream(target string) (reader *io.PipeReader, done chan struct{}, next func() (name, data string)) {
+	reader, writer := io.Pipe()
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.Header.Set("Accept", EventStreamContentType)
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		HandleHttpRequest(&pipeWriter{writer, http.Header{}}, request)
+		writer.Close()
+	}()
+
+	lines := bufio.NewReader(reader)
+	next = func() (name, data string) {
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && name != "":
+				return
+			case line == ":":
+				return ":", ""
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+	return
+}
+
+func TestEventStream(t *testing.T) {
+
+	Convey("Given an event stream of a collection", t, func() {
+		reset()
+		Changes = &changes.Feed{}
+		heartbeat, buffer := EventStreamHeartbeat, changes.SubscriptionBuffer
+		EventStreamHeartbeat = time.Hour
+		defer func() {
+			EventStreamHeartbeat, changes.SubscriptionBuffer = heartbeat, buffer
+		}()
+
+		serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice"})
+
+		Convey("The results should be sent first, followed by the changes", func() {
+			reader, _, next := stream("/users")
+			defer reader.Close()
+
+			name, data := next()
+			So(name, ShouldEqual, "results")
+			So(data, ShouldContainSubstring, "alice")
+
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob"})
+			name, data = next()
+			So(name, ShouldEqual, changes.Created)
+			So(data, ShouldContainSubstring, "bob")
+		})
+
+		Convey("Fields removed by the interceptors should not be streamed", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.AFTER_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				results, _ := dataprovider.Results(resp.Body)
+				for _, result := range results {
+					delete(result, "password")
+				}
+				return
+			}, nil)
+			reader, _, next := stream("/users")
+			defer reader.Close()
+			next()
+
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob", "password": "secret"})
+			name, data := next()
+			So(name, ShouldEqual, changes.Created)
+			So(data, ShouldContainSubstring, "bob")
+			So(data, ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("Heartbeats should be sent while there are no changes", func() {
+			EventStreamHeartbeat = 10 * time.Millisecond
+			reader, _, next := stream("/users")
+			defer reader.Close()
+
+			next()
+			name, _ := next()
+			So(name, ShouldEqual, ":")
+		})
+
+		Convey("Streams falling behind the changes should be ended with an error", func() {
+			changes.SubscriptionBuffer = 1
+			reader, done, next := stream("/users")
+			defer reader.Close()
+			next()
+
+			// the stream is not read, so it cannot keep up with the changes
+			for i := 0; i < 3; i++ {
+				serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob"})
+			}
+
+			name, data := next()
+			for name == changes.Created {
+				name, data = next()
+			}
+			So(name, ShouldEqual, "error")
+			So(data, ShouldContainSubstring, "410")
+			<-done
+		})
+
+		Convey("Streams which cannot be written should be unsubscribed", func() {
+			reader, done, next := stream("/users")
+			next()
+			reader.Close()
+
+			serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob"})
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+			}
+			So(Changes.Watches("users"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestAggregateInterceptors(t *testing.T) {
+
+	Convey("Given a collection with interceptors", t, func() {
+		reset()
+
+		serve(http.MethodPost, "/users", map[string]interface{}{"name": "alice", "team": "red"})
+		serve(http.MethodPost, "/users", map[string]interface{}{"name": "bob", "team": "blue"})
+		target := "/users/" + AggregateSegment + "?group=team"
+
+		Convey("Interceptors rejecting reads of the collection should reject its aggregations", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Users cannot be read."}
+				return
+			}, nil)
+			recorder, _ := serve(http.MethodGet, "/users", nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+			recorder, _ = serve(http.MethodGet, target, nil)
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Conditions added by the interceptors should apply to the aggregations", func() {
+			Interceptors.Add("/users", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				editedReq = req
+				editedReq.Parameters = map[string][]string{"where": {`{"team":"red"}`}}
+				for key, values := range req.Parameters {
+					if key != "where" {
+						editedReq.Parameters[key] = values
+					}
+				}
+				return
+			}, nil)
+			recorder, body := serve(http.MethodGet, target, nil)
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			results := body["results"].([]interface{})
+			So(len(results), ShouldEqual, 1)
+			So(results[0].(map[string]interface{})["team"], ShouldEqual, "red")
+		})
+	})
+}
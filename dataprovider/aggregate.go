@@ -0,0 +1,23 @@
+package dataprovider
+
+import (
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// Aggregator is implemented by providers which can group the documents and compute
+// values of the groups, like counts, sums and distinct values.
+type Aggregator interface {
+	Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error)
+}
+
+// Aggregate runs the aggregation on providers which implement Aggregator. Returns an
+// error with code 501 for the ones which don't. The results are in the results field.
+func Aggregate(provider Provider, collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	if aggregator, isAggregator := provider.(Aggregator); isAggregator {
+		return aggregator.Aggregate(collection, aggregation)
+	}
+	err = &utils.Error{Code: http.StatusNotImplemented, Message: "Data provider doesn't support aggregations."}
+	return
+}
@@ -0,0 +1,217 @@
+package dataprovider
+
+import (
+	"io"
+	"context"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// ContextProvider is implemented by providers which can cancel their
+// operations when the context of the request is done.
+type ContextProvider interface {
+	Provider
+	CreateContext(ctx context.Context, collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error)
+	GetContext(ctx context.Context, collection string, id string) (response map[string]interface{}, err *utils.Error)
+	QueryContext(ctx context.Context, collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error)
+	UpdateContext(ctx context.Context, collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error)
+	DeleteContext(ctx context.Context, collection string, id string) (response map[string]interface{}, err *utils.Error)
+	CreateFileContext(ctx context.Context, data io.ReadCloser) (response map[string]interface{}, err *utils.Error)
+	GetFileContext(ctx context.Context, id string) (response []byte, err *utils.Error)
+}
+
+// ContextBinder is implemented by providers which need the context for every call,
+// including the calls of the optional interfaces like StructuredQuerier.
+type ContextBinder interface {
+	BindContext(ctx context.Context) Provider
+}
+
+// WithContext binds the given context to the provider. Every call on the
+// returned provider fails with the error of the context once it is done.
+// If the provider implements ContextBinder, the provider it binds is used. If
+// it implements ContextProvider, the context is passed to it, otherwise the
+// context is only checked before the call.
+func WithContext(ctx context.Context, provider Provider) Provider {
+	if provider == nil {
+		return nil
+	}
+	if binder, isBinder := provider.(ContextBinder); isBinder {
+		provider = binder.BindContext(ctx)
+	}
+	return &contextBoundProvider{ctx, provider}
+}
+
+// Context returns the context the provider is bound to with WithContext.
+// Returns the background context if the provider is not bound to a context.
+func Context(provider Provider) context.Context {
+	if bound, isBound := provider.(*contextBoundProvider); isBound {
+		return bound.ctx
+	}
+	return context.Background()
+}
+
+type contextBoundProvider struct {
+	ctx      context.Context
+	provider Provider
+}
+
+func (cp *contextBoundProvider) Connect() (err *utils.Error) {
+	return cp.provider.Connect()
+}
+
+func (cp *contextBoundProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.CreateContext(cp.ctx, collection, data)
+	}
+	return cp.provider.Create(collection, data)
+}
+
+func (cp *contextBoundProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.GetContext(cp.ctx, collection, id)
+	}
+	return cp.provider.Get(collection, id)
+}
+
+func (cp *contextBoundProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.QueryContext(cp.ctx, collection, parameters)
+	}
+	return cp.provider.Query(collection, parameters)
+}
+
+// QueryStructured runs the query on providers which implement StructuredQuerier,
+// and passes it as parameters to the ones which don't.
+func (cp *contextBoundProvider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if _, isStructuredQuerier := cp.provider.(StructuredQuerier); isStructuredQuerier {
+		return QueryStructured(cp.provider, collection, q)
+	}
+	return cp.Query(collection, q.Parameters())
+}
+
+// Count counts on providers which implement Counter, and counts the results of a query
+// on the ones which don't.
+func (cp *contextBoundProvider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if counter, isCounter := cp.provider.(Counter); isCounter {
+		return counter.Count(collection, where)
+	}
+	return countResults(cp, collection, where)
+}
+
+func (cp *contextBoundProvider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	return Aggregate(cp.provider, collection, aggregation)
+}
+
+func (cp *contextBoundProvider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	return Search(cp.provider, collection, text, q)
+}
+
+func (cp *contextBoundProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.UpdateContext(cp.ctx, collection, id, data)
+	}
+	return cp.provider.Update(collection, id, data)
+}
+
+// Modify modifies on providers which implement Modifier, and gets and updates
+// the document on the ones which don't.
+func (cp *contextBoundProvider) Modify(collection string, id string, modify ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if modifier, isModifier := cp.provider.(Modifier); isModifier {
+		return modifier.Modify(collection, id, modify)
+	}
+	return getAndUpdate(cp, collection, id, modify)
+}
+
+func (cp *contextBoundProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.DeleteContext(cp.ctx, collection, id)
+	}
+	return cp.provider.Delete(collection, id)
+}
+
+// DeleteIf checks and deletes on providers which implement ConditionalDeleter, and gets,
+// checks and deletes the document on the ones which don't.
+func (cp *contextBoundProvider) DeleteIf(collection string, id string, check CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if deleter, isConditionalDeleter := cp.provider.(ConditionalDeleter); isConditionalDeleter {
+		return deleter.DeleteIf(collection, id, check)
+	}
+	return getAndDelete(cp, collection, id, check)
+}
+
+func (cp *contextBoundProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.CreateFileContext(cp.ctx, data)
+	}
+	return cp.provider.CreateFile(data)
+}
+
+func (cp *contextBoundProvider) GetFile(id string) (response []byte, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if provider, isContextProvider := cp.provider.(ContextProvider); isContextProvider {
+		return provider.GetFileContext(cp.ctx, id)
+	}
+	return cp.provider.GetFile(id)
+}
+
+// OpenFile opens the file on providers which implement FileStreamer, and
+// gets the whole file from the ones which don't.
+func (cp *contextBoundProvider) OpenFile(id string) (file files.File, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if streamer, isFileStreamer := cp.provider.(FileStreamer); isFileStreamer {
+		return streamer.OpenFile(id)
+	}
+	return getWholeFile(cp, id)
+}
+
+// CreateFileWithInfo creates the file with its metadata on providers which
+// implement FileInfoCreator, and without it on the ones which don't.
+func (cp *contextBoundProvider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	if err = utils.ContextError(cp.ctx.Err()); err != nil {
+		return
+	}
+	if creator, isFileInfoCreator := cp.provider.(FileInfoCreator); isFileInfoCreator {
+		return creator.CreateFileWithInfo(info, data)
+	}
+	return cp.CreateFile(data)
+}
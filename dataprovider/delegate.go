@@ -0,0 +1,93 @@
+package dataprovider
+
+import (
+	"io"
+	"context"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// Delegate passes every call to the provider it wraps, including the calls of the optional
+// interfaces. Providers which change some of the calls of another provider embed it and
+// implement only the calls they change.
+//
+// Delegate doesn't implement ContextBinder, since the provider it binds would lose the
+// changes of the embedding provider. Embedding providers which are bound to the contexts of
+// the requests implement BindContext with Bind.
+type Delegate struct {
+	Provider Provider
+}
+
+// Bind returns the delegate of the provider bound to the context, see WithContext.
+func (d Delegate) Bind(ctx context.Context) Delegate {
+	return Delegate{WithContext(ctx, d.Provider)}
+}
+
+func (d Delegate) Connect() (err *utils.Error) {
+	return d.Provider.Connect()
+}
+
+func (d Delegate) EnsureIndexes(indexes []Index) (err *utils.Error) {
+	return EnsureIndexes(d.Provider, indexes)
+}
+
+func (d Delegate) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.Create(collection, data)
+}
+
+func (d Delegate) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.Get(collection, id)
+}
+
+func (d Delegate) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.Query(collection, parameters)
+}
+
+func (d Delegate) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return QueryStructured(d.Provider, collection, q)
+}
+
+func (d Delegate) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return Count(d.Provider, collection, where)
+}
+
+func (d Delegate) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return Aggregate(d.Provider, collection, aggregation)
+}
+
+func (d Delegate) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return Search(d.Provider, collection, text, q)
+}
+
+func (d Delegate) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.Update(collection, id, data)
+}
+
+func (d Delegate) Modify(collection string, id string, modify ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	return Modify(d.Provider, collection, id, modify)
+}
+
+func (d Delegate) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.Delete(collection, id)
+}
+
+func (d Delegate) DeleteIf(collection string, id string, check CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return DeleteIf(d.Provider, collection, id, check)
+}
+
+func (d Delegate) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return d.Provider.CreateFile(data)
+}
+
+func (d Delegate) GetFile(id string) (response []byte, err *utils.Error) {
+	return d.Provider.GetFile(id)
+}
+
+func (d Delegate) OpenFile(id string) (file files.File, err *utils.Error) {
+	return OpenFile(d.Provider, id)
+}
+
+func (d Delegate) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return CreateFileWithInfo(d.Provider, info, data)
+}
@@ -0,0 +1,36 @@
+package dataprovider
+
+import (
+	"github.com/rihtim/core/utils"
+)
+
+// CheckFunc receives a copy of the current document and returns an error if it must not be deleted.
+type CheckFunc func(document map[string]interface{}) (err *utils.Error)
+
+// ConditionalDeleter is implemented by providers which can check and delete a document
+// atomically, ex: to delete it only if it has not been changed since it was read.
+type ConditionalDeleter interface {
+	DeleteIf(collection string, id string, check CheckFunc) (response map[string]interface{}, err *utils.Error)
+}
+
+// DeleteIf deletes the document if the check returns no error, on providers which implement
+// ConditionalDeleter. On the ones which don't, it gets, checks and deletes the document. The
+// fallback is atomic only if the provider is a transaction.
+func DeleteIf(provider Provider, collection string, id string, check CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if deleter, isConditionalDeleter := provider.(ConditionalDeleter); isConditionalDeleter {
+		return deleter.DeleteIf(collection, id, check)
+	}
+	return getAndDelete(provider, collection, id, check)
+}
+
+func getAndDelete(provider Provider, collection string, id string, check CheckFunc) (response map[string]interface{}, err *utils.Error) {
+
+	document, err := provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+	if err = check(document); err != nil {
+		return
+	}
+	return provider.Delete(collection, id)
+}
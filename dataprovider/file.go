@@ -0,0 +1,62 @@
+package dataprovider
+
+import (
+	"io"
+	"bytes"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/utils"
+)
+
+// Field names of the file metadata in the response of file creation.
+const (
+	FileNameField        = "name"
+	FileContentTypeField = "contentType"
+	FileSizeField        = "size"
+)
+
+// FileStreamer is implemented by providers which can open files for streaming
+// instead of loading them into memory.
+type FileStreamer interface {
+	OpenFile(id string) (file files.File, err *utils.Error)
+}
+
+// FileInfoCreator is implemented by providers which store the metadata
+// of files together with their content.
+type FileInfoCreator interface {
+	CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error)
+}
+
+// OpenFile opens the file on providers which implement FileStreamer. On the ones which
+// don't, it gets the whole file and detects its content type from the content.
+func OpenFile(provider Provider, id string) (file files.File, err *utils.Error) {
+	if streamer, isFileStreamer := provider.(FileStreamer); isFileStreamer {
+		return streamer.OpenFile(id)
+	}
+	return getWholeFile(provider, id)
+}
+
+// CreateFileWithInfo creates the file with its metadata on providers which implement
+// FileInfoCreator. The metadata is dropped on the ones which don't.
+func CreateFileWithInfo(provider Provider, info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	if creator, isFileInfoCreator := provider.(FileInfoCreator); isFileInfoCreator {
+		return creator.CreateFileWithInfo(info, data)
+	}
+	return provider.CreateFile(data)
+}
+
+func getWholeFile(provider Provider, id string) (file files.File, err *utils.Error) {
+
+	content, err := provider.GetFile(id)
+	if err != nil {
+		return
+	}
+	file = files.File{
+		Info: files.Info{
+			ContentType: http.DetectContentType(content),
+			Size:        int64(len(content)),
+		},
+		Content: bytes.NewReader(content),
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package dataprovider
+
+import (
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// Index is an index of a collection on one or more fields, ex: a unique index of the emails
+// of users. Fields of embedded objects are separated with dots, ex: address.city
+type Index struct {
+	Collection string
+	Fields     []string
+	Unique     bool
+}
+
+// IndexEnsurer is implemented by providers which create indexes in their storage. EnsureIndexes
+// creates the missing indexes and keeps the existing ones, since it is called every time the
+// provider connects. Writes violating a unique index fail with the Conflict error of the index.
+type IndexEnsurer interface {
+	EnsureIndexes(indexes []Index) (err *utils.Error)
+}
+
+// EnsureIndexes ensures the indexes on providers which implement IndexEnsurer. The ones
+// which don't are left as they are.
+func EnsureIndexes(provider Provider, indexes []Index) (err *utils.Error) {
+	if ensurer, isEnsurer := provider.(IndexEnsurer); isEnsurer {
+		return ensurer.EnsureIndexes(indexes)
+	}
+	return
+}
+
+// Name returns the name of the index, ex: users_email_unique
+func (i Index) Name() string {
+	name := i.Collection + "_" + strings.Join(i.Fields, "_")
+	if i.Unique {
+		name += "_unique"
+	}
+	return name
+}
+
+// Values returns the values of the fields of the index in the document. The values are not
+// complete if a field is missing or null; such documents are not checked for uniqueness.
+func (i Index) Values(document map[string]interface{}) (values []interface{}, complete bool) {
+	values = make([]interface{}, len(i.Fields))
+	for j, field := range i.Fields {
+		if values[j], _ = query.Lookup(document, field); values[j] == nil {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// Where returns the condition matching the documents with the given values of the fields.
+func (i Index) Where(values []interface{}) query.Condition {
+	conditions := make(query.And, len(i.Fields))
+	for j, field := range i.Fields {
+		conditions[j] = query.Comparison{Field: field, Operator: query.Equal, Value: values[j]}
+	}
+	return conditions
+}
+
+// Duplicates tells whether the documents have the same complete values of the fields.
+func (i Index) Duplicates(a, b map[string]interface{}) bool {
+	aValues, aComplete := i.Values(a)
+	bValues, bComplete := i.Values(b)
+	if !aComplete || !bComplete {
+		return false
+	}
+	for j := range aValues {
+		if !query.Equals(aValues[j], bValues[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Conflict returns the error of a document violating the unique index, with code 409
+// naming the fields.
+func (i Index) Conflict() *utils.Error {
+	message := "Value of '" + i.Fields[0] + "' must be unique in '" + i.Collection + "'."
+	if len(i.Fields) > 1 {
+		message = "Values of '" + strings.Join(i.Fields, "', '") + "' must be unique together in '" + i.Collection + "'."
+	}
+	return &utils.Error{
+		Code:    http.StatusConflict,
+		Message: message,
+		Details: map[string]interface{}{"index": i.Name(), "fields": i.Fields},
+	}
+}
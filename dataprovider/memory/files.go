@@ -0,0 +1,184 @@
+package memory
+
+import (
+	"io"
+	"time"
+	"bytes"
+	"net/http"
+	"io/ioutil"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// storedFile keeps the content of a file with its metadata. The content
+// is never modified after it is stored, so it is shared by the readers.
+type storedFile struct {
+	info      files.Info
+	content   []byte
+	createdAt time.Time
+}
+
+func (p *Provider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.CreateFileWithInfo(files.Info{Size: -1}, data)
+}
+
+func (p *Provider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+
+	id, file, err := readFile(info, data)
+	if err != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	p.init()
+	p.files[id] = file
+	p.mutex.Unlock()
+
+	response = fileResponse(id, file)
+	return
+}
+
+func (p *Provider) GetFile(id string) (response []byte, err *utils.Error) {
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	file, err := p.findFile(id)
+	if err != nil {
+		return
+	}
+	response = make([]byte, len(file.content))
+	copy(response, file.content)
+	return
+}
+
+func (p *Provider) OpenFile(id string) (file files.File, err *utils.Error) {
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	stored, err := p.findFile(id)
+	if err != nil {
+		return
+	}
+	file = stored.open()
+	return
+}
+
+// findFile returns the stored file. Callers must hold the lock.
+func (p *Provider) findFile(id string) (file storedFile, err *utils.Error) {
+	file, exists := p.files[id]
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "File not found."}
+	}
+	return
+}
+
+func (t *Transaction) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return t.CreateFileWithInfo(files.Info{Size: -1}, data)
+}
+
+func (t *Transaction) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+
+	id, file, err := readFile(info, data)
+	if err != nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+	t.files[id] = file
+
+	response = fileResponse(id, file)
+	return
+}
+
+func (t *Transaction) GetFile(id string) (response []byte, err *utils.Error) {
+
+	file, err := t.findFile(id)
+	if err != nil {
+		return
+	}
+	response = make([]byte, len(file.content))
+	copy(response, file.content)
+	return
+}
+
+func (t *Transaction) OpenFile(id string) (file files.File, err *utils.Error) {
+
+	stored, err := t.findFile(id)
+	if err != nil {
+		return
+	}
+	file = stored.open()
+	return
+}
+
+// findFile returns the file from the changes of the transaction or from the provider.
+func (t *Transaction) findFile(id string) (file storedFile, err *utils.Error) {
+
+	t.mutex.Lock()
+	file, exists := t.files[id]
+	err = t.checkDone()
+	t.mutex.Unlock()
+
+	if err != nil || exists {
+		return
+	}
+
+	t.provider.mutex.RLock()
+	defer t.provider.mutex.RUnlock()
+	return t.provider.findFile(id)
+}
+
+// readFile reads the whole content and completes the metadata from the content.
+func readFile(info files.Info, data io.ReadCloser) (id string, file storedFile, err *utils.Error) {
+
+	if data == nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "File content is missing."}
+		return
+	}
+	defer data.Close()
+
+	content, readErr := ioutil.ReadAll(data)
+	if readErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Reading file failed. Reason: " + readErr.Error()}
+		return
+	}
+
+	if id, err = generateId(); err != nil {
+		return
+	}
+
+	info.Size = int64(len(content))
+	if info.ContentType == "" {
+		info.ContentType = http.DetectContentType(content)
+	}
+	file = storedFile{info, content, time.Now()}
+	return
+}
+
+func (f storedFile) open() files.File {
+	return files.File{
+		Info:    f.info,
+		Content: bytes.NewReader(f.content),
+		ModTime: f.createdAt,
+	}
+}
+
+func fileResponse(id string, file storedFile) map[string]interface{} {
+	response := map[string]interface{}{
+		dataprovider.IdField:              id,
+		dataprovider.CreatedAtField:       file.createdAt,
+		dataprovider.FileContentTypeField: file.info.ContentType,
+		dataprovider.FileSizeField:        file.info.Size,
+	}
+	if file.info.Name != "" {
+		response[dataprovider.FileNameField] = file.info.Name
+	}
+	return response
+}
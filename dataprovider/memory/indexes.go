@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// EnsureIndexes makes the provider reject the writes violating the unique indexes with the
+// Conflict error of the index. Other indexes are ignored, since every query scans the whole
+// collection anyway. Fails if the stored documents already violate a unique index.
+func (p *Provider) EnsureIndexes(indexes []dataprovider.Index) (err *utils.Error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+
+	unique := make(map[string][]dataprovider.Index)
+	for _, index := range indexes {
+		if index.Unique {
+			unique[index.Collection] = append(unique[index.Collection], index)
+		}
+	}
+
+	for collection, indexes := range unique {
+		for _, document := range p.collections[collection] {
+			if err = violation(indexes, document, p.collections[collection]); err != nil {
+				return
+			}
+		}
+	}
+	p.unique = unique
+	return
+}
+
+// violation returns the conflict of the first index the document violates among the documents.
+// The document itself and nil documents, which are deleted in transactions, are skipped.
+func violation(indexes []dataprovider.Index, document map[string]interface{}, documents map[string]map[string]interface{}) *utils.Error {
+	id := document[dataprovider.IdField]
+	for _, index := range indexes {
+		for otherId, other := range documents {
+			if other != nil && otherId != id && index.Duplicates(document, other) {
+				return index.Conflict()
+			}
+		}
+	}
+	return nil
+}
+
+// violation checks the document against the documents of the collection with the changes of
+// the transaction. Callers must hold the lock of the transaction.
+func (t *Transaction) violation(collection string, document map[string]interface{}) *utils.Error {
+
+	t.provider.mutex.RLock()
+	defer t.provider.mutex.RUnlock()
+
+	if indexes := t.provider.unique[collection]; len(indexes) > 0 {
+		return violation(indexes, document, t.merged(collection))
+	}
+	return nil
+}
+
+// merged returns the stored documents of the collection with the changes of the transaction,
+// where deleted documents are nil. Callers must hold the locks of the provider and the transaction.
+func (t *Transaction) merged(collection string) map[string]map[string]interface{} {
+	documents := make(map[string]map[string]interface{}, len(t.provider.collections[collection]))
+	for id, document := range t.provider.collections[collection] {
+		documents[id] = document
+	}
+	for id, document := range t.documents[collection] {
+		documents[id] = document
+	}
+	return documents
+}
@@ -0,0 +1,270 @@
+package memory
+
+import (
+	"sync"
+	"time"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+var defaultSort = []query.SortField{{Field: dataprovider.CreatedAtField}, {Field: dataprovider.IdField}}
+
+// Provider is a dataprovider.Provider which keeps every collection and file
+// in process memory. It is safe for concurrent use and its zero value is
+// ready to use, which makes it suitable for local development and tests.
+//
+// Query accepts the parameters documented in the query package.
+type Provider struct {
+	mutex       sync.RWMutex
+	collections map[string]map[string]map[string]interface{}
+	files       map[string]storedFile
+	unique      map[string][]dataprovider.Index
+}
+
+func (p *Provider) Connect() (err *utils.Error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+	return
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	document, err := newDocument(data)
+	if err != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+
+	if err = violation(p.unique[collection], document, p.collections[collection]); err != nil {
+		return
+	}
+	if p.collections[collection] == nil {
+		p.collections[collection] = make(map[string]map[string]interface{})
+	}
+	p.collections[collection][document[dataprovider.IdField].(string)] = document
+
+	response = createResponse(document)
+	return
+}
+
+func (p *Provider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	document, err := p.find(collection, id)
+	if err != nil {
+		return
+	}
+	response = utils.CopyDocument(document)
+	return
+}
+
+func (p *Provider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+
+	q, err := query.Parse(parameters)
+	if err != nil {
+		return
+	}
+	return p.QueryStructured(collection, q)
+}
+
+func (p *Provider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+
+	response = applyQuery(p.documents(collection), q)
+	return
+}
+
+func (p *Provider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	response = map[string]interface{}{dataprovider.ResultsField: aggregation.Apply(p.documents(collection))}
+	return
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	document, err := p.find(collection, id)
+	if err != nil {
+		return
+	}
+
+	document = utils.CopyDocument(document)
+	applyUpdate(document, data)
+	if err = violation(p.unique[collection], document, p.collections[collection]); err != nil {
+		return
+	}
+	p.collections[collection][id] = document
+
+	response = updateResponse(document)
+	return
+}
+
+// Modify replaces the document with the modified one under the write lock.
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	document, err := p.find(collection, id)
+	if err != nil {
+		return
+	}
+
+	modified, err := modify(utils.CopyDocument(document))
+	if err != nil {
+		return
+	}
+
+	document = replaceDocument(document, modified)
+	if err = violation(p.unique[collection], document, p.collections[collection]); err != nil {
+		return
+	}
+	p.collections[collection][id] = document
+
+	response = updateResponse(document)
+	return
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, err = p.find(collection, id); err != nil {
+		return
+	}
+	delete(p.collections[collection], id)
+	return
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	document, err := p.find(collection, id)
+	if err != nil {
+		return
+	}
+	if err = check(utils.CopyDocument(document)); err != nil {
+		return
+	}
+	delete(p.collections[collection], id)
+	return
+}
+
+// init creates the storage maps. Callers must hold the write lock.
+func (p *Provider) init() {
+	if p.collections == nil {
+		p.collections = make(map[string]map[string]map[string]interface{})
+	}
+	if p.files == nil {
+		p.files = make(map[string]storedFile)
+	}
+}
+
+// find returns the stored document itself, not a copy. Callers must hold the lock.
+func (p *Provider) find(collection, id string) (document map[string]interface{}, err *utils.Error) {
+	document, exists := p.collections[collection][id]
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+	}
+	return
+}
+
+// newDocument copies the data and adds the generated fields.
+func newDocument(data map[string]interface{}) (document map[string]interface{}, err *utils.Error) {
+
+	id, err := generateId()
+	if err != nil {
+		return
+	}
+
+	document = utils.CopyDocument(data)
+	if document == nil {
+		document = make(map[string]interface{})
+	}
+	document[dataprovider.IdField] = id
+	document[dataprovider.CreatedAtField] = time.Now()
+	return
+}
+
+// applyUpdate sets the fields of data on the document.
+func applyUpdate(document, data map[string]interface{}) {
+	for key, value := range utils.CopyDocument(data) {
+		// generated fields cannot be changed by the client
+		if key == dataprovider.IdField || key == dataprovider.CreatedAtField {
+			continue
+		}
+		document[key] = value
+	}
+	document[dataprovider.UpdatedAtField] = time.Now()
+}
+
+// replaceDocument returns a copy of the modified document with the
+// generated fields of the current document and a new update time.
+func replaceDocument(current, modified map[string]interface{}) map[string]interface{} {
+	document := utils.CopyDocument(modified)
+	if document == nil {
+		document = make(map[string]interface{})
+	}
+	document[dataprovider.IdField] = current[dataprovider.IdField]
+	document[dataprovider.CreatedAtField] = current[dataprovider.CreatedAtField]
+	document[dataprovider.UpdatedAtField] = time.Now()
+	return document
+}
+
+func createResponse(document map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		dataprovider.IdField:        document[dataprovider.IdField],
+		dataprovider.CreatedAtField: document[dataprovider.CreatedAtField],
+	}
+}
+
+func updateResponse(document map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		dataprovider.UpdatedAtField: document[dataprovider.UpdatedAtField],
+	}
+}
+
+// documents returns copies of the documents of the collection.
+func (p *Provider) documents(collection string) []map[string]interface{} {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	documents := make([]map[string]interface{}, 0, len(p.collections[collection]))
+	for _, document := range p.collections[collection] {
+		documents = append(documents, utils.CopyDocument(document))
+	}
+	return documents
+}
+
+// applyQuery runs the query on the documents. The documents must be copies
+// of the stored ones since they are returned in the response. Documents are
+// ordered by creation as the last criteria to keep the results in a stable
+// order, since map iteration is random.
+func applyQuery(documents []map[string]interface{}, q query.Query) (response map[string]interface{}) {
+	query.SortDocuments(documents, defaultSort)
+	response = map[string]interface{}{dataprovider.ResultsField: q.Apply(documents)}
+	return
+}
+
+func generateId() (id string, err *utils.Error) {
+	bytes := make([]byte, 12)
+	if _, randErr := rand.Read(bytes); randErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Generating id failed. Reason: " + randErr.Error()}
+		return
+	}
+	id = hex.EncodeToString(bytes)
+	return
+}
@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/providertest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var _ dataprovider.TransactionalProvider = &Provider{}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, func() dataprovider.Provider { return &Provider{} })
+}
+
+func TestTransactionConformance(t *testing.T) {
+	providertest.Run(t, func() dataprovider.Provider {
+		transaction, _ := (&Provider{}).Begin(context.Background())
+		return transaction
+	})
+}
+
+func TestTransaction(t *testing.T) {
+
+	Convey("Given a memory provider with a document", t, func() {
+		provider := &Provider{}
+		created, _ := provider.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		transaction, err := provider.Begin(context.Background())
+		So(err, ShouldBeNil)
+
+		transaction.Update("users", id, map[string]interface{}{"name": "bob"})
+		transaction.Create("users", map[string]interface{}{"name": "carol"})
+
+		Convey("Changes should be visible only in the transaction", func() {
+			object, _ := transaction.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+
+			object, _ = provider.Get("users", id)
+			So(object["name"], ShouldEqual, "alice")
+
+			response, _ := provider.Query("users", nil)
+			So(len(response[dataprovider.ResultsField].([]map[string]interface{})), ShouldEqual, 1)
+		})
+
+		Convey("When the transaction is committed", func() {
+			So(transaction.Commit(), ShouldBeNil)
+
+			Convey("Changes should be applied to the provider", func() {
+				object, _ := provider.Get("users", id)
+				So(object["name"], ShouldEqual, "bob")
+
+				response, _ := provider.Query("users", nil)
+				So(len(response[dataprovider.ResultsField].([]map[string]interface{})), ShouldEqual, 2)
+			})
+
+			Convey("The transaction should not be usable anymore", func() {
+				_, err := transaction.Get("users", id)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the transaction is rolled back", func() {
+			So(transaction.Rollback(), ShouldBeNil)
+
+			Convey("Changes should be discarded", func() {
+				object, _ := provider.Get("users", id)
+				So(object["name"], ShouldEqual, "alice")
+
+				response, _ := provider.Query("users", nil)
+				So(len(response[dataprovider.ResultsField].([]map[string]interface{})), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestQuery(t *testing.T) {
+
+	Convey("Given a memory provider with documents", t, func() {
+		provider := &Provider{}
+		provider.Create("users", map[string]interface{}{"name": "alice", "age": 30.0})
+		provider.Create("users", map[string]interface{}{"name": "bob", "age": 25.0})
+		provider.Create("users", map[string]interface{}{"name": "carol", "age": 35.0})
+
+		Convey("When queried with a where parameter", func() {
+			response, err := provider.Query("users", map[string][]string{"where": {`{"name":"bob"}`}})
+
+			Convey("It should return only the matching documents", func() {
+				So(err, ShouldBeNil)
+				results := response[dataprovider.ResultsField].([]map[string]interface{})
+				So(len(results), ShouldEqual, 1)
+				So(results[0]["name"], ShouldEqual, "bob")
+			})
+		})
+
+		Convey("When queried with sort, skip and limit", func() {
+			response, err := provider.Query("users", map[string][]string{
+				"sort":  {"-age"},
+				"skip":  {"1"},
+				"limit": {"1"},
+			})
+
+			Convey("It should return the requested page in order", func() {
+				So(err, ShouldBeNil)
+				results := response[dataprovider.ResultsField].([]map[string]interface{})
+				So(len(results), ShouldEqual, 1)
+				So(results[0]["name"], ShouldEqual, "alice")
+			})
+		})
+
+		Convey("When queried with an invalid where parameter", func() {
+			_, err := provider.Query("users", map[string][]string{"where": {"{"}})
+
+			Convey("It should return bad request", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestUniqueIndexes(t *testing.T) {
+
+	Convey("Given a provider with a unique index", t, func() {
+		provider := &Provider{}
+		So(provider.EnsureIndexes([]dataprovider.Index{{Collection: "users", Fields: []string{"email"}, Unique: true}}), ShouldBeNil)
+
+		created, _ := provider.Create("users", map[string]interface{}{"email": "alice@example.com"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Creating a duplicate should return conflict", func() {
+			_, err := provider.Create("users", map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+			So(err.Message, ShouldContainSubstring, "'email'")
+		})
+
+		Convey("Updating to a duplicate should return conflict and keep the document", func() {
+			created, _ := provider.Create("users", map[string]interface{}{"email": "bob@example.com"})
+			other := created[dataprovider.IdField].(string)
+
+			_, err := provider.Update("users", other, map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			document, _ := provider.Get("users", other)
+			So(document["email"], ShouldEqual, "bob@example.com")
+		})
+
+		Convey("Updating a document with its own values should succeed", func() {
+			_, err := provider.Update("users", id, map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Documents without the field should not conflict", func() {
+			_, err := provider.Create("users", map[string]interface{}{"name": "carol"})
+			So(err, ShouldBeNil)
+			_, err = provider.Create("users", map[string]interface{}{"name": "dave"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("A transaction creating a duplicate should fail", func() {
+			transaction, _ := provider.Begin(context.Background())
+			_, err := transaction.Create("users", map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Committing a duplicate created after the transaction wrote should fail", func() {
+			transaction, _ := provider.Begin(context.Background())
+			_, err := transaction.Create("users", map[string]interface{}{"email": "bob@example.com"})
+			So(err, ShouldBeNil)
+
+			provider.Create("users", map[string]interface{}{"email": "bob@example.com"})
+
+			err = transaction.Commit()
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			response, _ := provider.Query("users", map[string][]string{"where": {`{"email":"bob@example.com"}`}})
+			So(len(response[dataprovider.ResultsField].([]map[string]interface{})), ShouldEqual, 1)
+		})
+
+		Convey("Ensuring an index which the documents violate should fail", func() {
+			provider.Create("users", map[string]interface{}{"name": "alice"})
+			provider.Create("users", map[string]interface{}{"name": "alice"})
+			err := provider.EnsureIndexes([]dataprovider.Index{{Collection: "users", Fields: []string{"name"}, Unique: true}})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+		})
+	})
+}
@@ -0,0 +1,313 @@
+package memory
+
+import (
+	"sync"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Begin starts a transaction. Changes made through the transaction are kept
+// aside and visible only to the transaction until they are committed. There
+// is no conflict detection; the last committed change of a document wins.
+func (p *Provider) Begin(ctx context.Context) (transaction dataprovider.Transaction, err *utils.Error) {
+	transaction = &Transaction{
+		provider:  p,
+		documents: make(map[string]map[string]map[string]interface{}),
+		files:     make(map[string]storedFile),
+	}
+	return
+}
+
+// Transaction is the dataprovider.Transaction of the memory provider.
+// A nil document in the changes means the document is deleted.
+type Transaction struct {
+	mutex     sync.Mutex
+	provider  *Provider
+	documents map[string]map[string]map[string]interface{}
+	files     map[string]storedFile
+	done      bool
+}
+
+func (t *Transaction) Connect() (err *utils.Error) {
+	return
+}
+
+func (t *Transaction) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	document, err := newDocument(data)
+	if err != nil {
+		return
+	}
+	if err = t.violation(collection, document); err != nil {
+		return
+	}
+	t.set(collection, document[dataprovider.IdField].(string), document)
+
+	response = createResponse(document)
+	return
+}
+
+func (t *Transaction) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	document, err := t.find(collection, id)
+	if err != nil {
+		return
+	}
+	response = utils.CopyDocument(document)
+	return
+}
+
+func (t *Transaction) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+
+	q, err := query.Parse(parameters)
+	if err != nil {
+		return
+	}
+	return t.QueryStructured(collection, q)
+}
+
+func (t *Transaction) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	response = applyQuery(t.collection(collection), q)
+	return
+}
+
+func (t *Transaction) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	response = map[string]interface{}{dataprovider.ResultsField: aggregation.Apply(t.collection(collection))}
+	return
+}
+
+func (t *Transaction) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	document, err := t.find(collection, id)
+	if err != nil {
+		return
+	}
+
+	document = utils.CopyDocument(document)
+	applyUpdate(document, data)
+	if err = t.violation(collection, document); err != nil {
+		return
+	}
+	t.set(collection, id, document)
+
+	response = updateResponse(document)
+	return
+}
+
+func (t *Transaction) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	document, err := t.find(collection, id)
+	if err != nil {
+		return
+	}
+
+	modified, err := modify(utils.CopyDocument(document))
+	if err != nil {
+		return
+	}
+
+	document = replaceDocument(document, modified)
+	if err = t.violation(collection, document); err != nil {
+		return
+	}
+	t.set(collection, id, document)
+
+	response = updateResponse(document)
+	return
+}
+
+func (t *Transaction) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	if _, err = t.find(collection, id); err != nil {
+		return
+	}
+	t.set(collection, id, nil)
+	return
+}
+
+func (t *Transaction) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+
+	document, err := t.find(collection, id)
+	if err != nil {
+		return
+	}
+	if err = check(utils.CopyDocument(document)); err != nil {
+		return
+	}
+	t.set(collection, id, nil)
+	return
+}
+
+// Commit applies the changes of the transaction to the provider. The changes are discarded if
+// they violate a unique index with the committed documents.
+func (t *Transaction) Commit() (err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+	t.done = true
+
+	p := t.provider
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+
+	// documents committed since the changes were made may violate the unique indexes
+	for collection, changes := range t.documents {
+		indexes := p.unique[collection]
+		if len(indexes) == 0 {
+			continue
+		}
+		documents := t.merged(collection)
+		for _, document := range changes {
+			if document == nil {
+				continue
+			}
+			if err = violation(indexes, document, documents); err != nil {
+				return
+			}
+		}
+	}
+
+	for collection, changes := range t.documents {
+		if p.collections[collection] == nil {
+			p.collections[collection] = make(map[string]map[string]interface{})
+		}
+		for id, document := range changes {
+			if document == nil {
+				delete(p.collections[collection], id)
+			} else {
+				p.collections[collection][id] = document
+			}
+		}
+	}
+	for id, file := range t.files {
+		p.files[id] = file
+	}
+	return
+}
+
+// Rollback discards the changes of the transaction.
+func (t *Transaction) Rollback() (err *utils.Error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err = t.checkDone(); err != nil {
+		return
+	}
+	t.done = true
+	t.documents = nil
+	t.files = nil
+	return
+}
+
+func (t *Transaction) checkDone() (err *utils.Error) {
+	if t.done {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Transaction is already committed or rolled back."}
+	}
+	return
+}
+
+// find returns the document from the changes of the transaction or a copy of the
+// stored document. Callers must hold the lock of the transaction.
+func (t *Transaction) find(collection, id string) (document map[string]interface{}, err *utils.Error) {
+
+	if document, changed := t.documents[collection][id]; changed {
+		if document == nil {
+			err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+		}
+		return document, err
+	}
+
+	t.provider.mutex.RLock()
+	defer t.provider.mutex.RUnlock()
+
+	document, err = t.provider.find(collection, id)
+	document = utils.CopyDocument(document)
+	return
+}
+
+// collection returns copies of the documents of the collection with the changes of the
+// transaction. Callers must hold the lock of the transaction.
+func (t *Transaction) collection(collection string) []map[string]interface{} {
+
+	changes := t.documents[collection]
+	documents := make([]map[string]interface{}, 0)
+
+	t.provider.mutex.RLock()
+	for id, document := range t.provider.collections[collection] {
+		if _, changed := changes[id]; !changed {
+			documents = append(documents, utils.CopyDocument(document))
+		}
+	}
+	t.provider.mutex.RUnlock()
+
+	for _, document := range changes {
+		if document != nil {
+			documents = append(documents, utils.CopyDocument(document))
+		}
+	}
+	return documents
+}
+
+func (t *Transaction) set(collection, id string, document map[string]interface{}) {
+	if t.documents[collection] == nil {
+		t.documents[collection] = make(map[string]map[string]interface{})
+	}
+	t.documents[collection][id] = document
+}
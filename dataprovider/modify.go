@@ -0,0 +1,73 @@
+package dataprovider
+
+import (
+	"reflect"
+	"github.com/rihtim/core/utils"
+)
+
+// ModifyFunc receives a copy of the current document and returns the new document.
+type ModifyFunc func(document map[string]interface{}) (modified map[string]interface{}, err *utils.Error)
+
+// Modifier is implemented by providers which can read and replace a document atomically.
+// The document returned by the modify function replaces the current document; the
+// generated fields are kept and the update time is set by the provider.
+type Modifier interface {
+	Modify(collection string, id string, modify ModifyFunc) (response map[string]interface{}, err *utils.Error)
+}
+
+// Modify modifies the document on providers which implement Modifier. On the ones which
+// don't, it gets the document and updates the changed fields, setting the removed ones
+// to nil. The fallback is atomic only if the provider is a transaction.
+func Modify(provider Provider, collection string, id string, modify ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if modifier, isModifier := provider.(Modifier); isModifier {
+		return modifier.Modify(collection, id, modify)
+	}
+	return getAndUpdate(provider, collection, id, modify)
+}
+
+// OnlyDeletedAtChanged tells whether the modification only soft deletes or restores the
+// document, by setting or removing its deletion time. Generated fields are not compared.
+func OnlyDeletedAtChanged(document, modified map[string]interface{}) bool {
+
+	if (document[DeletedAtField] == nil) == (modified[DeletedAtField] == nil) {
+		return false
+	}
+
+	// missing fields are compared as nil, since the fallback of Modify sets removed fields to nil
+	ignored := map[string]bool{IdField: true, CreatedAtField: true, UpdatedAtField: true, DeletedAtField: true}
+	for _, fields := range []map[string]interface{}{document, modified} {
+		for key := range fields {
+			if !ignored[key] && !reflect.DeepEqual(document[key], modified[key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func getAndUpdate(provider Provider, collection string, id string, modify ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+
+	document, err := provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+
+	modified, err := modify(document)
+	if err != nil {
+		return
+	}
+
+	changes := make(map[string]interface{})
+	for key, value := range modified {
+		changes[key] = value
+	}
+	for key := range document {
+		if _, exists := modified[key]; !exists {
+			changes[key] = nil
+		}
+	}
+	for _, generated := range []string{IdField, CreatedAtField, UpdatedAtField} {
+		delete(changes, generated)
+	}
+	return provider.Update(collection, id, changes)
+}
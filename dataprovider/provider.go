@@ -5,6 +5,18 @@ import (
 	"github.com/rihtim/core/utils"
 )
 
+// Field names providers use for the generated fields of a document
+// and for the result list of a query response.
+const (
+	IdField        = "_id"
+	CreatedAtField = "createdAt"
+	UpdatedAtField = "updatedAt"
+	ResultsField   = "results"
+)
+
+// DeletedAtField is set to the deletion time of the soft deleted documents.
+const DeletedAtField = "deletedAt"
+
 type Provider interface {
 	Connect() (err *utils.Error)
 	Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error)
@@ -0,0 +1,284 @@
+// Package providertest contains a behavioral test suite which every
+// dataprovider.Provider implementation is expected to pass. It pins down
+// the parts of the interface contract the request handlers of core rely on.
+//
+// Usage from a provider package:
+//
+//   func TestConformance(t *testing.T) {
+//       providertest.Run(t, func() dataprovider.Provider { return NewProvider() })
+//   }
+package providertest
+
+import (
+	"bytes"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Factory returns a connected-or-connectable provider with empty storage.
+// It is called once for every test case.
+type Factory func() dataprovider.Provider
+
+const collection = "providertest"
+
+// Run executes the whole suite against the providers created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("CreateGet", func(t *testing.T) { testCreateGet(t, factory) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, factory) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory) })
+	t.Run("Files", func(t *testing.T) { testFiles(t, factory) })
+	t.Run("Query", func(t *testing.T) { testQuery(t, factory) })
+}
+
+func connect(factory Factory) dataprovider.Provider {
+	provider := factory()
+	err := provider.Connect()
+	So(err, ShouldBeNil)
+	return provider
+}
+
+func create(provider dataprovider.Provider, data map[string]interface{}) string {
+	response, err := provider.Create(collection, data)
+	So(err, ShouldBeNil)
+	So(response, ShouldContainKey, dataprovider.IdField)
+	So(response, ShouldContainKey, dataprovider.CreatedAtField)
+
+	id, isString := response[dataprovider.IdField].(string)
+	So(isString, ShouldBeTrue)
+	So(id, ShouldNotBeEmpty)
+	return id
+}
+
+func results(response map[string]interface{}) []map[string]interface{} {
+	So(response, ShouldContainKey, dataprovider.ResultsField)
+	list, isList := response[dataprovider.ResultsField].([]map[string]interface{})
+	So(isList, ShouldBeTrue)
+	return list
+}
+
+func testCreateGet(t *testing.T, factory Factory) {
+
+	Convey("Given a provider", t, func() {
+		provider := connect(factory)
+
+		Convey("When an object is created", func() {
+			id := create(provider, map[string]interface{}{"name": "alice", "age": 30.0})
+
+			Convey("Get should return the object with its generated fields", func() {
+				object, err := provider.Get(collection, id)
+				So(err, ShouldBeNil)
+				So(object["name"], ShouldEqual, "alice")
+				So(object["age"], ShouldEqual, 30.0)
+				So(object[dataprovider.IdField], ShouldEqual, id)
+				So(object, ShouldContainKey, dataprovider.CreatedAtField)
+			})
+
+			Convey("Another create should generate a different id", func() {
+				So(create(provider, map[string]interface{}{"name": "bob"}), ShouldNotEqual, id)
+			})
+
+			Convey("Modifying the returned object should not change the stored object", func() {
+				object, _ := provider.Get(collection, id)
+				object["name"] = "mallory"
+
+				object, _ = provider.Get(collection, id)
+				So(object["name"], ShouldEqual, "alice")
+			})
+		})
+
+		Convey("Get with an unknown id should return not found", func() {
+			_, err := provider.Get(collection, "unknown")
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func testUpdate(t *testing.T, factory Factory) {
+
+	Convey("Given a provider with an object", t, func() {
+		provider := connect(factory)
+		id := create(provider, map[string]interface{}{"name": "alice", "age": 30.0})
+
+		Convey("When the object is updated", func() {
+			response, err := provider.Update(collection, id, map[string]interface{}{"age": 31.0, "city": "istanbul"})
+
+			Convey("It should return the update time", func() {
+				So(err, ShouldBeNil)
+				So(response, ShouldContainKey, dataprovider.UpdatedAtField)
+			})
+
+			Convey("Given fields should be replaced and added, other fields should be kept", func() {
+				object, err := provider.Get(collection, id)
+				So(err, ShouldBeNil)
+				So(object["name"], ShouldEqual, "alice")
+				So(object["age"], ShouldEqual, 31.0)
+				So(object["city"], ShouldEqual, "istanbul")
+				So(object, ShouldContainKey, dataprovider.UpdatedAtField)
+			})
+		})
+
+		Convey("When the update contains the id field", func() {
+			_, err := provider.Update(collection, id, map[string]interface{}{dataprovider.IdField: "other"})
+
+			Convey("The id should not change", func() {
+				So(err, ShouldBeNil)
+				object, err := provider.Get(collection, id)
+				So(err, ShouldBeNil)
+				So(object[dataprovider.IdField], ShouldEqual, id)
+			})
+		})
+
+		Convey("Update with an unknown id should return not found", func() {
+			_, err := provider.Update(collection, "unknown", map[string]interface{}{"age": 1.0})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func testDelete(t *testing.T, factory Factory) {
+
+	Convey("Given a provider with an object", t, func() {
+		provider := connect(factory)
+		id := create(provider, map[string]interface{}{"name": "alice"})
+
+		Convey("When the object is deleted", func() {
+			_, err := provider.Delete(collection, id)
+			So(err, ShouldBeNil)
+
+			Convey("Get should return not found", func() {
+				_, err := provider.Get(collection, id)
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("Deleting again should return not found", func() {
+				_, err := provider.Delete(collection, id)
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("Query should not return the object", func() {
+				response, err := provider.Query(collection, nil)
+				So(err, ShouldBeNil)
+				So(len(results(response)), ShouldEqual, 0)
+			})
+		})
+
+		Convey("Conditional deletes should delete the object only if the check passes", func() {
+			_, err := dataprovider.DeleteIf(provider, collection, id, func(document map[string]interface{}) *utils.Error {
+				if document["name"] != "bob" {
+					return &utils.Error{Code: http.StatusPreconditionFailed, Message: "Object has been modified."}
+				}
+				return nil
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusPreconditionFailed)
+
+			_, err = dataprovider.DeleteIf(provider, collection, id, func(document map[string]interface{}) *utils.Error {
+				return nil
+			})
+			So(err, ShouldBeNil)
+
+			_, err = provider.Get(collection, id)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func testFiles(t *testing.T, factory Factory) {
+
+	Convey("Given a provider", t, func() {
+		provider := connect(factory)
+
+		Convey("When a file is created", func() {
+			content := []byte("some file content")
+			response, err := provider.CreateFile(ioutil.NopCloser(bytes.NewReader(content)))
+			So(err, ShouldBeNil)
+			So(response, ShouldContainKey, dataprovider.IdField)
+			id, _ := response[dataprovider.IdField].(string)
+
+			Convey("GetFile should return the same content", func() {
+				file, err := provider.GetFile(id)
+				So(err, ShouldBeNil)
+				So(string(file), ShouldEqual, string(content))
+			})
+		})
+
+		Convey("GetFile with an unknown id should return not found", func() {
+			_, err := provider.GetFile("unknown")
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func testQuery(t *testing.T, factory Factory) {
+
+	Convey("Given a provider with objects", t, func() {
+		provider := connect(factory)
+		create(provider, map[string]interface{}{"name": "alice", "team": "red", "age": 30.0})
+		create(provider, map[string]interface{}{"name": "bob", "team": "blue", "age": 25.0})
+		create(provider, map[string]interface{}{"name": "carol", "team": "red", "age": 35.0})
+
+		Convey("Query without parameters should return all objects", func() {
+			response, err := provider.Query(collection, nil)
+			So(err, ShouldBeNil)
+			So(len(results(response)), ShouldEqual, 3)
+		})
+
+		Convey("Query with a where parameter should return matching objects", func() {
+			response, err := provider.Query(collection, map[string][]string{"where": {`{"team":"red"}`}})
+			So(err, ShouldBeNil)
+
+			list := results(response)
+			So(len(list), ShouldEqual, 2)
+			for _, object := range list {
+				So(object["team"], ShouldEqual, "red")
+			}
+		})
+
+		Convey("Query with operators should return matching objects", func() {
+			response, err := provider.Query(collection, map[string][]string{"where": {`{"age":{"$gte":30},"$or":[{"name":"bob"},{"name":"carol"}]}`}})
+			So(err, ShouldBeNil)
+
+			list := results(response)
+			So(len(list), ShouldEqual, 1)
+			So(list[0]["name"], ShouldEqual, "carol")
+		})
+
+		Convey("Query with a limit should return at most that many objects", func() {
+			response, err := provider.Query(collection, map[string][]string{"limit": {"2"}})
+			So(err, ShouldBeNil)
+			So(len(results(response)), ShouldEqual, 2)
+		})
+
+		Convey("Query with sort should order the objects", func() {
+			response, err := provider.Query(collection, map[string][]string{"sort": {"-name"}})
+			So(err, ShouldBeNil)
+
+			list := results(response)
+			So(len(list), ShouldEqual, 3)
+			So(list[0]["name"], ShouldEqual, "carol")
+			So(list[2]["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Query on an unknown collection should return an empty list", func() {
+			response, err := provider.Query("unknown", nil)
+			So(err, ShouldBeNil)
+			So(len(results(response)), ShouldEqual, 0)
+		})
+
+		Convey("Query with a malformed where parameter should return bad request", func() {
+			_, err := provider.Query(collection, map[string][]string{"where": {"{"}})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
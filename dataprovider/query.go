@@ -0,0 +1,70 @@
+package dataprovider
+
+import (
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// StructuredQuerier is implemented by providers which run parsed queries
+// instead of interpreting the query parameters themselves.
+type StructuredQuerier interface {
+	QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error)
+}
+
+// Counter is implemented by providers which can count the documents
+// matching a condition without fetching them.
+type Counter interface {
+	Count(collection string, where query.Condition) (count int, err *utils.Error)
+}
+
+// QueryStructured runs the query on providers which implement StructuredQuerier,
+// and passes it as parameters to the ones which don't.
+func QueryStructured(provider Provider, collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if querier, isStructuredQuerier := provider.(StructuredQuerier); isStructuredQuerier {
+		return querier.QueryStructured(collection, q)
+	}
+	return provider.Query(collection, q.Parameters())
+}
+
+// Count counts the documents matching the condition on providers which implement
+// Counter, and counts the results of a query on the ones which don't.
+func Count(provider Provider, collection string, where query.Condition) (count int, err *utils.Error) {
+	if counter, isCounter := provider.(Counter); isCounter {
+		return counter.Count(collection, where)
+	}
+	return countResults(provider, collection, where)
+}
+
+func countResults(provider Provider, collection string, where query.Condition) (count int, err *utils.Error) {
+
+	q := query.New()
+	q.Where = where
+	q.Fields = []string{IdField}
+
+	response, err := QueryStructured(provider, collection, q)
+	if err != nil {
+		return
+	}
+	results, _ := Results(response)
+	count = len(results)
+	return
+}
+
+// Results returns the result list of a query response. Returns false
+// if the response doesn't contain a list of documents.
+func Results(response map[string]interface{}) (results []map[string]interface{}, isList bool) {
+
+	switch list := response[ResultsField].(type) {
+	case []map[string]interface{}:
+		return list, true
+	case []interface{}:
+		results = make([]map[string]interface{}, len(list))
+		for i, item := range list {
+			if results[i], isList = item.(map[string]interface{}); !isList {
+				return nil, false
+			}
+		}
+		return results, true
+	}
+	return
+}
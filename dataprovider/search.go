@@ -0,0 +1,31 @@
+package dataprovider
+
+import (
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// Fields added to the results of full-text searches.
+const (
+	ScoreField      = "_score"
+	HighlightsField = "_highlights"
+)
+
+// Searcher is implemented by providers which can search the texts of the documents. Search
+// returns the documents matching the text and the where condition of the query, the most
+// relevant first unless the query is sorted. Every result has its relevance in ScoreField
+// and the fragments of its fields matching the text by the fields in HighlightsField.
+type Searcher interface {
+	Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error)
+}
+
+// Search runs the search on providers which implement Searcher. Returns an error with code
+// 501 for the ones which don't. The results are in the results field.
+func Search(provider Provider, collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if searcher, isSearcher := provider.(Searcher); isSearcher {
+		return searcher.Search(collection, text, q)
+	}
+	err = &utils.Error{Code: http.StatusNotImplemented, Message: "Data provider doesn't support full-text search."}
+	return
+}
@@ -0,0 +1,21 @@
+package dataprovider
+
+import (
+	"context"
+	"github.com/rihtim/core/utils"
+)
+
+// TransactionalProvider is implemented by providers which can group
+// multiple operations into a single transaction.
+type TransactionalProvider interface {
+	Provider
+	Begin(ctx context.Context) (transaction Transaction, err *utils.Error)
+}
+
+// Transaction is a Provider whose operations are applied only after
+// Commit is called. Operations made after Commit or Rollback fail.
+type Transaction interface {
+	Provider
+	Commit() (err *utils.Error)
+	Rollback() (err *utils.Error)
+}
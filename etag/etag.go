@@ -0,0 +1,62 @@
+// Package etag computes entity tags of documents and evaluates the
+// If-Match and If-None-Match conditional request headers against them.
+package etag
+
+import (
+	"strings"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Header names used for conditional requests.
+const (
+	ETagHeader        = "ETag"
+	IfMatchHeader     = "If-Match"
+	IfNoneMatchHeader = "If-None-Match"
+)
+
+// Compute returns the strong entity tag of the document, which is derived from
+// its content. Since documents carry their update time, the tag changes on every
+// update even if the fields are set to the same values.
+func Compute(document map[string]interface{}) string {
+	// json.Marshal sorts the keys of maps, so the same content gives the same tag
+	bytes, _ := json.Marshal(document)
+	sum := sha1.Sum(bytes)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// MatchesIfMatch tells whether the value of an If-Match header matches the tag.
+// Uses strong comparison, so weak tags in the header never match.
+func MatchesIfMatch(header, tag string) bool {
+	for _, candidate := range split(header) {
+		if candidate == "*" || (!isWeak(candidate) && candidate == tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesIfNoneMatch tells whether the value of an If-None-Match header matches
+// the tag, in which case the request condition fails. Uses weak comparison.
+func MatchesIfNoneMatch(header, tag string) bool {
+	for _, candidate := range split(header) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeak(tag string) bool {
+	return strings.HasPrefix(tag, "W/")
+}
+
+func split(header string) (tags []string) {
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return
+}
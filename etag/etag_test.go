@@ -0,0 +1,33 @@
+package etag
+
+import (
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestETag(t *testing.T) {
+
+	Convey("Given a document and its tag", t, func() {
+		document := map[string]interface{}{"name": "alice", "age": 30.0}
+		tag := Compute(document)
+
+		Convey("The tag should depend only on the content", func() {
+			So(Compute(map[string]interface{}{"age": 30.0, "name": "alice"}), ShouldEqual, tag)
+			So(Compute(map[string]interface{}{"age": 31.0, "name": "alice"}), ShouldNotEqual, tag)
+		})
+
+		Convey("If-Match should use strong comparison", func() {
+			So(MatchesIfMatch(tag, tag), ShouldBeTrue)
+			So(MatchesIfMatch(`"other", `+tag, tag), ShouldBeTrue)
+			So(MatchesIfMatch("*", tag), ShouldBeTrue)
+			So(MatchesIfMatch("W/"+tag, tag), ShouldBeFalse)
+			So(MatchesIfMatch(`"other"`, tag), ShouldBeFalse)
+		})
+
+		Convey("If-None-Match should use weak comparison", func() {
+			So(MatchesIfNoneMatch("W/"+tag, tag), ShouldBeTrue)
+			So(MatchesIfNoneMatch("*", tag), ShouldBeTrue)
+			So(MatchesIfNoneMatch(`"other"`, tag), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"io"
+	"time"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+)
+
+const EventStreamContentType = "text/event-stream"
+
+// EventStreamHeartbeat is the interval of the comments sent to keep idle event streams open
+// through proxies.
+var EventStreamHeartbeat = 15 * time.Second
+
+// acceptsEventStream tells whether the request is a GET asking for an event stream.
+func acceptsEventStream(r *http.Request) bool {
+	if !strings.EqualFold(r.Method, methods.Get) {
+		return false
+	}
+	for _, value := range r.Header["Accept"] {
+		for _, mediaType := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(strings.Split(mediaType, ";")[0]), EventStreamContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleEventStream serves a GET as Server-Sent Events. The response of the request is sent as a
+// 'results' event, followed by a 'created', 'updated' or 'deleted' event for every change of the
+// objects the request reads, until the client disconnects. The documents of the events go through
+// the fields parameter and the AFTER_EXEC interceptors of the request like the response does. A
+// stream which falls behind the changes is ended with an 'error' event. The subscription is
+// closed once a write fails.
+func handleEventStream(w http.ResponseWriter, r *http.Request, request messages.Message) {
+
+	flusher, isFlusher := w.(http.Flusher)
+	if !isFlusher {
+		printError(w, &utils.Error{Code: http.StatusNotAcceptable, Message: "Event streams are not supported by the server."})
+		return
+	}
+
+	response, subscription, filter, err := subscribe(r.Context(), request, requestscope.Init())
+	if err != nil || subscription == nil {
+		buildResponse(w, r, response, err)
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", EventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if writeEvent(w, "results", response.Body) != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(EventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var writeErr error
+		select {
+		case event, open := <-subscription.Events():
+			if !open {
+				writeEvent(w, "error", errorBody(&utils.Error{Code: http.StatusGone, Message: "Subscription fell behind the changes and is ended."}))
+				flusher.Flush()
+				return
+			}
+			event, send := filter(event)
+			if !send {
+				continue
+			}
+			writeErr = writeEvent(w, event.Type, event)
+		case <-heartbeat.C:
+			_, writeErr = io.WriteString(w, ":\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if writeErr != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event with JSON data. Encoded JSON has no line breaks, so it fits a single data line.
+func writeEvent(w io.Writer, name string, data interface{}) (err error) {
+	encoded, _ := json.Marshal(data)
+	_, err = io.WriteString(w, "event: "+name+"\ndata: "+string(encoded)+"\n\n")
+	return
+}
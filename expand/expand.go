@@ -0,0 +1,316 @@
+// Package expand embeds the documents referenced by the fields of documents into them,
+// ex: ?expand=author,comments.author replaces the author id of a post with the author,
+// and adds the comments of the post with their authors.
+//
+// Expansions are batched: every level of an expansion costs a single query, however
+// many documents are expanded. Relations embed at most the expand limit of the relation
+// of children in each document; a level costs another query for each document which
+// has more children than the limit.
+//
+// The fields of the expanded documents are selected with dots in the fields parameter,
+// ex: ?expand=author&fields=title,author.name
+package expand
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/dataprovider"
+)
+
+const Parameter = "expand"
+
+// MaxDepth is the maximum number of levels in an expansion, ex: comments.author has two levels.
+var MaxDepth = 3
+
+// DefaultLimit is the maximum number of children embedded in a document by the relations
+// without an expand limit.
+var DefaultLimit = 100
+
+// Filter is called with the documents read from a collection before they are expanded and
+// embedded, ex: to run the interceptors of the collection on them. The returned documents
+// are embedded; the documents it leaves out are treated as missing.
+type Filter func(collection string, documents []map[string]interface{}) (filtered []map[string]interface{}, err *utils.Error)
+
+// Tree keeps the expansions by the name of the field, with the expansions of the embedded documents.
+type Tree map[string]Tree
+
+// Parse parses the values of the expand parameter. Returns an error with code 400 if an
+// expansion is deeper than MaxDepth.
+func Parse(values []string) (tree Tree, err *utils.Error) {
+	for _, value := range values {
+		for _, expansion := range query.ParseList(value) {
+			path := strings.Split(expansion, ".")
+			if len(path) > MaxDepth {
+				err = &utils.Error{Code: http.StatusBadRequest, Message: "Expansion '" + expansion + "' exceeds the maximum depth of " + strconv.Itoa(MaxDepth) + "."}
+				return
+			}
+			if tree == nil {
+				tree = make(Tree)
+			}
+			node := tree
+			for _, name := range path {
+				if node[name] == nil {
+					node[name] = make(Tree)
+				}
+				node = node[name]
+			}
+		}
+	}
+	return
+}
+
+// Names returns the names of the expanded fields in order.
+func (t Tree) Names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Fields returns the fields the documents must have to be expanded.
+func (t Tree) Fields() []string {
+	if len(t) == 0 {
+		return nil
+	}
+	return append([]string{dataprovider.IdField}, t.Names()...)
+}
+
+// Own returns the fields which are not fields of the expanded documents, ex: title and
+// not author.name if author is expanded.
+func (t Tree) Own(fields []string) (own []string) {
+	for _, field := range fields {
+		if _, isExpanded := t[strings.Split(field, ".")[0]]; !isExpanded || !strings.Contains(field, ".") {
+			own = append(own, field)
+		}
+	}
+	return
+}
+
+// Project returns a new document with the given fields and the expanded fields. Expanded
+// documents are projected to their fields in the given fields, ex: author.name, and are
+// kept whole if none of their fields are given. Embedded lists are projected by item.
+func (t Tree) Project(document map[string]interface{}, fields []string) map[string]interface{} {
+
+	nested := make(map[string][]string)
+	for _, field := range fields {
+		if parts := strings.SplitN(field, ".", 2); len(parts) == 2 && t[parts[0]] != nil {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+
+	projected := query.Project(document, append(t.Own(fields), t.Names()...))
+	for name, embeddedFields := range nested {
+		switch value := projected[name].(type) {
+		case map[string]interface{}:
+			projected[name] = t[name].Project(value, embeddedFields)
+		case []interface{}:
+			list := make([]interface{}, len(value))
+			for i, item := range value {
+				if embedded, isDocument := item.(map[string]interface{}); isDocument {
+					list[i] = t[name].Project(embedded, embeddedFields)
+				} else {
+					list[i] = item
+				}
+			}
+			projected[name] = list
+		}
+	}
+	return projected
+}
+
+// Documents expands the documents of the collection in place. A name is expanded with the
+// reference of the collection with that field, or else with the relation of that name, whose
+// children are embedded as a list. Returns an error with code 400 if there is neither. The
+// documents read from the collections are passed to the filter if it is not nil.
+func Documents(provider dataprovider.Provider, registry *relations.Registry, collection string, documents []map[string]interface{}, tree Tree, filter Filter) (err *utils.Error) {
+
+	if len(documents) == 0 {
+		return
+	}
+
+	for _, name := range tree.Names() {
+		if reference, isReference := registry.GetReference(collection, name); isReference {
+			err = expandReference(provider, registry, reference, documents, tree[name], filter)
+		} else if relation, isRelation := registry.Get(collection, name); isRelation {
+			err = expandRelation(provider, registry, relation, documents, tree[name], filter)
+		} else {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Field '" + name + "' of '" + collection + "' cannot be expanded."}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// expandReference replaces the ids in the reference field with the referenced documents.
+// Ids of missing documents are replaced with nil, or removed from the lists.
+func expandReference(provider dataprovider.Provider, registry *relations.Registry, reference relations.Reference, documents []map[string]interface{}, tree Tree, filter Filter) (err *utils.Error) {
+
+	ids := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, document := range documents {
+		for _, id := range referencedIds(document[reference.Field]) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	referenced, err := find(provider, registry, reference.Target, query.Comparison{Field: dataprovider.IdField, Operator: query.In, Value: ids}, tree, filter)
+	if err != nil {
+		return
+	}
+	byId := make(map[string]map[string]interface{}, len(referenced))
+	for _, document := range referenced {
+		if id, isString := document[dataprovider.IdField].(string); isString {
+			byId[id] = document
+		}
+	}
+
+	for _, document := range documents {
+		switch value := document[reference.Field].(type) {
+		case string:
+			if embedded, found := byId[value]; found {
+				document[reference.Field] = embedded
+			} else {
+				document[reference.Field] = nil
+			}
+		case []interface{}:
+			list := make([]interface{}, 0, len(value))
+			for _, id := range referencedIds(value) {
+				if embedded, found := byId[id]; found {
+					list = append(list, embedded)
+				}
+			}
+			document[reference.Field] = list
+		}
+	}
+	return
+}
+
+// expandRelation embeds the children of the documents as a list under the name of the relation.
+// Children are read in the order of their ids, at most the limit of the relation times the
+// documents at once. If a query reads that many, the documents after the last one reached are
+// queried again, since their children may be missing.
+func expandRelation(provider dataprovider.Provider, registry *relations.Registry, relation relations.Relation, documents []map[string]interface{}, tree Tree, filter Filter) (err *utils.Error) {
+
+	limit := relation.ExpandLimit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	ids := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		if id, isString := document[dataprovider.IdField].(string); isString {
+			ids = append(ids, id)
+		}
+	}
+
+	byParent := make(map[string][]interface{})
+	for len(ids) > 0 {
+		q := query.New()
+		q.Where = query.Comparison{Field: relation.ForeignKey, Operator: query.In, Value: ids}
+		q.Sort = []query.SortField{{Field: relation.ForeignKey}, {Field: dataprovider.IdField}}
+		q.Limit = limit * len(ids)
+
+		response, queryErr := dataprovider.QueryStructured(provider, relation.ChildCollection(), q)
+		if queryErr != nil {
+			return queryErr
+		}
+		children, _ := dataprovider.Results(response)
+
+		// the parents are taken before the children are filtered
+		count := len(children)
+		read := make(map[string]int)
+		var last interface{}
+		for _, child := range children {
+			last = child[relation.ForeignKey]
+			if parentId, isString := last.(string); isString {
+				read[parentId]++
+			}
+		}
+
+		if children, err = embed(provider, registry, relation.ChildCollection(), children, tree, filter); err != nil {
+			return
+		}
+		for _, child := range children {
+			if parentId, isString := child[relation.ForeignKey].(string); isString && len(byParent[parentId]) < limit {
+				byParent[parentId] = append(byParent[parentId], child)
+			}
+		}
+		if count < q.Limit {
+			break
+		}
+
+		// the last parent reached is complete if the limit of its children is read
+		remaining := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			if result := query.Compare(id, last); result > 0 || (result == 0 && read[id.(string)] < limit) {
+				remaining = append(remaining, id)
+			}
+		}
+		ids = remaining
+	}
+
+	for _, document := range documents {
+		id, _ := document[dataprovider.IdField].(string)
+		if list := byParent[id]; list != nil {
+			document[relation.Name] = list
+		} else {
+			document[relation.Name] = []interface{}{}
+		}
+	}
+	return
+}
+
+// find queries the documents matching the condition and embeds them.
+func find(provider dataprovider.Provider, registry *relations.Registry, collection string, where query.Condition, tree Tree, filter Filter) (documents []map[string]interface{}, err *utils.Error) {
+
+	q := query.New()
+	q.Where = where
+	response, err := dataprovider.QueryStructured(provider, collection, q)
+	if err != nil {
+		return
+	}
+	documents, _ = dataprovider.Results(response)
+	return embed(provider, registry, collection, documents, tree, filter)
+}
+
+// embed passes the documents read from the collection to the filter and expands them with the tree.
+func embed(provider dataprovider.Provider, registry *relations.Registry, collection string, documents []map[string]interface{}, tree Tree, filter Filter) (embedded []map[string]interface{}, err *utils.Error) {
+
+	embedded = documents
+	if filter != nil && len(documents) > 0 {
+		if embedded, err = filter(collection, documents); err != nil {
+			return
+		}
+	}
+	err = Documents(provider, registry, collection, embedded, tree, filter)
+	return
+}
+
+func referencedIds(value interface{}) (ids []string) {
+	switch v := value.(type) {
+	case string:
+		ids = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if id, isString := item.(string); isString {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return
+}
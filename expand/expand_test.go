@@ -0,0 +1,217 @@
+package expand
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingProvider counts the queries to check that expansions are batched.
+type countingProvider struct {
+	*memory.Provider
+	queries int
+}
+
+func (cp *countingProvider) QueryStructured(collection string, q query.Query) (map[string]interface{}, *utils.Error) {
+	cp.queries++
+	return cp.Provider.QueryStructured(collection, q)
+}
+
+func create(provider dataprovider.Provider, collection string, data map[string]interface{}) string {
+	response, err := provider.Create(collection, data)
+	So(err, ShouldBeNil)
+	return response[dataprovider.IdField].(string)
+}
+
+func TestParse(t *testing.T) {
+
+	Convey("Parse should build the tree of expansions", t, func() {
+		tree, err := Parse([]string{"author, comments.author", "comments.likes"})
+		So(err, ShouldBeNil)
+		So(tree, ShouldResemble, Tree{"author": Tree{}, "comments": Tree{"author": Tree{}, "likes": Tree{}}})
+		So(tree.Names(), ShouldResemble, []string{"author", "comments"})
+		So(tree.Fields(), ShouldResemble, []string{dataprovider.IdField, "author", "comments"})
+	})
+
+	Convey("Parse should reject expansions deeper than the maximum depth", t, func() {
+		_, err := Parse([]string{"a.b.c.d"})
+		So(err, ShouldNotBeNil)
+		So(err.Code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Parse without values should return an empty tree", t, func() {
+		tree, err := Parse(nil)
+		So(err, ShouldBeNil)
+		So(len(tree), ShouldEqual, 0)
+		So(tree.Fields(), ShouldBeNil)
+	})
+}
+
+func TestDocuments(t *testing.T) {
+
+	Convey("Given posts with authors and comments", t, func() {
+		provider := &countingProvider{Provider: &memory.Provider{}}
+		registry := relations.NewRegistry(relations.Relation{Parent: "posts", Name: "comments", ForeignKey: "postId"})
+		registry.AddReference(relations.Reference{Collection: "posts", Field: "author", Target: "users"})
+		registry.AddReference(relations.Reference{Collection: "comments", Field: "author", Target: "users"})
+		registry.AddReference(relations.Reference{Collection: "posts", Field: "editors", Target: "users"})
+
+		alice := create(provider, "users", map[string]interface{}{"name": "alice"})
+		bob := create(provider, "users", map[string]interface{}{"name": "bob"})
+		first := create(provider, "posts", map[string]interface{}{"title": "first", "author": alice, "editors": []interface{}{bob, "missing"}})
+		second := create(provider, "posts", map[string]interface{}{"title": "second", "author": "missing"})
+		create(provider, "comments", map[string]interface{}{"postId": first, "author": bob})
+		create(provider, "comments", map[string]interface{}{"postId": first, "author": alice})
+
+		posts := []map[string]interface{}{}
+		for _, id := range []string{first, second} {
+			post, _ := provider.Get("posts", id)
+			posts = append(posts, post)
+		}
+
+		Convey("References should be replaced with the referenced documents", func() {
+			tree, _ := Parse([]string{"author,editors"})
+			So(Documents(provider, registry, "posts", posts, tree, nil), ShouldBeNil)
+
+			So(posts[0]["author"].(map[string]interface{})["name"], ShouldEqual, "alice")
+			So(posts[1]["author"], ShouldBeNil)
+
+			editors := posts[0]["editors"].([]interface{})
+			So(len(editors), ShouldEqual, 1)
+			So(editors[0].(map[string]interface{})["name"], ShouldEqual, "bob")
+		})
+
+		Convey("Relations should embed the children, expanded in a query per level", func() {
+			tree, _ := Parse([]string{"comments.author"})
+			provider.queries = 0
+			So(Documents(provider, registry, "posts", posts, tree, nil), ShouldBeNil)
+			So(provider.queries, ShouldEqual, 2)
+
+			comments := posts[0]["comments"].([]interface{})
+			So(len(comments), ShouldEqual, 2)
+			for _, comment := range comments {
+				So(comment.(map[string]interface{})["author"], ShouldHaveSameTypeAs, map[string]interface{}{})
+			}
+			So(posts[1]["comments"], ShouldBeEmpty)
+		})
+
+		Convey("Unknown fields should not be expanded", func() {
+			tree, _ := Parse([]string{"title"})
+			err := Documents(provider, registry, "posts", posts, tree, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestLimit(t *testing.T) {
+
+	Convey("Given users with different numbers of books", t, func() {
+		provider := &countingProvider{Provider: &memory.Provider{}}
+		registry := relations.NewRegistry(relations.Relation{Parent: "users", Name: "books", ForeignKey: "userId", ExpandLimit: 2})
+
+		users := []map[string]interface{}{}
+		for _, books := range []int{5, 1, 0, 3} {
+			user, _ := provider.Get("users", create(provider, "users", map[string]interface{}{}))
+			for i := 0; i < books; i++ {
+				create(provider, "books", map[string]interface{}{"userId": user["_id"]})
+			}
+			users = append(users, user)
+		}
+		tree, _ := Parse([]string{"books"})
+
+		Convey("At most the limit of children should be embedded in each document", func() {
+			So(Documents(provider, registry, "users", users, tree, nil), ShouldBeNil)
+			So(users[0]["books"], ShouldHaveLength, 2)
+			So(users[1]["books"], ShouldHaveLength, 1)
+			So(users[2]["books"], ShouldHaveLength, 0)
+			So(users[3]["books"], ShouldHaveLength, 2)
+		})
+
+		Convey("The default limit should be used for relations without a limit", func() {
+			registry.Add(relations.Relation{Parent: "users", Name: "books", ForeignKey: "userId"})
+			DefaultLimit = 1
+			defer func() { DefaultLimit = 100 }()
+
+			So(Documents(provider, registry, "users", users, tree, nil), ShouldBeNil)
+			So(users[0]["books"], ShouldHaveLength, 1)
+			So(users[3]["books"], ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestFilter(t *testing.T) {
+
+	Convey("Given posts with authors and a filter", t, func() {
+		provider := &memory.Provider{}
+		registry := &relations.Registry{}
+		registry.AddReference(relations.Reference{Collection: "posts", Field: "author", Target: "users"})
+
+		alice := create(provider, "users", map[string]interface{}{"name": "alice", "password": "secret"})
+		post, _ := provider.Get("posts", create(provider, "posts", map[string]interface{}{"title": "first", "author": alice}))
+		tree, _ := Parse([]string{"author"})
+
+		Convey("The filter should receive the documents read from each collection", func() {
+			var collections []string
+			filter := func(collection string, documents []map[string]interface{}) ([]map[string]interface{}, *utils.Error) {
+				collections = append(collections, collection)
+				for _, document := range documents {
+					delete(document, "password")
+				}
+				return documents, nil
+			}
+			So(Documents(provider, registry, "posts", []map[string]interface{}{post}, tree, filter), ShouldBeNil)
+			So(collections, ShouldResemble, []string{"users"})
+			So(post["author"].(map[string]interface{})["name"], ShouldEqual, "alice")
+			So(post["author"], ShouldNotContainKey, "password")
+		})
+
+		Convey("Errors of the filter should fail the expansion", func() {
+			filter := func(collection string, documents []map[string]interface{}) ([]map[string]interface{}, *utils.Error) {
+				return nil, &utils.Error{Code: http.StatusForbidden}
+			}
+			err := Documents(provider, registry, "posts", []map[string]interface{}{post}, tree, filter)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestProject(t *testing.T) {
+
+	Convey("Given an expanded document", t, func() {
+		tree, _ := Parse([]string{"author,comments.author"})
+		document := map[string]interface{}{
+			"title":  "first",
+			"body":   "text",
+			"author": map[string]interface{}{"_id": "a", "name": "alice", "email": "alice@example.com"},
+			"comments": []interface{}{
+				map[string]interface{}{"text": "nice", "author": map[string]interface{}{"name": "bob", "email": "bob@example.com"}},
+			},
+		}
+
+		Convey("Expanded documents should be kept whole unless their fields are given", func() {
+			projected := tree.Project(document, []string{"title"})
+			So(projected, ShouldContainKey, "title")
+			So(projected, ShouldNotContainKey, "body")
+			So(projected["author"], ShouldResemble, document["author"])
+		})
+
+		Convey("Expanded documents and lists should be projected to their fields", func() {
+			projected := tree.Project(document, []string{"title", "author.name", "comments.author.name"})
+			So(projected["author"], ShouldResemble, map[string]interface{}{"name": "alice"})
+			So(projected["comments"], ShouldResemble, []interface{}{
+				map[string]interface{}{"author": map[string]interface{}{"name": "bob"}},
+			})
+		})
+
+		Convey("Own should leave out the fields of the expanded documents", func() {
+			So(tree.Own([]string{"title", "author.name", "address.city"}), ShouldResemble, []string{"title", "address.city"})
+		})
+	})
+}
@@ -0,0 +1,68 @@
+// Package files defines the files that are stored by data providers and
+// streamed to clients, together with their metadata.
+package files
+
+import (
+	"io"
+	"mime"
+	"time"
+)
+
+// DefaultContentType is used for files whose content type is unknown.
+const DefaultContentType = "application/octet-stream"
+
+// MultipartField is the name of the form field files are uploaded with in multipart
+// requests. If the form has no such field, the first file of the form is used.
+const MultipartField = "file"
+
+// Info is the metadata of a file given on upload.
+type Info struct {
+	Name        string // original file name, empty if unknown
+	ContentType string // empty if unknown
+	Size        int64  // negative if unknown
+}
+
+// File is an opened file. If Content also implements io.Closer, it is
+// closed after the file is written to the response.
+type File struct {
+	Info
+	Content io.ReadSeeker
+	ModTime time.Time // zero if unknown
+}
+
+// Close closes the content of the file if it is closable.
+func (f File) Close() error {
+	if closer, isCloser := f.Content.(io.Closer); isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// InlineContentTypes are the content types of the files which clients display. Files of
+// the other types are downloaded as attachments, so that uploaded HTML or SVG files are not
+// run as scripts on the origin of the API.
+var InlineContentTypes = map[string]bool{
+	"text/plain":      true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"application/pdf": true,
+}
+
+// ContentDisposition returns the Content-Disposition header value which makes clients keep the
+// original file name. Files are inline only if their content type is in InlineContentTypes.
+func (i Info) ContentDisposition() string {
+	disposition := "attachment"
+	if mediaType, _, _ := mime.ParseMediaType(i.ContentType); InlineContentTypes[mediaType] {
+		disposition = "inline"
+	}
+	if i.Name == "" {
+		return disposition
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": i.Name})
+}
@@ -1,6 +1,7 @@
 package functions
 
 import (
+	"context"
 	"github.com/rihtim/core/utils"
 	"github.com/rihtim/core/messages"
 	"github.com/rihtim/core/requestscope"
@@ -9,6 +10,17 @@ import (
 
 type FunctionHandler func(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error)
 
+// ContextFunctionHandler is a FunctionHandler which receives the context of the request.
+type ContextFunctionHandler func(ctx context.Context, req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error)
+
+// WithContext converts a ContextFunctionHandler to a FunctionHandler
+// which passes the context stored in the request scope.
+func WithContext(handler ContextFunctionHandler) FunctionHandler {
+	return func(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+		return handler(rs.Context(), req, rs, extras, dp)
+	}
+}
+
 type FunctionController interface {
 	FindIndex(path, method string) int
 	Contains(path, method string) bool
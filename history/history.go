@@ -0,0 +1,203 @@
+// Package history keeps the previous versions of the documents of collections. Before a
+// document is updated or deleted, a snapshot of it is written to the history collection
+// of its collection. Snapshots are numbered per document, starting from 1.
+package history
+
+import (
+	"sync"
+	"time"
+	"strconv"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Fields of the snapshots. The creation time of a snapshot is the time of the change.
+const (
+	ObjectIdField  = "objectId"
+	VersionField   = "version"
+	OperationField = "operation"
+	DocumentField  = "document"
+)
+
+// Operations which replaced the snapshots.
+const (
+	UpdateOperation  = "update"
+	DeleteOperation  = "delete"
+	RestoreOperation = "restore"
+)
+
+const (
+	// Segment is the path segment of the history of an object, ex: /users/{id}/_history/{version}
+	Segment = "_history"
+
+	// RevertSegment is the path segment which reverts an object to a version with POST,
+	// ex: POST /users/{id}/_history/{version}/_revert. It is the same as the revert command.
+	RevertSegment = "_revert"
+
+	// AtParameter reads an object as it was at the given time, ex: ?at=2020-01-02T15:04:05Z
+	AtParameter = "at"
+)
+
+type Policy struct {
+	Collection string
+
+	// HistoryCollection keeps the snapshots. If empty, it is the collection name with the suffix '_history'.
+	HistoryCollection string
+}
+
+// Store returns the collection which keeps the snapshots.
+func (p Policy) Store() string {
+	if p.HistoryCollection == "" {
+		return p.Collection + "_history"
+	}
+	return p.HistoryCollection
+}
+
+// Registry keeps the collections whose history is kept. It is safe for concurrent
+// use and its zero value is an empty registry.
+type Registry struct {
+	mutex    sync.RWMutex
+	policies map[string]Policy
+}
+
+// Add enables history for the collection of the policy, replacing the previous policy.
+func (r *Registry) Add(policy Policy) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.policies == nil {
+		r.policies = make(map[string]Policy)
+	}
+	r.policies[policy.Collection] = policy
+}
+
+func (r *Registry) Remove(collection string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.policies, collection)
+}
+
+func (r *Registry) Get(collection string) (policy Policy, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	policy, exists = r.policies[collection]
+	return
+}
+
+// IsStore tells whether the collection keeps the snapshots of a collection in the registry.
+func (r *Registry) IsStore(collection string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, policy := range r.policies {
+		if policy.Store() == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// Versions returns a provider which sees only the snapshots of the object in the history collection.
+func Versions(provider dataprovider.Provider, policy Policy, id string) dataprovider.Provider {
+	return relations.Scope(provider, relations.Relation{Collection: policy.Store(), ForeignKey: ObjectIdField}, id)
+}
+
+// Version returns the snapshot of the object with the given version number.
+func Version(provider dataprovider.Provider, policy Policy, id string, version int) (snapshot map[string]interface{}, err *utils.Error) {
+
+	q := query.New()
+	q.Where = query.And{
+		query.Comparison{Field: ObjectIdField, Operator: query.Equal, Value: id},
+		query.Comparison{Field: VersionField, Operator: query.Equal, Value: version},
+	}
+	q.Limit = 1
+
+	snapshots, err := find(provider, policy.Store(), q)
+	if err != nil {
+		return
+	}
+	if len(snapshots) == 0 {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Version " + strconv.Itoa(version) + " not found."}
+		return
+	}
+	snapshot = snapshots[0]
+	return
+}
+
+// Revert replaces the object with the document of the snapshot with the given version number.
+// The replaced object is recorded as a new version if the provider records the history.
+// Deleted objects cannot be reverted, since they cannot be created with the same id.
+func Revert(provider, store dataprovider.Provider, policy Policy, id string, version int) (response map[string]interface{}, err *utils.Error) {
+
+	snapshot, err := Version(store, policy, id, version)
+	if err != nil {
+		return
+	}
+	document, _ := snapshot[DocumentField].(map[string]interface{})
+
+	return dataprovider.Modify(provider, policy.Collection, id, func(map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		return utils.CopyValue(document).(map[string]interface{}), nil
+	})
+}
+
+// At returns the object as it was at the given time. Returns an error with code 404
+// if the object didn't exist or was deleted at that time.
+func At(provider dataprovider.Provider, policy Policy, id string, at time.Time) (document map[string]interface{}, err *utils.Error) {
+
+	notFound := &utils.Error{Code: http.StatusNotFound, Message: "Object not found at the given time."}
+
+	// the current document is the answer if it hasn't changed since then
+	current, err := provider.Get(policy.Collection, id)
+	if err != nil && err.Code != http.StatusNotFound {
+		return
+	}
+	err = nil
+	if current != nil && changedBefore(current, at) {
+		if current[dataprovider.DeletedAtField] != nil {
+			err = notFound
+			return
+		}
+		return current, nil
+	}
+
+	// otherwise the first snapshot taken after then keeps the document as it was
+	q := query.New()
+	q.Where = query.And{
+		query.Comparison{Field: ObjectIdField, Operator: query.Equal, Value: id},
+		query.Comparison{Field: dataprovider.CreatedAtField, Operator: query.GreaterThan, Value: at},
+	}
+	q.Sort = []query.SortField{{Field: VersionField}}
+	q.Limit = 1
+
+	snapshots, err := find(provider, policy.Store(), q)
+	if err != nil {
+		return
+	}
+	if len(snapshots) > 0 {
+		document, _ = snapshots[0][DocumentField].(map[string]interface{})
+	}
+	if document == nil || !changedBefore(document, at) || document[dataprovider.DeletedAtField] != nil {
+		document, err = nil, notFound
+	}
+	return
+}
+
+// changedBefore tells whether the document was created or last updated at or before the time.
+func changedBefore(document map[string]interface{}, at time.Time) bool {
+	field := dataprovider.UpdatedAtField
+	if _, updated := document[field]; !updated {
+		field = dataprovider.CreatedAtField
+	}
+	return query.Comparison{Field: field, Operator: query.LessThanOrEqual, Value: at}.Match(document)
+}
+
+func find(provider dataprovider.Provider, collection string, q query.Query) (results []map[string]interface{}, err *utils.Error) {
+	response, err := dataprovider.QueryStructured(provider, collection, q)
+	if err != nil {
+		return
+	}
+	results, _ = dataprovider.Results(response)
+	return
+}
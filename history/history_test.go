@@ -0,0 +1,146 @@
+package history
+
+import (
+	"time"
+	"testing"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+
+	Convey("Given a collection with history", t, func() {
+		provider := &memory.Provider{}
+		registry := &Registry{}
+		registry.Add(Policy{Collection: "users"})
+		policy, _ := registry.Get("users")
+		recording := Record(provider, registry)
+
+		created, _ := recording.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+		beforeUpdates := time.Now()
+		time.Sleep(time.Millisecond)
+
+		_, err := recording.Update("users", id, map[string]interface{}{"name": "alicia"})
+		So(err, ShouldBeNil)
+		_, err = dataprovider.Modify(recording, "users", id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+			document["name"] = "ally"
+			return document, nil
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Every change should write a snapshot of the previous document", func() {
+			response, err := Versions(provider, policy, id).Query(policy.Store(), map[string][]string{"sort": {"version"}})
+			So(err, ShouldBeNil)
+			snapshots, _ := dataprovider.Results(response)
+			So(len(snapshots), ShouldEqual, 2)
+			So(snapshots[0][OperationField], ShouldEqual, UpdateOperation)
+			So(snapshots[0][DocumentField].(map[string]interface{})["name"], ShouldEqual, "alice")
+			So(snapshots[1][DocumentField].(map[string]interface{})["name"], ShouldEqual, "alicia")
+		})
+
+		Convey("Version should return the snapshot by number", func() {
+			snapshot, err := Version(provider, policy, id, 2)
+			So(err, ShouldBeNil)
+			So(snapshot[VersionField], ShouldEqual, 2)
+
+			_, err = Version(provider, policy, id, 3)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Revert should restore the version and record the replaced document", func() {
+			_, err := Revert(recording, provider, policy, id, 1)
+			So(err, ShouldBeNil)
+
+			user, _ := provider.Get("users", id)
+			So(user["name"], ShouldEqual, "alice")
+
+			snapshot, err := Version(provider, policy, id, 3)
+			So(err, ShouldBeNil)
+			So(snapshot[DocumentField].(map[string]interface{})["name"], ShouldEqual, "ally")
+		})
+
+		Convey("At should return the document as it was at the time", func() {
+			document, err := At(provider, policy, id, beforeUpdates)
+			So(err, ShouldBeNil)
+			So(document["name"], ShouldEqual, "alice")
+
+			document, err = At(provider, policy, id, time.Now())
+			So(err, ShouldBeNil)
+			So(document["name"], ShouldEqual, "ally")
+
+			_, err = At(provider, policy, id, beforeUpdates.Add(-time.Hour))
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When the document is deleted", func() {
+			_, err := recording.Delete("users", id)
+			So(err, ShouldBeNil)
+
+			Convey("Its last version should be recorded", func() {
+				snapshot, err := Version(provider, policy, id, 3)
+				So(err, ShouldBeNil)
+				So(snapshot[OperationField], ShouldEqual, DeleteOperation)
+			})
+
+			Convey("It should be readable at times before the delete only", func() {
+				document, err := At(provider, policy, id, beforeUpdates)
+				So(err, ShouldBeNil)
+				So(document["name"], ShouldEqual, "alice")
+
+				_, err = At(provider, policy, id, time.Now())
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("Other collections should not be recorded", func() {
+			created, _ := recording.Create("books", map[string]interface{}{"title": "dune"})
+			_, err := recording.Update("books", created[dataprovider.IdField].(string), map[string]interface{}{"title": "emma"})
+			So(err, ShouldBeNil)
+
+			response, _ := provider.Query("books_history", nil)
+			snapshots, _ := dataprovider.Results(response)
+			So(snapshots, ShouldBeEmpty)
+		})
+
+		Convey("The history collection should be known as a store", func() {
+			So(registry.IsStore("users_history"), ShouldBeTrue)
+			So(registry.IsStore("users"), ShouldBeFalse)
+		})
+
+		Convey("Changes should be kept when their snapshot cannot be written", func() {
+			recording := Record(failingStore{provider}, registry)
+
+			_, err := recording.Update("users", id, map[string]interface{}{"name": "bob"})
+			So(err, ShouldBeNil)
+			_, err = dataprovider.Modify(recording, "users", id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				document["name"] = "carol"
+				return document, nil
+			})
+			So(err, ShouldBeNil)
+
+			document, _ := provider.Get("users", id)
+			So(document["name"], ShouldEqual, "carol")
+
+			_, err = recording.Delete("users", id)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+// failingStore fails writing to the history collections.
+type failingStore struct {
+	dataprovider.Provider
+}
+
+func (fs failingStore) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if strings.HasSuffix(collection, "_history") {
+		err = &utils.Error{Code: http.StatusServiceUnavailable, Message: "Unavailable."}
+		return
+	}
+	return fs.Provider.Create(collection, data)
+}
@@ -0,0 +1,124 @@
+package history
+
+import (
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Record returns a provider which writes a snapshot of the documents of the collections in the
+// registry before they are updated or deleted. Snapshots are written to the same provider, so
+// they are committed or rolled back with the change if the provider is a transaction. Snapshots
+// are written after the change, which is kept when writing the snapshot fails, since outside of
+// a transaction it cannot be undone; the failure is logged.
+func Record(provider dataprovider.Provider, registry *Registry) dataprovider.Provider {
+	return &recordingProvider{dataprovider.Delegate{Provider: provider}, registry}
+}
+
+type recordingProvider struct {
+	dataprovider.Delegate
+	registry *Registry
+}
+
+func (rp *recordingProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	policy, tracked := rp.registry.Get(collection)
+	if !tracked {
+		return rp.Provider.Update(collection, id, data)
+	}
+
+	previous, err := rp.Provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+	if response, err = rp.Provider.Update(collection, id, data); err != nil {
+		return
+	}
+	rp.snapshot(policy, id, UpdateOperation, previous)
+	return
+}
+
+func (rp *recordingProvider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	policy, tracked := rp.registry.Get(collection)
+	if !tracked {
+		return dataprovider.Modify(rp.Provider, collection, id, modify)
+	}
+
+	// the snapshot is written after the modification, since providers may lock the document during it
+	var previous map[string]interface{}
+	operation := UpdateOperation
+	response, err = dataprovider.Modify(rp.Provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		previous = utils.CopyDocument(document)
+		modified, err := modify(document)
+		if err != nil {
+			return nil, err
+		}
+		// reset, since providers may call the function again
+		operation = UpdateOperation
+		if dataprovider.OnlyDeletedAtChanged(previous, modified) {
+			operation = RestoreOperation
+			if modified[dataprovider.DeletedAtField] != nil {
+				operation = DeleteOperation
+			}
+		}
+		return modified, nil
+	})
+	if err != nil {
+		return
+	}
+	rp.snapshot(policy, id, operation, previous)
+	return
+}
+
+func (rp *recordingProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	policy, tracked := rp.registry.Get(collection)
+	if !tracked {
+		return rp.Provider.Delete(collection, id)
+	}
+
+	previous, err := rp.Provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+	if response, err = rp.Provider.Delete(collection, id); err != nil {
+		return
+	}
+	rp.snapshot(policy, id, DeleteOperation, previous)
+	return
+}
+
+func (rp *recordingProvider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	policy, tracked := rp.registry.Get(collection)
+	if !tracked {
+		return dataprovider.DeleteIf(rp.Provider, collection, id, check)
+	}
+
+	var previous map[string]interface{}
+	response, err = dataprovider.DeleteIf(rp.Provider, collection, id, func(document map[string]interface{}) *utils.Error {
+		previous = utils.CopyDocument(document)
+		return check(document)
+	})
+	if err != nil {
+		return
+	}
+	rp.snapshot(policy, id, DeleteOperation, previous)
+	return
+}
+
+// snapshot writes the previous document as the next version of the object. The version is
+// the number of snapshots plus one, so concurrent changes outside of a transaction may share it.
+func (rp *recordingProvider) snapshot(policy Policy, id, operation string, previous map[string]interface{}) {
+
+	count, err := dataprovider.Count(rp.Provider, policy.Store(), query.Comparison{Field: ObjectIdField, Operator: query.Equal, Value: id})
+	if err == nil {
+		_, err = rp.Provider.Create(policy.Store(), map[string]interface{}{
+			ObjectIdField:  id,
+			VersionField:   count + 1,
+			OperationField: operation,
+			DocumentField:  previous,
+		})
+	}
+	if err != nil {
+		log.Error("Recording the history of '" + policy.Collection + "/" + id + "' failed. Reason: " + err.Message)
+	}
+}
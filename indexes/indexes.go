@@ -0,0 +1,97 @@
+// Package indexes declares the indexes of collections, ex: a unique index of the emails of
+// users and an index of the posts of users by their creation time:
+//
+//   core.Indexes.Add(indexes.Unique("users", "email"))
+//   core.Indexes.Add(indexes.New("posts", "userId", "createdAt"))
+//
+// Unique indexes are enforced by Enforce, which looks for another document with the same values
+// before a document is written. The check and the write are not atomic; concurrent writes may
+// still create duplicates unless the provider enforces the indexes itself. Ensure creates the
+// indexes on providers which implement dataprovider.IndexEnsurer.
+package indexes
+
+import (
+	"sort"
+	"sync"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// New returns an index of the collection on the fields.
+func New(collection string, fields ...string) dataprovider.Index {
+	return dataprovider.Index{Collection: collection, Fields: fields}
+}
+
+// Unique returns a unique index of the collection on the fields. Documents missing any of
+// the fields are not checked, so that the fields stay optional.
+func Unique(collection string, fields ...string) dataprovider.Index {
+	return dataprovider.Index{Collection: collection, Fields: fields, Unique: true}
+}
+
+// Registry keeps the indexes of the collections. It is safe for concurrent use and its
+// zero value is an empty registry.
+type Registry struct {
+	mutex   sync.RWMutex
+	indexes map[string]map[string]dataprovider.Index
+}
+
+// Add adds the index to its collection, replacing the index with the same name. Indexes
+// without fields are ignored.
+func (r *Registry) Add(index dataprovider.Index) {
+	if len(index.Fields) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.indexes == nil {
+		r.indexes = make(map[string]map[string]dataprovider.Index)
+	}
+	if r.indexes[index.Collection] == nil {
+		r.indexes[index.Collection] = make(map[string]dataprovider.Index)
+	}
+	r.indexes[index.Collection][index.Name()] = index
+}
+
+func (r *Registry) Remove(collection, name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.indexes[collection], name)
+}
+
+// Get returns the indexes of the collection ordered by their names.
+func (r *Registry) Get(collection string) (indexes []dataprovider.Index) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, index := range r.indexes[collection] {
+		indexes = append(indexes, index)
+	}
+	sortByName(indexes)
+	return
+}
+
+// Indexes returns the indexes of every collection ordered by their names.
+func (r *Registry) Indexes() (indexes []dataprovider.Index) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, collection := range r.indexes {
+		for _, index := range collection {
+			indexes = append(indexes, index)
+		}
+	}
+	sortByName(indexes)
+	return
+}
+
+// Ensure creates the indexes of the registry on the provider if it implements
+// dataprovider.IndexEnsurer. It is meant to be called after the provider connects.
+func Ensure(provider dataprovider.Provider, registry *Registry) (err *utils.Error) {
+	return dataprovider.EnsureIndexes(provider, registry.Indexes())
+}
+
+func sortByName(indexes []dataprovider.Index) {
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Name() < indexes[j].Name()
+	})
+}
@@ -0,0 +1,134 @@
+package indexes
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given a registry", t, func() {
+		registry := &Registry{}
+		registry.Add(Unique("users", "email"))
+		registry.Add(New("users", "lastName", "firstName"))
+		registry.Add(New("posts", "userId"))
+
+		Convey("Get should return the indexes of the collection by their names", func() {
+			indexes := registry.Get("users")
+			So(len(indexes), ShouldEqual, 2)
+			So(indexes[0].Name(), ShouldEqual, "users_email_unique")
+			So(indexes[1].Name(), ShouldEqual, "users_lastName_firstName")
+		})
+
+		Convey("Adding an index with the same name should replace it", func() {
+			registry.Add(Unique("users", "email"))
+			So(len(registry.Get("users")), ShouldEqual, 2)
+			So(len(registry.Indexes()), ShouldEqual, 3)
+		})
+
+		Convey("Removed indexes should not be returned", func() {
+			registry.Remove("users", "users_email_unique")
+			So(len(registry.Get("users")), ShouldEqual, 1)
+		})
+
+		Convey("Indexes without fields should be ignored", func() {
+			registry.Add(New("comments"))
+			So(registry.Get("comments"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestEnforce(t *testing.T) {
+
+	Convey("Given a provider enforcing unique indexes", t, func() {
+		registry := &Registry{}
+		registry.Add(Unique("users", "email"))
+		registry.Add(Unique("users", "firstName", "lastName"))
+		provider := Enforce(&memory.Provider{}, registry)
+
+		created, _ := provider.Create("users", map[string]interface{}{"email": "alice@example.com", "firstName": "alice", "lastName": "smith"})
+		alice := created[dataprovider.IdField].(string)
+		created, _ = provider.Create("users", map[string]interface{}{"email": "bob@example.com", "firstName": "bob", "lastName": "smith"})
+		bob := created[dataprovider.IdField].(string)
+
+		Convey("Creating a document with a duplicate value should return conflict naming the field", func() {
+			_, err := provider.Create("users", map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+			So(err.Message, ShouldEqual, "Value of 'email' must be unique in 'users'.")
+			So(err.Details, ShouldResemble, map[string]interface{}{"index": "users_email_unique", "fields": []string{"email"}})
+		})
+
+		Convey("Compound indexes should conflict only if every field is the same", func() {
+			_, err := provider.Create("users", map[string]interface{}{"firstName": "carol", "lastName": "smith"})
+			So(err, ShouldBeNil)
+
+			_, err = provider.Create("users", map[string]interface{}{"firstName": "alice", "lastName": "smith"})
+			So(err, ShouldNotBeNil)
+			So(err.Message, ShouldEqual, "Values of 'firstName', 'lastName' must be unique together in 'users'.")
+		})
+
+		Convey("Documents without the fields should not conflict", func() {
+			_, err := provider.Create("users", map[string]interface{}{"name": "dave"})
+			So(err, ShouldBeNil)
+			_, err = provider.Create("users", map[string]interface{}{"name": "dave", "email": nil})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Updating a field to a duplicate value should return conflict", func() {
+			_, err := provider.Update("users", bob, map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			_, err = provider.Update("users", bob, map[string]interface{}{"firstName": "alice"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			document, _ := provider.Get("users", bob)
+			So(document["email"], ShouldEqual, "bob@example.com")
+			So(document["firstName"], ShouldEqual, "bob")
+		})
+
+		Convey("Updating a document with its own values should succeed", func() {
+			_, err := provider.Update("users", alice, map[string]interface{}{"email": "alice@example.com", "age": 30})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Modifying a document to a duplicate value should return conflict", func() {
+			_, err := dataprovider.Modify(provider, "users", bob, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				document["email"] = "alice@example.com"
+				return document, nil
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			_, err = dataprovider.Modify(provider, "users", bob, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				document["email"] = "robert@example.com"
+				return document, nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Other collections should not be checked", func() {
+			provider.Create("posts", map[string]interface{}{"email": "alice@example.com"})
+			_, err := provider.Create("posts", map[string]interface{}{"email": "alice@example.com"})
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Ensure should create the indexes on providers which implement IndexEnsurer", t, func() {
+		registry := &Registry{}
+		registry.Add(Unique("users", "email"))
+		provider := &memory.Provider{}
+		So(Ensure(provider, registry), ShouldBeNil)
+
+		provider.Create("users", map[string]interface{}{"email": "alice@example.com"})
+		_, err := provider.Create("users", map[string]interface{}{"email": "alice@example.com"})
+		So(err, ShouldNotBeNil)
+		So(err.Code, ShouldEqual, http.StatusConflict)
+	})
+}
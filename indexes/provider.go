@@ -0,0 +1,185 @@
+package indexes
+
+import (
+	"io"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Enforce returns a provider which rejects the documents violating the unique indexes of their
+// collections in the registry with the Conflict error of the index. Updates are checked only if
+// they change a field of an index; the updated document is checked as a whole.
+func Enforce(provider dataprovider.Provider, registry *Registry) dataprovider.Provider {
+	return &enforcingProvider{provider, registry}
+}
+
+type enforcingProvider struct {
+	provider dataprovider.Provider
+	registry *Registry
+}
+
+func (ep *enforcingProvider) Connect() (err *utils.Error) {
+	return ep.provider.Connect()
+}
+
+func (ep *enforcingProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if err = ep.check(collection, "", data, ep.unique(collection, nil)); err != nil {
+		return
+	}
+	return ep.provider.Create(collection, data)
+}
+
+func (ep *enforcingProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Get(collection, id)
+}
+
+func (ep *enforcingProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Query(collection, parameters)
+}
+
+func (ep *enforcingProvider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.QueryStructured(ep.provider, collection, q)
+}
+
+func (ep *enforcingProvider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return dataprovider.Count(ep.provider, collection, where)
+}
+
+func (ep *enforcingProvider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Aggregate(ep.provider, collection, aggregation)
+}
+
+func (ep *enforcingProvider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Search(ep.provider, collection, text, q)
+}
+
+func (ep *enforcingProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	unique := ep.unique(collection, data)
+	if len(unique) == 0 {
+		return ep.provider.Update(collection, id, data)
+	}
+
+	document, err := ep.provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+	for key, value := range data {
+		document[key] = value
+	}
+	if err = ep.check(collection, id, document, unique); err != nil {
+		return
+	}
+	return ep.provider.Update(collection, id, data)
+}
+
+// Modify runs the modification on the current document to check the result before the document
+// is modified, since providers may lock the document during the modification. The modification
+// fails if it results in other values of the indexed fields when it is applied.
+func (ep *enforcingProvider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+
+	unique := ep.unique(collection, nil)
+	if len(unique) == 0 {
+		return dataprovider.Modify(ep.provider, collection, id, modify)
+	}
+
+	document, err := ep.provider.Get(collection, id)
+	if err != nil {
+		return
+	}
+	checked, err := modify(document)
+	if err != nil {
+		return
+	}
+	if err = ep.check(collection, id, checked, unique); err != nil {
+		return
+	}
+
+	return dataprovider.Modify(ep.provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		modified, err := modify(document)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range unique {
+			modifiedValues, _ := index.Values(modified)
+			checkedValues, _ := index.Values(checked)
+			if !query.Equals(modifiedValues, checkedValues) {
+				return nil, &utils.Error{Code: http.StatusConflict, Message: "Object was changed while its unique fields were checked."}
+			}
+		}
+		return modified, nil
+	})
+}
+
+func (ep *enforcingProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Delete(collection, id)
+}
+
+func (ep *enforcingProvider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.DeleteIf(ep.provider, collection, id, check)
+}
+
+func (ep *enforcingProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.CreateFile(data)
+}
+
+func (ep *enforcingProvider) GetFile(id string) (response []byte, err *utils.Error) {
+	return ep.provider.GetFile(id)
+}
+
+func (ep *enforcingProvider) OpenFile(id string) (file files.File, err *utils.Error) {
+	return dataprovider.OpenFile(ep.provider, id)
+}
+
+func (ep *enforcingProvider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.CreateFileWithInfo(ep.provider, info, data)
+}
+
+// unique returns the unique indexes of the collection which have a field changed by the data.
+// Every unique index of the collection is returned if data is nil.
+func (ep *enforcingProvider) unique(collection string, data map[string]interface{}) (unique []dataprovider.Index) {
+	for _, index := range ep.registry.Get(collection) {
+		if index.Unique && (data == nil || changes(data, index)) {
+			unique = append(unique, index)
+		}
+	}
+	return
+}
+
+func changes(data map[string]interface{}, index dataprovider.Index) bool {
+	for _, field := range index.Fields {
+		if _, changed := data[strings.Split(field, ".")[0]]; changed {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the conflict of the first index for which another document has the values of
+// the document. The document with the given id is the document itself, if it is updated.
+func (ep *enforcingProvider) check(collection string, id string, document map[string]interface{}, unique []dataprovider.Index) (err *utils.Error) {
+	for _, index := range unique {
+		values, complete := index.Values(document)
+		if !complete {
+			continue
+		}
+
+		where := index.Where(values)
+		if id != "" {
+			where = query.And{where, query.Comparison{Field: dataprovider.IdField, Operator: query.NotEqual, Value: id}}
+		}
+
+		count, countErr := dataprovider.Count(ep.provider, collection, where)
+		if countErr != nil {
+			return countErr
+		}
+		if count > 0 {
+			return index.Conflict()
+		}
+	}
+	return
+}
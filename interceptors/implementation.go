@@ -1,6 +1,7 @@
 package interceptors
 
 import (
+	"sync"
 	"regexp"
 	"strings"
 	"runtime"
@@ -21,7 +22,10 @@ type interceptorIndex struct {
 	interceptor     Interceptor
 }
 
+// CoreInterceptorController matches the interceptors by the resources and the methods. Interceptors
+// can be added while requests are executed, ex: while FINAL interceptors are running.
 type CoreInterceptorController struct {
+	mutex           sync.RWMutex
 	interceptorsMap []interceptorIndex
 }
 
@@ -29,6 +33,9 @@ func (ci *CoreInterceptorController) Add(res, method string, interceptorType Int
 
 	res = utils.ConvertRichUrlToRegex(res, true)
 
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+
 	if ci.interceptorsMap == nil {
 		ci.interceptorsMap = make([]interceptorIndex, 0)
 	}
@@ -46,6 +53,9 @@ func (ci *CoreInterceptorController) Get(res, method string, interceptorType Int
 	extras = make([]interface{}, 0)
 	paths = make([]string, 0)
 
+	ci.mutex.RLock()
+	defer ci.mutex.RUnlock()
+
 	for _, index := range ci.interceptorsMap {
 
 		// skip if interceptor type doesn't match
@@ -1,6 +1,7 @@
 package interceptors
 
 import (
+	"context"
 	"github.com/rihtim/core/utils"
 	"github.com/rihtim/core/messages"
 	"github.com/rihtim/core/requestscope"
@@ -9,6 +10,17 @@ import (
 
 type Interceptor func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error)
 
+// ContextInterceptor is an Interceptor which receives the context of the request.
+type ContextInterceptor func(ctx context.Context, rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error)
+
+// WithContext converts a ContextInterceptor to an Interceptor
+// which passes the context stored in the request scope.
+func WithContext(interceptor ContextInterceptor) Interceptor {
+	return func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+		return interceptor(rs.Context(), rs, extras, req, resp, dp)
+	}
+}
+
 type InterceptorType int
 
 const (
@@ -4,6 +4,7 @@ import (
 	"io"
 	"strconv"
 	"mime/multipart"
+	"github.com/rihtim/core/files"
 	"github.com/rihtim/core/utils"
 )
 
@@ -17,6 +18,7 @@ type Message struct {
 	MultipartForm *multipart.Form        `json:"multipart,omitempty"`
 	Body          map[string]interface{} `json:"body,omitempty"`
 	RawBody       []byte                 `json:"rawbody,omitempty"` // used for files
+	File          *files.File            `json:"-"`                 // used for streamed files
 	ReqBodyRaw    io.ReadCloser
 	Status        int                    `json:"status,omitempty"` // used only in responses
 }
@@ -84,5 +86,5 @@ type RequestError struct {
 }
 
 func (m *Message) IsEmpty() bool {
-	return m.Status == 0 && len(m.Res) == 0 && len(m.Command) == 0 && m.Headers == nil && m.Parameters == nil && m.MultipartForm == nil && m.Body == nil && len(m.RawBody) == 0 && m.ReqBodyRaw == nil
+	return m.Status == 0 && len(m.Res) == 0 && len(m.Command) == 0 && m.Headers == nil && m.Parameters == nil && m.MultipartForm == nil && m.Body == nil && len(m.RawBody) == 0 && m.File == nil && m.ReqBodyRaw == nil
 }
@@ -1,10 +1,15 @@
 package methods
 
 const (
-	Get     = "get"
-	Post    = "post"
-	Put     = "put"
-	Delete  = "delete"
-	Options = "options"
-	Any     = "*"
+	Get         = "get"
+	Post        = "post"
+	Put         = "put"
+	Patch       = "patch"
+	Delete      = "delete"
+	Options     = "options"
+	Restore     = "restore"
+	Revert      = "revert"
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+	Any         = "*"
 )
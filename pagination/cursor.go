@@ -0,0 +1,164 @@
+// Package pagination implements opaque cursors for paging through collection
+// queries. A cursor keeps the sort values of the last document of a page and
+// the next page starts right after them, so documents inserted between the
+// requests don't shift the pages.
+//
+// Cursors are signed with HMAC-SHA256. The key is generated randomly at start up,
+// so SetKey must be called with a shared key if there are multiple instances or if
+// cursors should survive restarts.
+package pagination
+
+import (
+	"sync"
+	"time"
+	"strings"
+	"net/http"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/base64"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+// Names of the parameters and the response fields of paged queries.
+const (
+	AfterParameter = "after"
+	CountParameter = "count"
+	NextField      = "next"
+	TotalField     = "total"
+)
+
+// DefaultLimit is the page size used when a cursor is given without a limit.
+var DefaultLimit = 100
+
+var keyMutex sync.RWMutex
+var key = randomKey()
+
+// SetKey sets the key which cursors are signed with.
+func SetKey(newKey []byte) {
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+	key = newKey
+}
+
+// payload is the content of a cursor. Times are encoded as strings, so the indexes of
+// the values which are times are kept to decode them as times.
+type payload struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	Times  []int         `json:"t,omitempty"`
+}
+
+// Encode creates the cursor pointing after the document for the given sort.
+func Encode(sortFields []query.SortField, document map[string]interface{}) string {
+
+	values := make([]interface{}, len(sortFields))
+	var times []int
+	for i, sortField := range sortFields {
+		values[i], _ = query.Lookup(document, sortField.Field)
+		if _, isTime := values[i].(time.Time); isTime {
+			times = append(times, i)
+		}
+	}
+
+	data, _ := json.Marshal(payload{sortKey(sortFields), values, times})
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(sign(data))
+}
+
+// Decode verifies the cursor and returns the sort values it points after. Returns an
+// error with code 400 if the cursor is invalid or was created for another sort.
+func Decode(cursor string, sortFields []query.SortField) (values []interface{}, err *utils.Error) {
+
+	invalid := &utils.Error{Code: http.StatusBadRequest, Message: "Invalid cursor."}
+
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		err = invalid
+		return
+	}
+
+	data, dataErr := base64.RawURLEncoding.DecodeString(parts[0])
+	signature, signatureErr := base64.RawURLEncoding.DecodeString(parts[1])
+	if dataErr != nil || signatureErr != nil || !hmac.Equal(signature, sign(data)) {
+		err = invalid
+		return
+	}
+
+	var decoded payload
+	if decodeErr := json.Unmarshal(data, &decoded); decodeErr != nil || len(decoded.Values) != len(sortFields) {
+		err = invalid
+		return
+	}
+	if decoded.Sort != sortKey(sortFields) {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Cursor was created for another sort order."}
+		return
+	}
+
+	for _, i := range decoded.Times {
+		text, isString := "", false
+		if i >= 0 && i < len(decoded.Values) {
+			text, isString = decoded.Values[i].(string)
+		}
+		parsed, parseErr := time.Parse(time.RFC3339Nano, text)
+		if !isString || parseErr != nil {
+			err = invalid
+			return
+		}
+		decoded.Values[i] = parsed
+	}
+
+	values = decoded.Values
+	return
+}
+
+// After returns the condition which matches the documents coming after the given sort values
+// in the order of the given sort fields. The order is the order of query.Compare, so documents
+// missing a sort field come first and are not skipped.
+func After(sortFields []query.SortField, values []interface{}) query.Condition {
+
+	// for sort fields a, b, c: a > va OR (a = va AND b > vb) OR (a = va AND b = vb AND c > vc)
+	conditions := make(query.Or, 0, len(sortFields))
+	for i, sortField := range sortFields {
+
+		follows := query.Follows(sortField.Field, values[i], sortField.Descending)
+		if follows == nil {
+			continue
+		}
+
+		and := make(query.And, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, query.Comparison{Field: sortFields[j].Field, Operator: query.Equal, Value: values[j]})
+		}
+		conditions = append(conditions, append(and, follows))
+	}
+	return conditions
+}
+
+// sortKey identifies the sort order, ex: -age,_id
+func sortKey(sortFields []query.SortField) string {
+	fields := make([]string, len(sortFields))
+	for i, sortField := range sortFields {
+		fields[i] = sortField.Field
+		if sortField.Descending {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+func sign(data []byte) []byte {
+	keyMutex.RLock()
+	defer keyMutex.RUnlock()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func randomKey() []byte {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return bytes
+}
@@ -0,0 +1,135 @@
+package pagination
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/query"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCursor(t *testing.T) {
+
+	Convey("Given documents sorted by a field with duplicate values", t, func() {
+		documents := []map[string]interface{}{
+			{"_id": "a", "age": 30.0},
+			{"_id": "b", "age": 25.0},
+			{"_id": "c", "age": 30.0},
+			{"_id": "d", "age": 35.0},
+			{"_id": "e", "age": 25.0},
+		}
+		sortFields := []query.SortField{{Field: "age", Descending: true}, {Field: "_id"}}
+
+		Convey("Paging with cursors should return every document once in order", func() {
+			var ids []interface{}
+			cursor := ""
+			for page := 0; page < 5; page++ {
+				q := query.New()
+				q.Sort = sortFields
+				q.Limit = 2
+				if cursor != "" {
+					values, err := Decode(cursor, sortFields)
+					So(err, ShouldBeNil)
+					q.Where = After(sortFields, values)
+				}
+
+				results := q.Apply(documents)
+				for _, result := range results {
+					ids = append(ids, result["_id"])
+				}
+				if len(results) < 2 {
+					break
+				}
+				cursor = Encode(sortFields, results[len(results)-1])
+			}
+			So(ids, ShouldResemble, []interface{}{"d", "a", "c", "b", "e"})
+		})
+
+		Convey("A cursor with a modified signature should be rejected", func() {
+			cursor := Encode(sortFields, documents[0])
+			_, err := Decode(cursor+"x", sortFields)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("A cursor should be rejected for another sort order", func() {
+			cursor := Encode(sortFields, documents[0])
+			_, err := Decode(cursor, []query.SortField{{Field: "age"}, {Field: "_id"}})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("A cursor signed with another key should be rejected", func() {
+			cursor := Encode(sortFields, documents[0])
+			SetKey([]byte("another key"))
+			defer SetKey(randomKey())
+
+			_, err := Decode(cursor, sortFields)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// page returns the ids of the documents in the order of the pages of the given size.
+func page(documents []map[string]interface{}, sortFields []query.SortField, size int) (ids []interface{}) {
+	cursor := ""
+	for pages := 0; pages <= len(documents); pages++ {
+		q := query.New()
+		q.Sort = sortFields
+		q.Limit = size
+		if cursor != "" {
+			values, err := Decode(cursor, sortFields)
+			So(err, ShouldBeNil)
+			q.Where = After(sortFields, values)
+		}
+
+		results := q.Apply(documents)
+		for _, result := range results {
+			ids = append(ids, result["_id"])
+		}
+		if len(results) < size {
+			break
+		}
+		cursor = Encode(sortFields, results[len(results)-1])
+	}
+	return
+}
+
+func TestMissingValues(t *testing.T) {
+
+	Convey("Given documents some of which are missing the sort field", t, func() {
+		documents := []map[string]interface{}{
+			{"_id": "a", "n": 2.0},
+			{"_id": "b"},
+			{"_id": "c", "n": 1.0},
+			{"_id": "d", "n": nil},
+			{"_id": "e", "n": "text"},
+		}
+
+		Convey("Paging in ascending order should return the missing values first", func() {
+			sortFields := []query.SortField{{Field: "n"}, {Field: "_id"}}
+			So(page(documents, sortFields, 1), ShouldResemble, []interface{}{"b", "d", "c", "a", "e"})
+			So(page(documents, sortFields, 2), ShouldResemble, []interface{}{"b", "d", "c", "a", "e"})
+		})
+
+		Convey("Paging in descending order should return the missing values last", func() {
+			sortFields := []query.SortField{{Field: "n", Descending: true}, {Field: "_id"}}
+			So(page(documents, sortFields, 1), ShouldResemble, []interface{}{"e", "a", "c", "b", "d"})
+			So(page(documents, sortFields, 3), ShouldResemble, []interface{}{"e", "a", "c", "b", "d"})
+		})
+	})
+
+	Convey("Given documents sorted by times", t, func() {
+		now := time.Now().UTC()
+		documents := []map[string]interface{}{
+			{"_id": "a", "createdAt": now},
+			{"_id": "b", "createdAt": now.Add(-time.Hour)},
+			{"_id": "c"},
+		}
+
+		Convey("Times in cursors should be compared as times", func() {
+			sortFields := []query.SortField{{Field: "createdAt"}, {Field: "_id"}}
+			So(page(documents, sortFields, 1), ShouldResemble, []interface{}{"c", "b", "a"})
+		})
+	})
+}
@@ -0,0 +1,331 @@
+package patch
+
+import (
+	"strconv"
+	"strings"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+)
+
+type operation struct {
+	op    string
+	path  []string
+	from  []string
+	value interface{}
+}
+
+// Apply applies the JSON Patch operations to the document and returns the patched
+// document. Operations are applied all or none; the given document is not modified.
+// Returns an error with code 400 if the patch is malformed and 409 if it cannot be
+// applied to the document, ex: a path doesn't exist or a test operation fails.
+func Apply(document map[string]interface{}, operations []interface{}) (patched map[string]interface{}, err *utils.Error) {
+
+	parsed, err := parseOperations(operations)
+	if err != nil {
+		return
+	}
+
+	var result interface{} = utils.CopyDocument(document)
+	if document == nil {
+		result = make(map[string]interface{})
+	}
+	for _, operation := range parsed {
+		if result, err = applyOperation(result, operation); err != nil {
+			return
+		}
+	}
+
+	patched, isObject := result.(map[string]interface{})
+	if !isObject {
+		err = conflict("Patch must result in a JSON object.")
+	}
+	return
+}
+
+func parseOperations(operations []interface{}) (parsed []operation, err *utils.Error) {
+
+	parsed = make([]operation, len(operations))
+	for i, item := range operations {
+
+		object, isObject := item.(map[string]interface{})
+		if !isObject {
+			err = badRequest("Patch operation " + strconv.Itoa(i) + " must be a JSON object.")
+			return
+		}
+
+		op, _ := object["op"].(string)
+		path, hasPath := object["path"].(string)
+		if !hasPath {
+			err = badRequest("Patch operation " + strconv.Itoa(i) + " is missing 'path'.")
+			return
+		}
+		if parsed[i].path, err = parsePointer(path); err != nil {
+			return
+		}
+		parsed[i].op = op
+
+		switch op {
+		case "add", "replace", "test":
+			value, hasValue := object["value"]
+			if !hasValue {
+				err = badRequest("Patch operation " + strconv.Itoa(i) + " is missing 'value'.")
+				return
+			}
+			parsed[i].value = value
+		case "move", "copy":
+			from, hasFrom := object["from"].(string)
+			if !hasFrom {
+				err = badRequest("Patch operation " + strconv.Itoa(i) + " is missing 'from'.")
+				return
+			}
+			if parsed[i].from, err = parsePointer(from); err != nil {
+				return
+			}
+		case "remove":
+		default:
+			err = badRequest("Patch operation " + strconv.Itoa(i) + " has unknown op '" + op + "'.")
+			return
+		}
+	}
+	return
+}
+
+// parsePointer splits the JSON Pointer (RFC 6901) to its unescaped reference tokens.
+func parsePointer(pointer string) (tokens []string, err *utils.Error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		err = badRequest("Invalid JSON pointer '" + pointer + "'.")
+		return
+	}
+	tokens = strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return
+}
+
+func applyOperation(document interface{}, operation operation) (result interface{}, err *utils.Error) {
+
+	switch operation.op {
+	case "add":
+		return set(document, operation.path, utils.CopyValue(operation.value), true)
+	case "replace":
+		return set(document, operation.path, utils.CopyValue(operation.value), false)
+	case "remove":
+		result, _, err = remove(document, operation.path)
+		return
+	case "move":
+		if isPrefix(operation.from, operation.path) && len(operation.from) < len(operation.path) {
+			err = conflict("Cannot move a value into one of its children.")
+			return
+		}
+		var value interface{}
+		if result, value, err = remove(document, operation.from); err != nil {
+			return
+		}
+		return set(result, operation.path, value, true)
+	case "copy":
+		value, exists := get(document, operation.from)
+		if !exists {
+			err = conflict("Path '" + pointerString(operation.from) + "' does not exist.")
+			return
+		}
+		return set(document, operation.path, utils.CopyValue(value), true)
+	case "test":
+		value, exists := get(document, operation.path)
+		if !exists || !equal(value, operation.value) {
+			err = conflict("Test failed for path '" + pointerString(operation.path) + "'.")
+			return
+		}
+		return document, nil
+	}
+	return document, nil
+}
+
+func get(node interface{}, tokens []string) (value interface{}, exists bool) {
+	value = node
+	for _, token := range tokens {
+		switch n := value.(type) {
+		case map[string]interface{}:
+			if value, exists = n[token]; !exists {
+				return
+			}
+		case []interface{}:
+			index, isIndex := arrayIndex(token, len(n)-1)
+			if !isIndex {
+				return nil, false
+			}
+			value = n[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// set adds or replaces the value at the path and returns the modified node. Adding
+// to an array inserts the value; '-' as the last token appends it.
+func set(node interface{}, tokens []string, value interface{}, add bool) (result interface{}, err *utils.Error) {
+
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[token]
+		if last {
+			if !add && !exists {
+				return nil, conflict("Path '" + token + "' does not exist.")
+			}
+			n[token] = value
+			return n, nil
+		}
+		if !exists {
+			return nil, conflict("Path '" + token + "' does not exist.")
+		}
+		if n[token], err = set(child, tokens[1:], value, add); err != nil {
+			return
+		}
+		return n, nil
+
+	case []interface{}:
+		if last && add {
+			if token == "-" {
+				return append(n, value), nil
+			}
+			index, isIndex := arrayIndex(token, len(n))
+			if !isIndex {
+				return nil, conflict("Invalid array index '" + token + "'.")
+			}
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		index, isIndex := arrayIndex(token, len(n)-1)
+		if !isIndex {
+			return nil, conflict("Invalid array index '" + token + "'.")
+		}
+		if last {
+			n[index] = value
+			return n, nil
+		}
+		if n[index], err = set(n[index], tokens[1:], value, add); err != nil {
+			return
+		}
+		return n, nil
+	}
+	return nil, conflict("Path '" + token + "' does not exist.")
+}
+
+// remove removes the value at the path and returns the modified node and the removed value.
+func remove(node interface{}, tokens []string) (result interface{}, removed interface{}, err *utils.Error) {
+
+	if len(tokens) == 0 {
+		return nil, nil, conflict("Cannot remove the whole document.")
+	}
+
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[token]
+		if !exists {
+			return nil, nil, conflict("Path '" + token + "' does not exist.")
+		}
+		if last {
+			delete(n, token)
+			return n, child, nil
+		}
+		if n[token], removed, err = remove(child, tokens[1:]); err != nil {
+			return
+		}
+		return n, removed, nil
+
+	case []interface{}:
+		index, isIndex := arrayIndex(token, len(n)-1)
+		if !isIndex {
+			return nil, nil, conflict("Invalid array index '" + token + "'.")
+		}
+		if last {
+			removed = n[index]
+			return append(n[:index], n[index+1:]...), removed, nil
+		}
+		if n[index], removed, err = remove(n[index], tokens[1:]); err != nil {
+			return
+		}
+		return n, removed, nil
+	}
+	return nil, nil, conflict("Path '" + token + "' does not exist.")
+}
+
+// arrayIndex parses the token as an array index between 0 and max.
+func arrayIndex(token string, max int) (index int, isIndex bool) {
+	if len(token) == 0 || (len(token) > 1 && token[0] == '0') {
+		return
+	}
+	index, convertErr := strconv.Atoi(token)
+	isIndex = convertErr == nil && index >= 0 && index <= max
+	return
+}
+
+func isPrefix(prefix, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	aObject, isObject := a.(map[string]interface{})
+	if isObject {
+		bObject, isObject := b.(map[string]interface{})
+		if !isObject || len(aObject) != len(bObject) {
+			return false
+		}
+		for key, value := range aObject {
+			if other, exists := bObject[key]; !exists || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+
+	aArray, isArray := a.([]interface{})
+	if isArray {
+		bArray, isArray := b.([]interface{})
+		if !isArray || len(aArray) != len(bArray) {
+			return false
+		}
+		for i := range aArray {
+			if !equal(aArray[i], bArray[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return query.Equals(a, b)
+}
+
+func pointerString(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		escaped[i] = strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
@@ -0,0 +1,66 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON objects decoded as map[string]interface{}.
+package patch
+
+import (
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/utils"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// IsJSONPatch tells whether the content type header denotes a JSON Patch document.
+func IsJSONPatch(contentType string) bool {
+	return strings.EqualFold(mediaType(contentType), JSONPatchContentType)
+}
+
+// IsMergePatch tells whether the content type header denotes a merge patch.
+func IsMergePatch(contentType string) bool {
+	return strings.EqualFold(mediaType(contentType), MergePatchContentType)
+}
+
+// Merge applies the merge patch to the document and returns the patched document.
+// Null values in the patch remove the fields, objects are merged recursively and
+// other values replace the fields. The given document is not modified.
+func Merge(document, patch map[string]interface{}) map[string]interface{} {
+	merged, _ := merge(utils.CopyValue(document), patch).(map[string]interface{})
+	return merged
+}
+
+func merge(target, patch interface{}) interface{} {
+
+	patchObject, isObject := patch.(map[string]interface{})
+	if !isObject {
+		return utils.CopyValue(patch)
+	}
+
+	targetObject, isObject := target.(map[string]interface{})
+	if !isObject || targetObject == nil {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = merge(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+func mediaType(contentType string) string {
+	return strings.TrimSpace(strings.Split(contentType, ";")[0])
+}
+
+func badRequest(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusBadRequest, Message: message}
+}
+
+func conflict(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusConflict, Message: message}
+}
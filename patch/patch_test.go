@@ -0,0 +1,105 @@
+package patch
+
+import (
+	"testing"
+	"net/http"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func decode(text string) (value interface{}) {
+	json.Unmarshal([]byte(text), &value)
+	return
+}
+
+func TestMerge(t *testing.T) {
+
+	Convey("Given a document", t, func() {
+		document := decode(`{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"text"}`).(map[string]interface{})
+
+		Convey("When a merge patch is applied", func() {
+			merged := Merge(document, decode(`{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`).(map[string]interface{}))
+
+			Convey("It should replace, add, remove and merge fields", func() {
+				So(merged, ShouldResemble, decode(`{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"text","phoneNumber":"+01-123-456-7890"}`))
+			})
+
+			Convey("The given document should not be modified", func() {
+				So(document["title"], ShouldEqual, "Goodbye!")
+				So(document["author"].(map[string]interface{}), ShouldContainKey, "familyName")
+			})
+		})
+	})
+}
+
+func TestApply(t *testing.T) {
+
+	Convey("Given a document", t, func() {
+		document := decode(`{"foo":"bar","baz":[1,2,3],"nested":{"a":{"b":1}}}`).(map[string]interface{})
+
+		Convey("When valid operations are applied", func() {
+			patched, err := Apply(document, decode(`[
+				{"op":"test","path":"/foo","value":"bar"},
+				{"op":"add","path":"/baz/1","value":9},
+				{"op":"add","path":"/baz/-","value":4},
+				{"op":"remove","path":"/baz/0"},
+				{"op":"replace","path":"/foo","value":"qux"},
+				{"op":"move","from":"/nested/a/b","path":"/moved"},
+				{"op":"copy","from":"/moved","path":"/nested/copied"}
+			]`).([]interface{}))
+
+			Convey("It should apply them in order", func() {
+				So(err, ShouldBeNil)
+				So(patched, ShouldResemble, decode(`{"foo":"qux","baz":[9,2,3,4],"nested":{"a":{},"copied":1},"moved":1}`))
+			})
+
+			Convey("The given document should not be modified", func() {
+				So(document, ShouldResemble, decode(`{"foo":"bar","baz":[1,2,3],"nested":{"a":{"b":1}}}`))
+			})
+		})
+
+		Convey("When a test operation fails", func() {
+			_, err := Apply(document, decode(`[{"op":"replace","path":"/foo","value":"qux"},{"op":"test","path":"/foo","value":"bar"}]`).([]interface{}))
+
+			Convey("It should return conflict", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+
+		Convey("When a path does not exist", func() {
+			_, err := Apply(document, decode(`[{"op":"replace","path":"/missing","value":1}]`).([]interface{}))
+
+			Convey("It should return conflict", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+
+		Convey("When an operation is malformed", func() {
+			_, err := Apply(document, decode(`[{"op":"jump","path":"/foo"}]`).([]interface{}))
+
+			Convey("It should return bad request", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("Escaped pointer tokens should be unescaped", func() {
+			patched, err := Apply(map[string]interface{}{"a/b": 1.0, "m~n": 2.0}, decode(`[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`).([]interface{}))
+			So(err, ShouldBeNil)
+			So(len(patched), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestContentTypes(t *testing.T) {
+
+	Convey("Content types should be detected with parameters", t, func() {
+		So(IsJSONPatch("application/json-patch+json; charset=utf-8"), ShouldBeTrue)
+		So(IsMergePatch("application/merge-patch+json"), ShouldBeTrue)
+		So(IsMergePatch("application/json"), ShouldBeFalse)
+		So(IsMergePatch(""), ShouldBeFalse)
+		So(IsMergePatch("text/plain"), ShouldBeFalse)
+	})
+}
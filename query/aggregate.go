@@ -0,0 +1,238 @@
+package query
+
+import (
+	"sort"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+)
+
+// Names of the parameters of aggregations, in addition to where, sort, limit and skip.
+//
+//   group:     comma separated fields to group the documents by, ex: team,city
+//   aggregate: JSON object of the values to compute for each group, ex:
+//              {"players":{"$count":true},"totalAge":{"$sum":"age"},"cities":{"$distinct":"city"}}
+const (
+	GroupParameter     = "group"
+	AggregateParameter = "aggregate"
+)
+
+type Accumulator string
+
+// $count counts the documents, or the documents having the field if a field is given. $sum and
+// $avg use only the numbers and $min and $max the values which are not null. $distinct lists
+// the distinct values in the order they are found.
+const (
+	Count    Accumulator = "$count"
+	Sum      Accumulator = "$sum"
+	Avg      Accumulator = "$avg"
+	Min      Accumulator = "$min"
+	Max      Accumulator = "$max"
+	Distinct Accumulator = "$distinct"
+)
+
+var accumulators = map[Accumulator]bool{Count: true, Sum: true, Avg: true, Min: true, Max: true, Distinct: true}
+
+// Aggregate is a value computed for each group, ex: totalAge is the $sum of age.
+type Aggregate struct {
+	Name        string
+	Accumulator Accumulator
+	Field       string // may be empty for Count
+}
+
+// Aggregation groups the documents matching Where by the GroupBy fields and computes the
+// aggregates of each group. Every result has the group fields and the aggregates. Without
+// GroupBy, the matching documents are a single group. Sort, Skip and Limit apply to the results.
+type Aggregation struct {
+	Where      Condition
+	GroupBy    []string
+	Aggregates []Aggregate
+	Sort       []SortField
+	Limit      int // negative means no limit
+	Skip       int
+}
+
+// ParseAggregation parses the aggregation parameters. Returns an error with code 400 if
+// any of the parameters is malformed.
+func ParseAggregation(parameters map[string][]string) (aggregation Aggregation, err *utils.Error) {
+
+	q, err := Parse(parameters)
+	if err != nil {
+		return
+	}
+	aggregation = Aggregation{Where: q.Where, Sort: q.Sort, Limit: q.Limit, Skip: q.Skip}
+
+	if values := q.Extras[GroupParameter]; len(values) > 0 {
+		aggregation.GroupBy = ParseList(values[0])
+	}
+	if values := q.Extras[AggregateParameter]; len(values) > 0 && values[0] != "" {
+		if aggregation.Aggregates, err = parseAggregates(values[0]); err != nil {
+			return
+		}
+	}
+
+	if len(aggregation.GroupBy) == 0 && len(aggregation.Aggregates) == 0 {
+		err = badRequest("Aggregation needs '" + GroupParameter + "' or '" + AggregateParameter + "' parameter.")
+		return
+	}
+	for _, aggregate := range aggregation.Aggregates {
+		for _, field := range aggregation.GroupBy {
+			if aggregate.Name == field {
+				err = badRequest("Aggregate '" + aggregate.Name + "' has the name of a group field.")
+				return
+			}
+		}
+	}
+	return
+}
+
+func parseAggregates(value string) (aggregates []Aggregate, err *utils.Error) {
+
+	var object map[string]map[string]interface{}
+	if decodeErr := json.Unmarshal([]byte(value), &object); decodeErr != nil {
+		err = badRequest("Parsing '" + AggregateParameter + "' parameter failed. Reason: " + decodeErr.Error())
+		return
+	}
+
+	for name, definition := range object {
+		if name == "" || len(definition) != 1 {
+			err = badRequest("Aggregate '" + name + "' must have a single accumulator, ex: {\"$sum\":\"field\"}.")
+			return
+		}
+		for key, argument := range definition {
+			accumulator := Accumulator(key)
+			if !accumulators[accumulator] {
+				err = badRequest("Aggregate '" + name + "' has unknown accumulator '" + key + "'.")
+				return
+			}
+			field, isField := argument.(string)
+			if accumulator != Count && (!isField || field == "") {
+				err = badRequest("Aggregate '" + name + "' must have a field name.")
+				return
+			}
+			aggregates = append(aggregates, Aggregate{Name: name, Accumulator: accumulator, Field: field})
+		}
+	}
+
+	// object keys have no order, results are compared in tests and cached by the providers
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Name < aggregates[j].Name })
+	return
+}
+
+// Apply runs the aggregation on the documents in memory.
+func (a Aggregation) Apply(documents []map[string]interface{}) (results []map[string]interface{}) {
+
+	groups := make(map[string]*group)
+	order := make([]*group, 0)
+	for _, document := range documents {
+		if a.Where != nil && !a.Where.Match(document) {
+			continue
+		}
+
+		values := make([]interface{}, len(a.GroupBy))
+		for i, field := range a.GroupBy {
+			values[i], _ = Lookup(document, field)
+		}
+		key, _ := json.Marshal(values)
+
+		current, exists := groups[string(key)]
+		if !exists {
+			current = &group{values: values, states: make([]state, len(a.Aggregates))}
+			groups[string(key)] = current
+			order = append(order, current)
+		}
+		for i, aggregate := range a.Aggregates {
+			current.states[i].add(aggregate, document)
+		}
+	}
+
+	// every document is in a single group when there are no group fields, even if there are none
+	if len(a.GroupBy) == 0 && len(order) == 0 {
+		order = append(order, &group{states: make([]state, len(a.Aggregates))})
+	}
+
+	results = make([]map[string]interface{}, 0, len(order))
+	for _, current := range order {
+		result := make(map[string]interface{}, len(a.GroupBy)+len(a.Aggregates))
+		for i, field := range a.GroupBy {
+			result[field] = current.values[i]
+		}
+		for i, aggregate := range a.Aggregates {
+			result[aggregate.Name] = current.states[i].result(aggregate)
+		}
+		results = append(results, result)
+	}
+
+	SortDocuments(results, a.Sort)
+
+	skip := a.Skip
+	if skip > len(results) {
+		skip = len(results)
+	}
+	results = results[skip:]
+	if a.Limit >= 0 && a.Limit < len(results) {
+		results = results[:a.Limit]
+	}
+	return
+}
+
+type group struct {
+	values []interface{}
+	states []state
+}
+
+// state is the running value of an aggregate of a group.
+type state struct {
+	count    int
+	sum      float64
+	numbers  int
+	value    interface{}
+	distinct []interface{}
+}
+
+func (s *state) add(aggregate Aggregate, document map[string]interface{}) {
+
+	value, exists := Lookup(document, aggregate.Field)
+	switch aggregate.Accumulator {
+	case Count:
+		if aggregate.Field == "" || (exists && value != nil) {
+			s.count++
+		}
+	case Sum, Avg:
+		if number, isNumber := toFloat(value); isNumber {
+			s.sum += number
+			s.numbers++
+		}
+	case Min, Max:
+		if value == nil {
+			return
+		}
+		result := Compare(value, s.value)
+		if s.value == nil || (aggregate.Accumulator == Min && result < 0) || (aggregate.Accumulator == Max && result > 0) {
+			s.value = value
+		}
+	case Distinct:
+		if exists && !contains(s.distinct, value) {
+			s.distinct = append(s.distinct, value)
+		}
+	}
+}
+
+func (s *state) result(aggregate Aggregate) interface{} {
+	switch aggregate.Accumulator {
+	case Count:
+		return s.count
+	case Sum:
+		return s.sum
+	case Avg:
+		if s.numbers == 0 {
+			return nil
+		}
+		return s.sum / float64(s.numbers)
+	case Distinct:
+		if s.distinct == nil {
+			return []interface{}{}
+		}
+		return s.distinct
+	}
+	return s.value
+}
@@ -0,0 +1,332 @@
+package query
+
+import (
+	"math"
+	"sort"
+	"time"
+	"strings"
+	"reflect"
+	"encoding/json"
+)
+
+func (c Comparison) Match(document map[string]interface{}) bool {
+
+	value, exists := Lookup(document, c.Field)
+
+	switch c.Operator {
+	case Equal:
+		return Equals(value, c.Value)
+	case NotEqual:
+		return !Equals(value, c.Value)
+	case GreaterThan:
+		result, comparable := compareSameKind(value, c.Value)
+		return comparable && result > 0
+	case GreaterThanOrEqual:
+		result, comparable := compareSameKind(value, c.Value)
+		return comparable && result >= 0
+	case LessThan:
+		result, comparable := compareSameKind(value, c.Value)
+		return comparable && result < 0
+	case LessThanOrEqual:
+		result, comparable := compareSameKind(value, c.Value)
+		return comparable && result <= 0
+	case In:
+		return contains(c.Value, value)
+	case NotIn:
+		return !contains(c.Value, value)
+	case Exists:
+		expected, _ := c.Value.(bool)
+		return exists == expected
+	}
+	return false
+}
+
+func (a And) Match(document map[string]interface{}) bool {
+	for _, condition := range a {
+		if !condition.Match(document) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o Or) Match(document map[string]interface{}) bool {
+	for _, condition := range o {
+		if condition.Match(document) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs the query on the documents: filters, sorts, skips, limits
+// and projects them. The given slice is not modified but the documents
+// in the result are the given documents unless fields are projected.
+func (q Query) Apply(documents []map[string]interface{}) (results []map[string]interface{}) {
+
+	results = make([]map[string]interface{}, 0)
+	for _, document := range documents {
+		if q.Where == nil || q.Where.Match(document) {
+			results = append(results, document)
+		}
+	}
+
+	SortDocuments(results, q.Sort)
+
+	skip := q.Skip
+	if skip > len(results) {
+		skip = len(results)
+	}
+	results = results[skip:]
+	if q.Limit >= 0 && q.Limit < len(results) {
+		results = results[:q.Limit]
+	}
+
+	if len(q.Fields) > 0 {
+		for i, document := range results {
+			results[i] = Project(document, q.Fields)
+		}
+	}
+	return
+}
+
+// SortDocuments sorts the documents in place by the given fields. Documents
+// which are equal on every field keep their order.
+func SortDocuments(documents []map[string]interface{}, sortFields []SortField) {
+	if len(sortFields) == 0 {
+		return
+	}
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, sortField := range sortFields {
+			a, _ := Lookup(documents[i], sortField.Field)
+			b, _ := Lookup(documents[j], sortField.Field)
+
+			result := Compare(a, b)
+			if result == 0 {
+				continue
+			}
+			if sortField.Descending {
+				return result > 0
+			}
+			return result < 0
+		}
+		return false
+	})
+}
+
+// Project returns a new document with only the given fields of the document.
+func Project(document map[string]interface{}, fields []string) map[string]interface{} {
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, exists := document[field]; exists {
+			projected[field] = value
+		}
+	}
+	return projected
+}
+
+// Lookup returns the value of the field in the document. Fields
+// of embedded objects are separated with dots, ex: address.city
+func Lookup(document map[string]interface{}, field string) (value interface{}, exists bool) {
+
+	if value, exists = document[field]; exists || !strings.Contains(field, ".") {
+		return
+	}
+
+	current := document
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		value, exists = current[part]
+		if !exists || i == len(parts)-1 {
+			return
+		}
+		if current, exists = value.(map[string]interface{}); !exists {
+			value = nil
+			return
+		}
+	}
+	return
+}
+
+// Equals compares values regardless of their numeric types. Times are
+// also equal to their RFC 3339 representations.
+func Equals(a, b interface{}) bool {
+	a, b = coerceTimes(a, b)
+	if aNumber, isNumber := toFloat(a); isNumber {
+		bNumber, isNumber := toFloat(b)
+		return isNumber && aNumber == bNumber
+	}
+	if aTime, isTime := a.(time.Time); isTime {
+		bTime, isTime := b.(time.Time)
+		return isTime && aTime.Equal(bTime)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare orders nil values first, then numbers, strings, booleans and times.
+// Values of different kinds are ordered by kind.
+func Compare(a, b interface{}) int {
+	aRank, bRank := rank(a), rank(b)
+	if aRank != bRank {
+		return aRank - bRank
+	}
+
+	switch aRank {
+	case 1:
+		aNumber, _ := toFloat(a)
+		bNumber, _ := toFloat(b)
+		if aNumber < bNumber {
+			return -1
+		} else if aNumber > bNumber {
+			return 1
+		}
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		if a.(bool) == b.(bool) {
+			return 0
+		} else if !a.(bool) {
+			return -1
+		}
+		return 1
+	case 4:
+		if a.(time.Time).Before(b.(time.Time)) {
+			return -1
+		} else if a.(time.Time).After(b.(time.Time)) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Follows returns the condition matching the documents whose value of the field comes after the
+// value in the order of Compare, or before it if descending is true. Values of other kinds are
+// matched by their kind, ex: every number comes after nil. Returns nil if no value can follow
+// the value. Objects and arrays have no order, so they are matched only after nil.
+func Follows(field string, value interface{}, descending bool) Condition {
+
+	valueRank := rank(value)
+	var conditions Or
+	if !descending {
+		if valueRank == 0 {
+			return Comparison{Field: field, Operator: NotEqual, Value: nil}
+		}
+		if valueRank < 5 {
+			conditions = append(conditions, Comparison{Field: field, Operator: GreaterThan, Value: value})
+			for kind := valueRank + 1; kind < 5; kind++ {
+				conditions = append(conditions, ofKind(field, kind))
+			}
+		}
+	} else {
+		if valueRank > 0 && valueRank < 5 {
+			conditions = append(conditions, Comparison{Field: field, Operator: LessThan, Value: value})
+		}
+		for kind := valueRank - 1; kind >= 0; kind-- {
+			conditions = append(conditions, ofKind(field, kind))
+		}
+	}
+
+	if len(conditions) == 0 {
+		return nil
+	} else if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return conditions
+}
+
+// ofKind returns the condition matching every value of the rank, comparing them with the
+// smallest value of their kind.
+func ofKind(field string, kind int) Condition {
+	switch kind {
+	case 1:
+		return Comparison{Field: field, Operator: GreaterThanOrEqual, Value: -math.MaxFloat64}
+	case 2:
+		return Comparison{Field: field, Operator: GreaterThanOrEqual, Value: ""}
+	case 3:
+		return Comparison{Field: field, Operator: GreaterThanOrEqual, Value: false}
+	case 4:
+		return Comparison{Field: field, Operator: GreaterThanOrEqual, Value: time.Time{}}
+	}
+	return Comparison{Field: field, Operator: Equal, Value: nil}
+}
+
+// compareSameKind compares values for the range operators, which
+// don't match values of different kinds or values without order.
+func compareSameKind(a, b interface{}) (result int, comparable bool) {
+	a, b = coerceTimes(a, b)
+	aRank := rank(a)
+	if aRank == 0 || aRank == 5 || aRank != rank(b) {
+		return
+	}
+	return Compare(a, b), true
+}
+
+func rank(value interface{}) int {
+	if value == nil {
+		return 0
+	}
+	if _, isNumber := toFloat(value); isNumber {
+		return 1
+	}
+	switch value.(type) {
+	case string:
+		return 2
+	case bool:
+		return 3
+	case time.Time:
+		return 4
+	}
+	return 5
+}
+
+// coerceTimes parses the string to time if the other value is a time,
+// since times in where clauses can only be given as strings.
+func coerceTimes(a, b interface{}) (interface{}, interface{}) {
+	if _, isTime := a.(time.Time); isTime {
+		if text, isString := b.(string); isString {
+			if parsed, parseErr := time.Parse(time.RFC3339Nano, text); parseErr == nil {
+				b = parsed
+			}
+		}
+	} else if _, isTime := b.(time.Time); isTime {
+		if text, isString := a.(string); isString {
+			if parsed, parseErr := time.Parse(time.RFC3339Nano, text); parseErr == nil {
+				a = parsed
+			}
+		}
+	}
+	return a, b
+}
+
+func contains(list, value interface{}) bool {
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		if Equals(value, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(value interface{}) (number float64, isNumber bool) {
+	isNumber = true
+	switch v := value.(type) {
+	case float64:
+		number = v
+	case float32:
+		number = float64(v)
+	case int:
+		number = float64(v)
+	case int32:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	case json.Number:
+		var parseErr error
+		number, parseErr = v.Float64()
+		isNumber = parseErr == nil
+	default:
+		isNumber = false
+	}
+	return
+}
@@ -0,0 +1,358 @@
+// Package query parses the query parameters of collection requests into a typed
+// syntax tree, so that every data provider interprets them the same way.
+//
+// Supported parameters:
+//
+//   where:  JSON object of conditions, ex: {"age":{"$gte":18},"$or":[{"team":"red"},{"team":"blue"}]}
+//   sort:   comma separated field names, prefixed with '-' for descending order, ex: -age,name
+//   limit:  maximum number of results, non-negative integer
+//   skip:   number of results to skip, non-negative integer
+//   fields: comma separated field names to return, ex: name,email
+//
+// A condition is either a field with a value, which means equality, or a field with
+// an object of operators. Supported operators are $eq, $ne, $gt, $gte, $lt, $lte,
+// $in, $nin and $exists. Conditions can be combined with $and and $or, which take
+// an array of where objects. Fields of embedded objects are reached with dots,
+// ex: {"address.city":"istanbul"}.
+package query
+
+import (
+	"strings"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+)
+
+// Names of the parameters that make up a query.
+const (
+	WhereParameter  = "where"
+	SortParameter   = "sort"
+	LimitParameter  = "limit"
+	SkipParameter   = "skip"
+	FieldsParameter = "fields"
+)
+
+type Operator string
+
+const (
+	Equal              Operator = "$eq"
+	NotEqual           Operator = "$ne"
+	GreaterThan        Operator = "$gt"
+	GreaterThanOrEqual Operator = "$gte"
+	LessThan           Operator = "$lt"
+	LessThanOrEqual    Operator = "$lte"
+	In                 Operator = "$in"
+	NotIn              Operator = "$nin"
+	Exists             Operator = "$exists"
+)
+
+const (
+	andKey = "$and"
+	orKey  = "$or"
+)
+
+var operators = map[Operator]bool{
+	Equal:              true,
+	NotEqual:           true,
+	GreaterThan:        true,
+	GreaterThanOrEqual: true,
+	LessThan:           true,
+	LessThanOrEqual:    true,
+	In:                 true,
+	NotIn:              true,
+	Exists:             true,
+}
+
+// Condition is a node of the where clause. It is one of Comparison, And or Or.
+type Condition interface {
+	Match(document map[string]interface{}) bool
+}
+
+// Comparison compares the value of a field with the given value. For In and NotIn
+// the value is a []interface{}, for Exists it is a bool.
+type Comparison struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+// And matches if all of its conditions match.
+type And []Condition
+
+// Or matches if any of its conditions matches.
+type Or []Condition
+
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+type Query struct {
+	Where  Condition // nil matches every document
+	Sort   []SortField
+	Limit  int // negative means no limit
+	Skip   int
+	Fields []string // empty means all fields
+
+	// Extras keeps the parameters which are not part of the query syntax.
+	Extras map[string][]string
+}
+
+// New returns a query which matches every document without a limit.
+func New() Query {
+	return Query{Limit: -1}
+}
+
+// Parse parses the query parameters. Returns an error with code 400 if
+// any of the parameters is malformed.
+func Parse(parameters map[string][]string) (query Query, err *utils.Error) {
+
+	query = New()
+	for name, values := range parameters {
+
+		value := ""
+		if len(values) > 0 {
+			value = values[0]
+		}
+
+		switch name {
+		case WhereParameter:
+			if len(value) > 0 {
+				query.Where, err = parseWhere(value)
+			}
+		case SortParameter:
+			query.Sort, err = parseSort(value)
+		case LimitParameter:
+			query.Limit, err = parseCount(name, value)
+		case SkipParameter:
+			query.Skip, err = parseCount(name, value)
+		case FieldsParameter:
+			query.Fields = ParseList(value)
+		default:
+			if query.Extras == nil {
+				query.Extras = make(map[string][]string)
+			}
+			query.Extras[name] = values
+		}
+
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Parameters encodes the query back to query parameters, including the extras.
+func (q Query) Parameters() (parameters map[string][]string) {
+
+	parameters = make(map[string][]string)
+	for name, values := range q.Extras {
+		parameters[name] = values
+	}
+
+	if q.Where != nil {
+		bytes, _ := json.Marshal(encodeCondition(q.Where))
+		parameters[WhereParameter] = []string{string(bytes)}
+	}
+	if len(q.Sort) > 0 {
+		fields := make([]string, len(q.Sort))
+		for i, sortField := range q.Sort {
+			fields[i] = sortField.Field
+			if sortField.Descending {
+				fields[i] = "-" + fields[i]
+			}
+		}
+		parameters[SortParameter] = []string{strings.Join(fields, ",")}
+	}
+	if q.Limit >= 0 {
+		parameters[LimitParameter] = []string{strconv.Itoa(q.Limit)}
+	}
+	if q.Skip > 0 {
+		parameters[SkipParameter] = []string{strconv.Itoa(q.Skip)}
+	}
+	if len(q.Fields) > 0 {
+		parameters[FieldsParameter] = []string{strings.Join(q.Fields, ",")}
+	}
+	return
+}
+
+func parseWhere(value string) (condition Condition, err *utils.Error) {
+	var where interface{}
+	if decodeErr := json.Unmarshal([]byte(value), &where); decodeErr != nil {
+		err = badRequest("Parsing 'where' parameter failed. Reason: " + decodeErr.Error())
+		return
+	}
+	return parseConditions(where)
+}
+
+// parseConditions parses a where object. Multiple conditions in the object are combined with And.
+func parseConditions(where interface{}) (condition Condition, err *utils.Error) {
+
+	object, isObject := where.(map[string]interface{})
+	if !isObject {
+		err = badRequest("Where clause must be a JSON object.")
+		return
+	}
+
+	conditions := make(And, 0, len(object))
+	for key, value := range object {
+
+		var parsed Condition
+		switch {
+		case key == andKey || key == orKey:
+			var list []Condition
+			if list, err = parseConditionList(key, value); err != nil {
+				return
+			}
+			if key == andKey {
+				parsed = And(list)
+			} else {
+				parsed = Or(list)
+			}
+		case strings.HasPrefix(key, "$"):
+			err = badRequest("Unknown operator '" + key + "' in where clause.")
+			return
+		default:
+			if parsed, err = parseField(key, value); err != nil {
+				return
+			}
+		}
+		conditions = append(conditions, parsed)
+	}
+
+	if len(conditions) == 1 {
+		condition = conditions[0]
+	} else if len(conditions) > 1 {
+		condition = conditions
+	}
+	return
+}
+
+func parseConditionList(key string, value interface{}) (list []Condition, err *utils.Error) {
+
+	items, isArray := value.([]interface{})
+	if !isArray || len(items) == 0 {
+		err = badRequest("Operator '" + key + "' requires a non-empty array of where clauses.")
+		return
+	}
+
+	list = make([]Condition, len(items))
+	for i, item := range items {
+		if list[i], err = parseConditions(item); err != nil {
+			return
+		}
+		if list[i] == nil {
+			err = badRequest("Operator '" + key + "' does not accept empty where clauses.")
+			return
+		}
+	}
+	return
+}
+
+// parseField parses the condition of a single field. The value is either compared
+// for equality or is an object of operators, which are combined with And.
+func parseField(field string, value interface{}) (condition Condition, err *utils.Error) {
+
+	object, isObject := value.(map[string]interface{})
+	if !isObject || !hasOperatorKeys(object) {
+		condition = Comparison{field, Equal, value}
+		return
+	}
+
+	comparisons := make(And, 0, len(object))
+	for key, operand := range object {
+
+		operator := Operator(key)
+		if !operators[operator] {
+			err = badRequest("Unknown operator '" + key + "' for field '" + field + "'.")
+			return
+		}
+
+		switch operator {
+		case In, NotIn:
+			if _, isArray := operand.([]interface{}); !isArray {
+				err = badRequest("Operator '" + key + "' for field '" + field + "' requires an array.")
+				return
+			}
+		case Exists:
+			if _, isBool := operand.(bool); !isBool {
+				err = badRequest("Operator '" + key + "' for field '" + field + "' requires a boolean.")
+				return
+			}
+		}
+		comparisons = append(comparisons, Comparison{field, operator, operand})
+	}
+
+	if len(comparisons) == 1 {
+		condition = comparisons[0]
+	} else {
+		condition = comparisons
+	}
+	return
+}
+
+// hasOperatorKeys tells whether the object is an operator object rather than a value.
+func hasOperatorKeys(object map[string]interface{}) bool {
+	for key := range object {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSort(value string) (sortFields []SortField, err *utils.Error) {
+	for _, field := range ParseList(value) {
+		descending := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+		if len(field) == 0 {
+			err = badRequest("Parameter 'sort' contains an empty field name.")
+			return
+		}
+		sortFields = append(sortFields, SortField{field, descending})
+	}
+	return
+}
+
+func parseCount(name, value string) (count int, err *utils.Error) {
+	count, convertErr := strconv.Atoi(value)
+	if convertErr != nil || count < 0 {
+		err = badRequest("Parameter '" + name + "' must be a non-negative integer.")
+	}
+	return
+}
+
+// ParseList splits the comma separated list, ex: the value of the fields parameter.
+func ParseList(value string) (list []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+func encodeCondition(condition Condition) interface{} {
+	switch c := condition.(type) {
+	case Comparison:
+		return map[string]interface{}{c.Field: map[string]interface{}{string(c.Operator): c.Value}}
+	case And:
+		return map[string]interface{}{andKey: encodeConditionList(c)}
+	case Or:
+		return map[string]interface{}{orKey: encodeConditionList(c)}
+	}
+	return nil
+}
+
+func encodeConditionList(conditions []Condition) []interface{} {
+	list := make([]interface{}, len(conditions))
+	for i, condition := range conditions {
+		list[i] = encodeCondition(condition)
+	}
+	return list
+}
+
+func badRequest(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusBadRequest, Message: message}
+}
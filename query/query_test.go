@@ -0,0 +1,193 @@
+package query
+
+import (
+	"testing"
+	"net/http"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	Convey("Given query parameters", t, func() {
+
+		Convey("When they are valid", func() {
+			q, err := Parse(map[string][]string{
+				"where":  {`{"age":{"$gte":18,"$lt":65},"$or":[{"team":"red"},{"team":{"$in":["blue"]}}]}`},
+				"sort":   {"-age,name"},
+				"limit":  {"10"},
+				"skip":   {"20"},
+				"fields": {"name, age"},
+				"custom": {"value"},
+			})
+
+			Convey("It should parse every part of the query", func() {
+				So(err, ShouldBeNil)
+				So(q.Where, ShouldHaveSameTypeAs, And{})
+				So(len(q.Where.(And)), ShouldEqual, 2)
+				So(q.Sort, ShouldResemble, []SortField{{"age", true}, {"name", false}})
+				So(q.Limit, ShouldEqual, 10)
+				So(q.Skip, ShouldEqual, 20)
+				So(q.Fields, ShouldResemble, []string{"name", "age"})
+				So(q.Extras["custom"], ShouldResemble, []string{"value"})
+			})
+
+			Convey("Encoded parameters should parse to the same query", func() {
+				parsed, err := Parse(q.Parameters())
+				So(err, ShouldBeNil)
+				So(parsed.Sort, ShouldResemble, q.Sort)
+				So(parsed.Limit, ShouldEqual, q.Limit)
+				So(parsed.Skip, ShouldEqual, q.Skip)
+				So(parsed.Fields, ShouldResemble, q.Fields)
+				So(parsed.Extras, ShouldResemble, q.Extras)
+
+				document := map[string]interface{}{"age": 30.0, "team": "blue"}
+				So(q.Where.Match(document), ShouldBeTrue)
+				So(parsed.Where.Match(document), ShouldBeTrue)
+			})
+		})
+
+		Convey("When there are no parameters", func() {
+			q, err := Parse(nil)
+
+			Convey("It should match everything without a limit", func() {
+				So(err, ShouldBeNil)
+				So(q.Where, ShouldBeNil)
+				So(q.Limit, ShouldBeLessThan, 0)
+			})
+		})
+
+		Convey("When they are malformed", func() {
+			malformed := []map[string][]string{
+				{"where": {"{"}},
+				{"where": {"[]"}},
+				{"where": {`{"age":{"$unknown":1}}`}},
+				{"where": {`{"$unknown":1}`}},
+				{"where": {`{"team":{"$in":"red"}}`}},
+				{"where": {`{"$or":[]}`}},
+				{"where": {`{"$or":[{}]}`}},
+				{"where": {`{"age":{"$exists":1}}`}},
+				{"limit": {"-1"}},
+				{"skip": {"abc"}},
+				{"sort": {"-"}},
+			}
+
+			Convey("It should return bad request", func() {
+				for _, parameters := range malformed {
+					_, err := Parse(parameters)
+					So(err, ShouldNotBeNil)
+					So(err.Code, ShouldEqual, http.StatusBadRequest)
+				}
+			})
+		})
+	})
+}
+
+func TestApply(t *testing.T) {
+
+	Convey("Given documents", t, func() {
+		documents := []map[string]interface{}{
+			{"name": "alice", "age": 30.0, "address": map[string]interface{}{"city": "istanbul"}},
+			{"name": "bob", "age": 25},
+			{"name": "carol", "age": 35.0, "address": map[string]interface{}{"city": "izmir"}},
+		}
+
+		Convey("A query on an embedded field should match by dot notation", func() {
+			q, _ := Parse(map[string][]string{"where": {`{"address.city":"izmir"}`}})
+			results := q.Apply(documents)
+			So(len(results), ShouldEqual, 1)
+			So(results[0]["name"], ShouldEqual, "carol")
+		})
+
+		Convey("A query should compare numbers regardless of their types", func() {
+			q, _ := Parse(map[string][]string{"where": {`{"age":{"$lt":30}}`}})
+			results := q.Apply(documents)
+			So(len(results), ShouldEqual, 1)
+			So(results[0]["name"], ShouldEqual, "bob")
+		})
+
+		Convey("$exists should match by the presence of the field", func() {
+			q, _ := Parse(map[string][]string{"where": {`{"address":{"$exists":false}}`}})
+			results := q.Apply(documents)
+			So(len(results), ShouldEqual, 1)
+			So(results[0]["name"], ShouldEqual, "bob")
+		})
+
+		Convey("A query should sort, page and project the documents", func() {
+			q, _ := Parse(map[string][]string{"sort": {"-age"}, "skip": {"1"}, "limit": {"1"}, "fields": {"name"}})
+			results := q.Apply(documents)
+			So(results, ShouldResemble, []map[string]interface{}{{"name": "alice"}})
+		})
+	})
+}
+
+func TestAggregation(t *testing.T) {
+
+	documents := []map[string]interface{}{
+		{"name": "alice", "team": "red", "age": 30.0, "city": "istanbul"},
+		{"name": "bob", "team": "blue", "age": 25.0, "city": "ankara"},
+		{"name": "carol", "team": "red", "age": 35.0, "city": "istanbul"},
+		{"name": "dave", "team": "red", "city": "izmir"},
+	}
+
+	Convey("Given aggregation parameters", t, func() {
+
+		Convey("When they group and aggregate", func() {
+			aggregation, err := ParseAggregation(map[string][]string{
+				"where":     {`{"city":{"$ne":"izmir"}}`},
+				"group":     {"team"},
+				"aggregate": {`{"players":{"$count":true},"totalAge":{"$sum":"age"},"averageAge":{"$avg":"age"},"youngest":{"$min":"age"},"cities":{"$distinct":"city"}}`},
+				"sort":      {"-players"},
+			})
+			So(err, ShouldBeNil)
+			So(aggregation.GroupBy, ShouldResemble, []string{"team"})
+			So(len(aggregation.Aggregates), ShouldEqual, 5)
+
+			Convey("Every group should have the values of its documents", func() {
+				results := aggregation.Apply(documents)
+				So(len(results), ShouldEqual, 2)
+				So(results[0], ShouldResemble, map[string]interface{}{
+					"team": "red", "players": 2, "totalAge": 65.0, "averageAge": 32.5, "youngest": 30.0, "cities": []interface{}{"istanbul"},
+				})
+				So(results[1]["team"], ShouldEqual, "blue")
+			})
+		})
+
+		Convey("When they only group", func() {
+			aggregation, err := ParseAggregation(map[string][]string{"group": {"city"}, "sort": {"city"}})
+			So(err, ShouldBeNil)
+
+			Convey("The results should be the distinct values", func() {
+				results := aggregation.Apply(documents)
+				So(results, ShouldResemble, []map[string]interface{}{{"city": "ankara"}, {"city": "istanbul"}, {"city": "izmir"}})
+			})
+		})
+
+		Convey("When they only aggregate", func() {
+			aggregation, err := ParseAggregation(map[string][]string{"aggregate": {`{"aged":{"$count":"age"},"oldest":{"$max":"age"}}`}})
+			So(err, ShouldBeNil)
+
+			Convey("All documents should be a single group", func() {
+				So(aggregation.Apply(documents), ShouldResemble, []map[string]interface{}{{"aged": 3, "oldest": 35.0}})
+			})
+
+			Convey("There should be a group even without documents", func() {
+				So(aggregation.Apply(nil), ShouldResemble, []map[string]interface{}{{"aged": 0, "oldest": nil}})
+			})
+		})
+
+		Convey("When they are malformed", func() {
+			for _, parameters := range []map[string][]string{
+				{},
+				{"aggregate": {`{"total":{"$sum":"age","$avg":"age"}}`}},
+				{"aggregate": {`{"total":{"$median":"age"}}`}},
+				{"aggregate": {`{"total":{"$sum":true}}`}},
+				{"aggregate": {`[]`}},
+				{"group": {"team"}, "aggregate": {`{"team":{"$count":true}}`}},
+			} {
+				_, err := ParseAggregation(parameters)
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
@@ -0,0 +1,115 @@
+// Package relations keeps the parent-child relations between collections which are
+// served as nested resources, ex: /users/{id}/books and /users/{id}/books/{bookId},
+// and the reference fields which hold the ids of documents in other collections.
+package relations
+
+import (
+	"sync"
+)
+
+type Relation struct {
+	// Parent is the collection of the parent documents, ex: users
+	Parent string
+
+	// Name is the path segment of the relation under a parent document, ex: books
+	Name string
+
+	// Collection is the collection of the child documents. If empty, Name is used.
+	Collection string
+
+	// ForeignKey is the field of the child documents which holds the id of the parent, ex: userId
+	ForeignKey string
+
+	// ExpandLimit is the maximum number of children embedded in a parent when the relation
+	// is expanded. Zero means expand.DefaultLimit.
+	ExpandLimit int
+}
+
+// ChildCollection returns the collection of the child documents.
+func (r Relation) ChildCollection() string {
+	if r.Collection == "" {
+		return r.Name
+	}
+	return r.Collection
+}
+
+// Reference is a field whose value is the id, or a list of ids, of documents in another collection.
+type Reference struct {
+	// Collection is the collection of the documents which have the field, ex: books
+	Collection string
+
+	// Field is the name of the field, ex: author
+	Field string
+
+	// Target is the collection of the referenced documents, ex: users
+	Target string
+}
+
+type key struct {
+	parent string
+	name   string
+}
+
+// Registry is safe for concurrent use. Its zero value is an empty registry.
+type Registry struct {
+	mutex      sync.RWMutex
+	relations  map[key]Relation
+	references map[key]Reference
+}
+
+// NewRegistry returns a registry with the given relations.
+func NewRegistry(relations ...Relation) *Registry {
+	registry := &Registry{}
+	for _, relation := range relations {
+		registry.Add(relation)
+	}
+	return registry
+}
+
+// Add registers the relation, replacing the relation with the same parent and name.
+func (r *Registry) Add(relation Relation) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.relations == nil {
+		r.relations = make(map[key]Relation)
+	}
+	r.relations[key{relation.Parent, relation.Name}] = relation
+}
+
+func (r *Registry) Remove(parent, name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.relations, key{parent, name})
+}
+
+func (r *Registry) Get(parent, name string) (relation Relation, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	relation, exists = r.relations[key{parent, name}]
+	return
+}
+
+// AddReference registers the reference, replacing the reference of the same field.
+func (r *Registry) AddReference(reference Reference) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.references == nil {
+		r.references = make(map[key]Reference)
+	}
+	r.references[key{reference.Collection, reference.Field}] = reference
+}
+
+func (r *Registry) RemoveReference(collection, field string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.references, key{collection, field})
+}
+
+func (r *Registry) GetReference(collection, field string) (reference Reference, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	reference, exists = r.references[key{collection, field}]
+	return
+}
@@ -0,0 +1,117 @@
+package relations
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given a registry with a relation", t, func() {
+		registry := NewRegistry(Relation{Parent: "users", Name: "books", ForeignKey: "userId"})
+
+		Convey("Get should return the relation by parent and name", func() {
+			relation, exists := registry.Get("users", "books")
+			So(exists, ShouldBeTrue)
+			So(relation.ChildCollection(), ShouldEqual, "books")
+
+			_, exists = registry.Get("books", "users")
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("Remove should unregister the relation", func() {
+			registry.Remove("users", "books")
+			_, exists := registry.Get("users", "books")
+			So(exists, ShouldBeFalse)
+		})
+	})
+}
+
+func TestScope(t *testing.T) {
+
+	Convey("Given a provider scoped to a parent", t, func() {
+		provider := &memory.Provider{}
+		relation := Relation{Parent: "users", Name: "favorites", Collection: "books", ForeignKey: "userId"}
+		scoped := Scope(provider, relation, "alice")
+
+		owned, _ := scoped.Create("books", map[string]interface{}{"title": "dune", "userId": "bob"})
+		ownedId := owned[dataprovider.IdField].(string)
+		other, _ := provider.Create("books", map[string]interface{}{"title": "emma", "userId": "bob"})
+		otherId := other[dataprovider.IdField].(string)
+
+		Convey("Create should set the foreign key to the parent", func() {
+			book, err := provider.Get("books", ownedId)
+			So(err, ShouldBeNil)
+			So(book["userId"], ShouldEqual, "alice")
+		})
+
+		Convey("Get should return only the children of the parent", func() {
+			_, err := scoped.Get("books", ownedId)
+			So(err, ShouldBeNil)
+
+			_, err = scoped.Get("books", otherId)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Query and Count should see only the children of the parent", func() {
+			response, err := scoped.Query("books", nil)
+			So(err, ShouldBeNil)
+			results, _ := dataprovider.Results(response)
+			So(len(results), ShouldEqual, 1)
+			So(results[0]["title"], ShouldEqual, "dune")
+
+			count, err := dataprovider.Count(scoped, "books", query.Comparison{Field: "title", Operator: query.Equal, Value: "emma"})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("Update should keep the foreign key", func() {
+			_, err := scoped.Update("books", ownedId, map[string]interface{}{"title": "dune messiah", "userId": "bob"})
+			So(err, ShouldBeNil)
+			book, _ := provider.Get("books", ownedId)
+			So(book["title"], ShouldEqual, "dune messiah")
+			So(book["userId"], ShouldEqual, "alice")
+		})
+
+		Convey("Modify should keep the foreign key", func() {
+			_, err := dataprovider.Modify(scoped, "books", ownedId, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				delete(document, "userId")
+				return document, nil
+			})
+			So(err, ShouldBeNil)
+			book, _ := provider.Get("books", ownedId)
+			So(book["userId"], ShouldEqual, "alice")
+		})
+
+		Convey("Update, Modify and Delete of another parent's child should return not found", func() {
+			_, err := scoped.Update("books", otherId, map[string]interface{}{"title": "x"})
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			_, err = dataprovider.Modify(scoped, "books", otherId, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				return document, nil
+			})
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			_, err = scoped.Delete("books", otherId)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			book, _ := provider.Get("books", otherId)
+			So(book["title"], ShouldEqual, "emma")
+		})
+
+		Convey("Other collections should not be scoped", func() {
+			_, err := scoped.Create("users", map[string]interface{}{"name": "carol"})
+			So(err, ShouldBeNil)
+			response, _ := scoped.Query("users", nil)
+			results, _ := dataprovider.Results(response)
+			So(len(results), ShouldEqual, 1)
+			So(results[0], ShouldNotContainKey, "userId")
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package relations
+
+import (
+	"io"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Scope returns a provider which sees only the children of the given parent document in the
+// child collection of the relation. Children are created with the foreign key set to the parent
+// id and the foreign key cannot be changed. Other collections are passed to the provider as is.
+//
+// Update and Delete check the parent before changing the child, which is atomic only if
+// the provider is a transaction.
+func Scope(provider dataprovider.Provider, relation Relation, parentId string) dataprovider.Provider {
+	return &scopedProvider{provider, relation.ChildCollection(), relation.ForeignKey, parentId}
+}
+
+type scopedProvider struct {
+	provider   dataprovider.Provider
+	collection string
+	foreignKey string
+	parentId   string
+}
+
+func (sp *scopedProvider) Connect() (err *utils.Error) {
+	return sp.provider.Connect()
+}
+
+func (sp *scopedProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if collection != sp.collection {
+		return sp.provider.Create(collection, data)
+	}
+	child := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		child[key] = value
+	}
+	child[sp.foreignKey] = sp.parentId
+	return sp.provider.Create(collection, child)
+}
+
+func (sp *scopedProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	response, err = sp.provider.Get(collection, id)
+	if err == nil && collection == sp.collection && !sp.owns(response) {
+		response, err = nil, notFound()
+	}
+	return
+}
+
+func (sp *scopedProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	if collection != sp.collection {
+		return sp.provider.Query(collection, parameters)
+	}
+	q, err := query.Parse(parameters)
+	if err != nil {
+		return
+	}
+	return sp.QueryStructured(collection, q)
+}
+
+func (sp *scopedProvider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if collection == sp.collection {
+		q.Where = sp.where(q.Where)
+	}
+	return dataprovider.QueryStructured(sp.provider, collection, q)
+}
+
+func (sp *scopedProvider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	if collection == sp.collection {
+		where = sp.where(where)
+	}
+	return dataprovider.Count(sp.provider, collection, where)
+}
+
+func (sp *scopedProvider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	if collection == sp.collection {
+		aggregation.Where = sp.where(aggregation.Where)
+	}
+	return dataprovider.Aggregate(sp.provider, collection, aggregation)
+}
+
+func (sp *scopedProvider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	if collection == sp.collection {
+		q.Where = sp.where(q.Where)
+	}
+	return dataprovider.Search(sp.provider, collection, text, q)
+}
+
+func (sp *scopedProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if collection != sp.collection {
+		return sp.provider.Update(collection, id, data)
+	}
+	if _, err = sp.Get(collection, id); err != nil {
+		return
+	}
+	changes := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != sp.foreignKey {
+			changes[key] = value
+		}
+	}
+	return sp.provider.Update(collection, id, changes)
+}
+
+func (sp *scopedProvider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if collection != sp.collection {
+		return dataprovider.Modify(sp.provider, collection, id, modify)
+	}
+	return dataprovider.Modify(sp.provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		if !sp.owns(document) {
+			return nil, notFound()
+		}
+		modified, err := modify(document)
+		if err != nil {
+			return nil, err
+		}
+		modified[sp.foreignKey] = sp.parentId
+		return modified, nil
+	})
+}
+
+func (sp *scopedProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if collection == sp.collection {
+		if _, err = sp.Get(collection, id); err != nil {
+			return
+		}
+	}
+	return sp.provider.Delete(collection, id)
+}
+
+func (sp *scopedProvider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if collection != sp.collection {
+		return dataprovider.DeleteIf(sp.provider, collection, id, check)
+	}
+	return dataprovider.DeleteIf(sp.provider, collection, id, func(document map[string]interface{}) *utils.Error {
+		if !sp.owns(document) {
+			return notFound()
+		}
+		return check(document)
+	})
+}
+
+func (sp *scopedProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return sp.provider.CreateFile(data)
+}
+
+func (sp *scopedProvider) GetFile(id string) (response []byte, err *utils.Error) {
+	return sp.provider.GetFile(id)
+}
+
+func (sp *scopedProvider) OpenFile(id string) (file files.File, err *utils.Error) {
+	return dataprovider.OpenFile(sp.provider, id)
+}
+
+func (sp *scopedProvider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.CreateFileWithInfo(sp.provider, info, data)
+}
+
+func (sp *scopedProvider) owns(document map[string]interface{}) bool {
+	return query.Equals(document[sp.foreignKey], sp.parentId)
+}
+
+func (sp *scopedProvider) where(where query.Condition) query.Condition {
+	parent := query.Comparison{Field: sp.foreignKey, Operator: query.Equal, Value: sp.parentId}
+	if where == nil {
+		return parent
+	}
+	return query.And{parent, where}
+}
+
+func notFound() *utils.Error {
+	return &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+}
@@ -0,0 +1,278 @@
+package replica
+
+import (
+	"io"
+	"context"
+	"sync/atomic"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Provider sends the reads to the replicas and the writes to the primary. It is a
+// dataprovider.ContextBinder, since the reads of a request depend on its writes.
+type Provider struct {
+	primary dataprovider.Provider
+	pool    *pool
+}
+
+// New returns a provider which reads from the replicas and writes to the primary. Use
+// NewTransactional to keep the transactions of the primary.
+func New(primary dataprovider.Provider, replicas ...dataprovider.Provider) *Provider {
+	return &Provider{primary: primary, pool: newPool(replicas)}
+}
+
+// Healthy returns the number of the replicas which are not skipped.
+func (p *Provider) Healthy() int {
+	return p.pool.healthy()
+}
+
+// BindContext returns the provider for the request of the context.
+func (p *Provider) BindContext(ctx context.Context) dataprovider.Provider {
+	return &session{primary: p.primary, pool: p.pool, ctx: ctx}
+}
+
+// Connect connects the primary and the replicas. Replicas which cannot be
+// connected are skipped like the failed ones.
+func (p *Provider) Connect() (err *utils.Error) {
+	if err = p.primary.Connect(); err != nil {
+		return
+	}
+	for _, replica := range p.pool.replicas {
+		if connectErr := replica.provider.Connect(); connectErr != nil {
+			log.Error("Connecting replica failed. Reason: " + connectErr.Error())
+			replica.failed()
+		}
+	}
+	return
+}
+
+// EnsureIndexes ensures the indexes on the primary, which the replicas replicate.
+func (p *Provider) EnsureIndexes(indexes []dataprovider.Index) (err *utils.Error) {
+	return dataprovider.EnsureIndexes(p.primary, indexes)
+}
+
+func (p *Provider) background() *session {
+	return &session{primary: p.primary, pool: p.pool, ctx: context.Background()}
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Create(collection, data)
+}
+
+func (p *Provider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Get(collection, id)
+}
+
+func (p *Provider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Query(collection, parameters)
+}
+
+func (p *Provider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return p.background().QueryStructured(collection, q)
+}
+
+func (p *Provider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return p.background().Count(collection, where)
+}
+
+func (p *Provider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Aggregate(collection, aggregation)
+}
+
+func (p *Provider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Search(collection, text, q)
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Update(collection, id, data)
+}
+
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Modify(collection, id, modify)
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.background().Delete(collection, id)
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return p.background().DeleteIf(collection, id, check)
+}
+
+func (p *Provider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.background().CreateFile(data)
+}
+
+func (p *Provider) GetFile(id string) (response []byte, err *utils.Error) {
+	return p.background().GetFile(id)
+}
+
+func (p *Provider) OpenFile(id string) (file files.File, err *utils.Error) {
+	return p.background().OpenFile(id)
+}
+
+func (p *Provider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.background().CreateFileWithInfo(info, data)
+}
+
+// session makes the calls of a request. Its reads go to the primary once it writes, or once
+// the request scope of its context is marked.
+type session struct {
+	primary dataprovider.Provider
+	pool    *pool
+	ctx     context.Context
+	written int32
+}
+
+func (s *session) sticky() bool {
+	return atomic.LoadInt32(&s.written) == 1 || sticky(s.ctx)
+}
+
+// read makes the read on the primary if the session is sticky, on the replicas otherwise.
+func (s *session) read(read func(provider dataprovider.Provider) *utils.Error) *utils.Error {
+	if s.sticky() {
+		return read(dataprovider.WithContext(s.ctx, s.primary))
+	}
+	return s.pool.read(s.ctx, s.primary, read)
+}
+
+// write returns the primary, marking the session to read from it.
+func (s *session) write() dataprovider.Provider {
+	atomic.StoreInt32(&s.written, 1)
+	markWritten(s.ctx)
+	return dataprovider.WithContext(s.ctx, s.primary)
+}
+
+func (s *session) Connect() (err *utils.Error) {
+	return s.primary.Connect()
+}
+
+func (s *session) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return s.write().Create(collection, data)
+}
+
+func (s *session) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = provider.Get(collection, id)
+		return
+	})
+	return
+}
+
+func (s *session) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = provider.Query(collection, parameters)
+		return
+	})
+	return
+}
+
+func (s *session) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = dataprovider.QueryStructured(provider, collection, q)
+		return
+	})
+	return
+}
+
+func (s *session) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		count, err = dataprovider.Count(provider, collection, where)
+		return
+	})
+	return
+}
+
+func (s *session) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = dataprovider.Aggregate(provider, collection, aggregation)
+		return
+	})
+	return
+}
+
+func (s *session) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = dataprovider.Search(provider, collection, text, q)
+		return
+	})
+	return
+}
+
+func (s *session) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return s.write().Update(collection, id, data)
+}
+
+func (s *session) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Modify(s.write(), collection, id, modify)
+}
+
+func (s *session) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return s.write().Delete(collection, id)
+}
+
+func (s *session) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.DeleteIf(s.write(), collection, id, check)
+}
+
+func (s *session) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return s.write().CreateFile(data)
+}
+
+func (s *session) GetFile(id string) (response []byte, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		response, err = provider.GetFile(id)
+		return
+	})
+	return
+}
+
+func (s *session) OpenFile(id string) (file files.File, err *utils.Error) {
+	err = s.read(func(provider dataprovider.Provider) (err *utils.Error) {
+		file, err = dataprovider.OpenFile(provider, id)
+		return
+	})
+	return
+}
+
+func (s *session) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.CreateFileWithInfo(s.write(), info, data)
+}
+
+// TransactionalProvider reads from the replicas and writes to a transactional primary. Reads in
+// a transaction go to the replicas until the transaction or its request writes, and to the
+// transaction after it.
+type TransactionalProvider struct {
+	*Provider
+	transactional dataprovider.TransactionalProvider
+}
+
+// NewTransactional returns a provider which reads from the replicas and writes to the transactional primary.
+func NewTransactional(primary dataprovider.TransactionalProvider, replicas ...dataprovider.Provider) *TransactionalProvider {
+	return &TransactionalProvider{New(primary, replicas...), primary}
+}
+
+func (p *TransactionalProvider) Begin(ctx context.Context) (transaction dataprovider.Transaction, err *utils.Error) {
+	begun, err := p.transactional.Begin(ctx)
+	if err != nil {
+		return
+	}
+	transaction = &transactionSession{session{primary: begun, pool: p.pool, ctx: ctx}, begun}
+	return
+}
+
+type transactionSession struct {
+	session
+	transaction dataprovider.Transaction
+}
+
+func (t *transactionSession) Commit() (err *utils.Error) {
+	return t.transaction.Commit()
+}
+
+func (t *transactionSession) Rollback() (err *utils.Error) {
+	return t.transaction.Rollback()
+}
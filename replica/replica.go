@@ -0,0 +1,130 @@
+// Package replica sends the reads to read replicas of a database and the writes to its primary.
+//
+// Reads see the writes of their own request: once a request writes, the rest of its reads are
+// sent to the primary. The request is marked in its request scope, so interceptors can send
+// the reads of a request to the primary too, ex: for a client which has just written in an
+// earlier request, with UsePrimary.
+//
+// Replicas which fail with a server error are skipped for UnhealthyDuration. Reads are sent to
+// the primary if every replica is unhealthy.
+//
+// Usage:
+//
+//   core.DataProvider = replica.New(primary, replica1, replica2)
+//   core.DataProvider = replica.NewTransactional(transactionalPrimary, replica1, replica2)
+package replica
+
+import (
+	"sync"
+	"time"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+// StickyKey is the request scope key which sends the reads of the request to the primary when true.
+const StickyKey = "replicaSticky"
+
+// UnhealthyDuration is how long a failed replica is skipped.
+var UnhealthyDuration = 10 * time.Second
+
+// UsePrimary sends the rest of the reads of the request to the primary.
+func UsePrimary(requestScope requestscope.RequestScope) {
+	requestScope.Set(StickyKey, true)
+}
+
+// sticky tells whether the reads of the request of the context go to the primary.
+func sticky(ctx context.Context) bool {
+	requestScope, exists := requestscope.FromContext(ctx)
+	if !exists {
+		return false
+	}
+	isSticky, _ := requestScope.Get(StickyKey).(bool)
+	return isSticky
+}
+
+// markWritten sends the rest of the reads of the request of the context to the primary. It may
+// be called from the goroutines of wrapping providers, ex: resilience timeouts, which the request
+// scope is safe for.
+func markWritten(ctx context.Context) {
+	if requestScope, exists := requestscope.FromContext(ctx); exists {
+		UsePrimary(requestScope)
+	}
+}
+
+type replica struct {
+	provider dataprovider.Provider
+
+	mutex          sync.Mutex
+	unhealthyUntil time.Time
+}
+
+func (r *replica) healthy() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return time.Now().After(r.unhealthyUntil)
+}
+
+func (r *replica) failed() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unhealthyUntil = time.Now().Add(UnhealthyDuration)
+}
+
+// pool picks the replicas in turn.
+type pool struct {
+	replicas []*replica
+	next     uint32
+}
+
+func newPool(providers []dataprovider.Provider) *pool {
+	replicas := make([]*replica, len(providers))
+	for i, provider := range providers {
+		replicas[i] = &replica{provider: provider}
+	}
+	return &pool{replicas: replicas}
+}
+
+// read makes the read on the healthy replicas in turn until one doesn't fail with a server
+// error. Makes it on the primary if every replica fails or is unhealthy.
+func (p *pool) read(ctx context.Context, primary dataprovider.Provider, read func(provider dataprovider.Provider) *utils.Error) *utils.Error {
+
+	count := len(p.replicas)
+	if count > 0 {
+		start := int(atomic.AddUint32(&p.next, 1)) % count
+		for i := 0; i < count; i++ {
+			replica := p.replicas[(start+i)%count]
+			if !replica.healthy() {
+				continue
+			}
+			err := read(dataprovider.WithContext(ctx, replica.provider))
+			if err == nil || !isServerError(err) {
+				return err
+			}
+			if ctx.Err() != nil {
+				return utils.ContextError(ctx.Err())
+			}
+			replica.failed()
+		}
+	}
+	return read(dataprovider.WithContext(ctx, primary))
+}
+
+// healthy returns the number of the replicas which are not skipped.
+func (p *pool) healthy() (count int) {
+	for _, replica := range p.replicas {
+		if replica.healthy() {
+			count++
+		}
+	}
+	return
+}
+
+// isServerError tells whether the replica failed. 501 Not Implemented is not a failure
+// of the replica, ex: when it doesn't support aggregations.
+func isServerError(err *utils.Error) bool {
+	return err.Code >= 500 && err.Code < 600 && err.Code != http.StatusNotImplemented
+}
@@ -0,0 +1,156 @@
+package replica
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/resilience"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// down fails every read with 503.
+type down struct {
+	*memory.Provider
+	reads int
+}
+
+func (d *down) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	d.reads++
+	return nil, &utils.Error{Code: http.StatusServiceUnavailable, Message: "Connection refused."}
+}
+
+func TestReplica(t *testing.T) {
+
+	Convey("Given a primary with a replica", t, func() {
+		primary := &memory.Provider{}
+		replica := &memory.Provider{}
+		provider := New(primary, replica)
+
+		created, _ := primary.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+		replica.Create("users", map[string]interface{}{"name": "replicated"})
+
+		Convey("Reads should go to the replica", func() {
+			response, err := provider.Query("users", nil)
+			So(err, ShouldBeNil)
+			results, _ := dataprovider.Results(response)
+			So(len(results), ShouldEqual, 1)
+			So(results[0]["name"], ShouldEqual, "replicated")
+		})
+
+		Convey("Writes should go to the primary", func() {
+			_, err := provider.Update("users", id, map[string]interface{}{"name": "bob"})
+			So(err, ShouldBeNil)
+			object, _ := primary.Get("users", id)
+			So(object["name"], ShouldEqual, "bob")
+		})
+
+		Convey("Reads of a request should go to the primary after it writes", func() {
+			requestScope := requestscope.Init()
+			ctx := requestscope.NewContext(context.Background(), requestScope)
+
+			db := dataprovider.WithContext(ctx, provider)
+			_, err := db.Get("users", id)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			db.Update("users", id, map[string]interface{}{"name": "bob"})
+			object, err := db.Get("users", id)
+			So(err, ShouldBeNil)
+			So(object["name"], ShouldEqual, "bob")
+			So(requestScope.Get(StickyKey), ShouldEqual, true)
+
+			Convey("Other providers of the request should read from the primary too", func() {
+				count, err := dataprovider.Count(dataprovider.WithContext(ctx, provider), "users", nil)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+		})
+
+		Convey("Reads of a request marked to use the primary should go to the primary", func() {
+			requestScope := requestscope.Init()
+			UsePrimary(requestScope)
+			ctx := requestscope.NewContext(context.Background(), requestScope)
+
+			_, err := dataprovider.WithContext(ctx, provider).Get("users", id)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a primary with a failing replica", t, func() {
+		primary := &memory.Provider{}
+		replica := &down{Provider: &memory.Provider{}}
+		provider := New(primary, replica)
+
+		created, _ := primary.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Reads should fall back to the primary and skip the replica", func() {
+			_, err := provider.Get("users", id)
+			So(err, ShouldBeNil)
+			So(provider.Healthy(), ShouldEqual, 0)
+
+			_, err = provider.Get("users", id)
+			So(err, ShouldBeNil)
+			So(replica.reads, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a resilient replicated provider", t, func() {
+		primary := &memory.Provider{}
+		provider := resilience.New(New(primary, &memory.Provider{}), resilience.Options{Timeout: time.Second})
+
+		created, _ := primary.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Writes in the goroutines of the attempts should mark the request safely", func() {
+			requestScope := requestscope.Init()
+			ctx := requestscope.NewContext(context.Background(), requestScope)
+			db := dataprovider.WithContext(ctx, provider)
+
+			var wait sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wait.Add(1)
+				go func() {
+					defer wait.Done()
+					db.Update("users", id, map[string]interface{}{"name": "bob"})
+					db.Get("users", id)
+				}()
+				requestScope.Set("key", i)
+			}
+			wait.Wait()
+
+			So(requestScope.Get(StickyKey), ShouldEqual, true)
+			object, err := db.Get("users", id)
+			So(err, ShouldBeNil)
+			So(object["name"], ShouldEqual, "bob")
+		})
+	})
+
+	Convey("Given a transactional primary with a replica", t, func() {
+		primary := &memory.Provider{}
+		provider := NewTransactional(primary, &memory.Provider{})
+
+		created, _ := primary.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Reads in a transaction should see its writes", func() {
+			transaction, err := provider.Begin(context.Background())
+			So(err, ShouldBeNil)
+
+			_, err = transaction.Get("users", id)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			transaction.Update("users", id, map[string]interface{}{"name": "bob"})
+			object, err := transaction.Get("users", id)
+			So(err, ShouldBeNil)
+			So(object["name"], ShouldEqual, "bob")
+			So(transaction.Rollback(), ShouldBeNil)
+		})
+	})
+}
@@ -1,29 +1,124 @@
 package core
 
 import (
+	"io"
+	"mime"
+	"sort"
+	"context"
+	"time"
+	"strconv"
 	"strings"
 	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/etag"
+	"github.com/rihtim/core/expand"
+	"github.com/rihtim/core/history"
+	"github.com/rihtim/core/changes"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/patch"
+	"github.com/rihtim/core/buckets"
+	"github.com/rihtim/core/schema"
+	"github.com/rihtim/core/indexes"
+	"github.com/rihtim/core/relations"
+	"github.com/rihtim/core/softdelete"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/search"
 	"github.com/rihtim/core/utils"
 	"github.com/rihtim/core/methods"
 	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/pagination"
 	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/interceptors"
 	"github.com/rihtim/core/dataprovider"
 )
 
+// AggregateSegment is the segment of the aggregations of a collection, ex: /users/_aggregate.
+// The parameters of aggregations are documented in query.ParseAggregation.
+const AggregateSegment = "_aggregate"
+
 var AllowedMethodsOfResourceTypes = map[string]map[string]bool{
 	"collection": {
 		"get":  true,
 		"post": true,
 	},
 	"model": {
-		"put":    true,
-		"delete": true,
-		"get":    true,
+		"put":     true,
+		"patch":   true,
+		"delete":  true,
+		"get":     true,
+		"restore": true,
 	},
 }
 
 func Execute(request messages.Message, db dataprovider.Provider) (response messages.Message, updatedRequestscope requestscope.RequestScope, err *utils.Error) {
 
+	// posting to the restore segment of an object is the same as the restore command
+	if strings.EqualFold(request.Command, methods.Post) && strings.HasSuffix(request.Res, "/"+softdelete.RestoreSegment) {
+		request.Res = strings.TrimSuffix(request.Res, "/"+softdelete.RestoreSegment)
+		request.Command = methods.Restore
+	}
+
+	// posting to the revert segment of a version is the same as the revert command
+	if strings.EqualFold(request.Command, methods.Post) && strings.HasSuffix(request.Res, "/"+history.RevertSegment) {
+		request.Res = strings.TrimSuffix(request.Res, "/"+history.RevertSegment)
+		request.Command = methods.Revert
+	}
+
+	// the history of an object is executed on the object, ex: /users/{id}/_history/{version}
+	res, version, isHistory := splitHistory(request.Res)
+	request.Res = res
+
+	// aggregations are executed on the collection, ex: /users/_aggregate
+	isAggregate := strings.HasSuffix(request.Res, "/"+AggregateSegment)
+	request.Res = strings.TrimSuffix(request.Res, "/"+AggregateSegment)
+
+	// snapshots are only accessed through the history of their objects, so that they cannot be changed
+	if isHistoryStore(request.Res) {
+		err = &utils.Error{Code: http.StatusForbidden, Message: "History can only be accessed through its objects, ex: /{collection}/{id}/" + history.Segment + "."}
+		return
+	}
+
+	// the context is taken before the provider is wrapped, since only the bound provider knows it
+	ctx := dataprovider.Context(db)
+
+	// changes are recorded for the subscriptions and published once the request succeeds
+	recorder := changes.Record(db, Changes)
+	db = recorder
+	defer func() {
+		if err == nil {
+			changes.Publish(ctx, Changes, recorder.Events()...)
+		}
+	}()
+
+	// snapshots are read from the provider as is and written before changes
+	store := db
+	db = history.Record(db, History)
+
+	// documents are validated against the schemas of their collections before they are written
+	db = schema.Enforce(db, Schemas)
+
+	// unique indexes are checked against every object, including the soft deleted ones
+	db = indexes.Enforce(db, Indexes)
+
+	// deleted objects are hidden, unless they are requested from the trash
+	if deleted, _ := request.GetParameter(softdelete.Parameter); strings.EqualFold(deleted, "true") || strings.EqualFold(request.Command, methods.Restore) {
+		collection := resourceCollection(request.Res)
+		if _, hasPolicy := SoftDelete.Get(collection); !hasPolicy {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Objects of '" + collection + "' are not soft deleted."}
+			return
+		}
+		db = softdelete.Trash(db, SoftDelete, collection)
+	} else {
+		db = softdelete.Hide(db, SoftDelete)
+	}
+
+	// nested resources are executed as the child collection, on a provider scoped to the parent
+	if len(strings.Split(request.Res, "/")) > 3 {
+		if request.Res, db, err = resolveNested(request.Res, db); err != nil {
+			return
+		}
+	}
+
 	// check if the method is allowed on the resource type
 	var resourceType string
 	resPartCount := len(strings.Split(request.Res, "/"))
@@ -32,10 +127,43 @@ func Execute(request messages.Message, db dataprovider.Provider) (response messa
 	} else if resPartCount == 3 {
 		resourceType = "model"
 	} else {
-		err = &utils.Error{
-			Code:    http.StatusMethodNotAllowed,
-			Message: "Invalid resource schema.",
+		err = invalidResourceSchema()
+		return
+	}
+
+	if isHistory {
+		if resourceType != "model" {
+			err = invalidResourceSchema()
+			return
 		}
+
+		// versions are reverted with the interceptors of PUT /{class}/{id} and read with the ones of GET /{class}/{id}
+		if strings.EqualFold(request.Command, methods.Revert) {
+			response, err = executeAs(ctx, methods.Put, request, db, func(request messages.Message) (messages.Message, *utils.Error) {
+				return handleHistory(ctx, request, version, store, db)
+			})
+			return
+		}
+		if request, response, err = interceptAs(ctx, interceptors.BEFORE_EXEC, methods.Get, request, response, db); err != nil || !response.IsEmpty() {
+			return
+		}
+		if response, err = handleHistory(ctx, request, version, store, db); err != nil {
+			return
+		}
+		err = filterSnapshots(ctx, request, response.Body, db)
+		return
+	}
+
+	if isAggregate {
+		if resourceType != "collection" {
+			err = invalidResourceSchema()
+			return
+		}
+
+		// aggregations read the collection, so they are executed with the interceptors of GET /{class}
+		response, err = executeAs(ctx, methods.Get, request, db, func(request messages.Message) (messages.Message, *utils.Error) {
+			return handleAggregate(request, db)
+		})
 		return
 	}
 
@@ -50,27 +178,119 @@ func Execute(request messages.Message, db dataprovider.Provider) (response messa
 
 	// execute request
 	if strings.EqualFold(request.Command, methods.Post) {
-		response, err = handlePost(request, db)
+		response, err = handlePost(ctx, request, db)
 	} else if strings.EqualFold(request.Command, methods.Get) {
-		response, err = handleGet(request, db)
+		response, err = handleGet(ctx, request, db)
 	} else if strings.EqualFold(request.Command, methods.Put) {
 		response, err = handlePut(request, db)
+	} else if strings.EqualFold(request.Command, methods.Patch) {
+		response, err = executeAs(ctx, methods.Put, request, db, func(request messages.Message) (messages.Message, *utils.Error) {
+			return handlePatch(request, db)
+		})
 	} else if strings.EqualFold(request.Command, methods.Delete) {
 		response, err = handleDelete(request, db)
+	} else if strings.EqualFold(request.Command, methods.Restore) {
+		response, err = executeAs(ctx, methods.Put, request, db, func(request messages.Message) (messages.Message, *utils.Error) {
+			return handleRestore(request, db)
+		})
 	}
 
 	return
 }
 
-var handlePost = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+// resolveNested converts a nested resource like /users/{id}/books/{bookId} to the resource of the
+// child collection, ex: /books/{bookId}, and returns the provider scoped to the parent documents.
+// Every parent in the path must exist and belong to its own parent.
+func resolveNested(res string, db dataprovider.Provider) (childRes string, scopedDb dataprovider.Provider, err *utils.Error) {
+
+	parts := strings.Split(res, "/")[1:]
+	collection := parts[0]
+	scopedDb = db
+
+	for i := 2; i < len(parts); i += 2 {
+		relation, exists := Relations.Get(collection, parts[i])
+		if !exists {
+			err = invalidResourceSchema()
+			return
+		}
+		if _, err = scopedDb.Get(collection, parts[i-1]); err != nil {
+			return
+		}
+		scopedDb = relations.Scope(scopedDb, relation, parts[i-1])
+		collection = relation.ChildCollection()
+	}
+
+	childRes, _ = childResource(Relations, res)
+	return
+}
+
+// isHistoryStore tells whether the resource or a parent of it is in a collection keeping snapshots.
+func isHistoryStore(res string) bool {
+	if !strings.HasPrefix(res, "/") {
+		return false
+	}
+	return History.IsStore(strings.Split(res, "/")[1]) || History.IsStore(resourceCollection(res))
+}
+
+// resourceCollection returns the collection of the resource, which is the child
+// collection of the last relation for nested resources.
+func resourceCollection(res string) string {
+	childRes, _ := childResource(Relations, res)
+	return strings.Split(childRes, "/")[1]
+}
+
+// childResource returns the resource of the child collection of a nested resource in the
+// relations of the registry without checking its parents, ex: /books/{bookId} for
+// /users/{id}/books/{bookId}. The segments following the relations are kept, ex:
+// /books/{bookId}/_history for its history.
+func childResource(registry *relations.Registry, res string) (childRes string, isNested bool) {
+	parts := strings.Split(res, "/")[1:]
+	collection := parts[0]
+	last := 0
+	for i := 2; i < len(parts); i += 2 {
+		relation, exists := registry.Get(collection, parts[i])
+		if !exists {
+			break
+		}
+		collection = relation.ChildCollection()
+		last = i
+	}
+	if last == 0 {
+		return res, false
+	}
+	return "/" + strings.Join(append([]string{collection}, parts[last+1:]...), "/"), true
+}
+
+// splitHistory splits the history segment and the version from the resource of an object,
+// ex: /users/{id}/_history/3 is split to /users/{id} and 3.
+func splitHistory(res string) (objectRes string, version string, isHistory bool) {
+	parts := strings.Split(res, "/")
+	for i := len(parts) - 1; i >= 3 && i >= len(parts)-2; i-- {
+		if parts[i] == history.Segment {
+			if i < len(parts)-1 {
+				version = parts[i+1]
+			}
+			return strings.Join(parts[:i], "/"), version, true
+		}
+	}
+	return res, "", false
+}
+
+func invalidResourceSchema() *utils.Error {
+	return &utils.Error{
+		Code:    http.StatusMethodNotAllowed,
+		Message: "Invalid resource schema.",
+	}
+}
+
+var handlePost = func(ctx context.Context, request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
 
 	class := strings.Split(request.Res, "/")[1]
 
-	// TODO: don't hard code the 'files' path
-	if !strings.EqualFold(class, "files") {
-		response.Body, err = db.Create(class, request.Body)
+	if bucket, isBucket := FileBuckets.Get(class); isBucket {
+		response.Body, err = createFile(ctx, bucket, request, db)
 	} else {
-		response.Body, err = db.CreateFile(request.ReqBodyRaw)
+		response.Body, err = db.Create(class, request.Body)
 	}
 
 	if err == nil {
@@ -79,23 +299,123 @@ var handlePost = func(request messages.Message, db dataprovider.Provider) (respo
 	return
 }
 
-var handleGet = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+// createFile checks the uploaded file against the limits of the bucket and stores it.
+func createFile(ctx context.Context, bucket buckets.Bucket, request messages.Message, db dataprovider.Provider) (response map[string]interface{}, err *utils.Error) {
+
+	info, data, err := uploadedFile(request)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		defer data.Close()
+	}
+
+	info, data, err = bucket.Accept(info, data)
+	if err != nil {
+		return
+	}
+
+	response, err = dataprovider.CreateFileWithInfo(bucketStorage(ctx, bucket, db), info, data)
+	err = bucket.CheckRead(data, err)
+	return
+}
+
+// bucketStorage returns the storage provider of the bucket bound to the context of the
+// request, or the data provider of the request if the bucket has none. The context is
+// given, since the data provider of the request is wrapped after it is bound to it.
+func bucketStorage(ctx context.Context, bucket buckets.Bucket, db dataprovider.Provider) dataprovider.Provider {
+	if bucket.Provider == nil {
+		return db
+	}
+	return dataprovider.WithContext(ctx, bucket.Provider)
+}
+
+// uploadedFile returns the uploaded file and its metadata. The file is either in the multipart
+// form or is the raw body, in which case the metadata is read from the request headers.
+func uploadedFile(request messages.Message) (info files.Info, data io.ReadCloser, err *utils.Error) {
+
+	if request.MultipartForm == nil {
+		info.Size = -1
+		info.ContentType, _ = request.GetHeader("Content-Type")
+		if disposition, hasDisposition := request.GetHeader("Content-Disposition"); hasDisposition {
+			if _, params, parseErr := mime.ParseMediaType(disposition); parseErr == nil {
+				info.Name = params["filename"]
+			}
+		}
+		if length, hasLength := request.GetHeader("Content-Length"); hasLength {
+			if size, parseErr := strconv.ParseInt(length, 10, 64); parseErr == nil {
+				info.Size = size
+			}
+		}
+		data = request.ReqBodyRaw
+		info.ContentType = specificContentType(info.ContentType)
+		return
+	}
+
+	fileHeaders := request.MultipartForm.File[files.MultipartField]
+	if len(fileHeaders) == 0 {
+		// fall back to the first file field in name order, since map iteration is random
+		fields := make([]string, 0, len(request.MultipartForm.File))
+		for field := range request.MultipartForm.File {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if fileHeaders = request.MultipartForm.File[field]; len(fileHeaders) > 0 {
+				break
+			}
+		}
+	}
+	if len(fileHeaders) == 0 {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Multipart form does not contain a file."}
+		return
+	}
+
+	fileHeader := fileHeaders[0]
+	file, openErr := fileHeader.Open()
+	if openErr != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Reading uploaded file failed. Reason: " + openErr.Error()}
+		return
+	}
+
+	info = files.Info{
+		Name:        fileHeader.Filename,
+		ContentType: specificContentType(fileHeader.Header.Get("Content-Type")),
+		Size:        fileHeader.Size,
+	}
+	data = file
+	return
+}
+
+// specificContentType treats the generic binary content type as unknown,
+// so that the provider can detect the type from the content.
+func specificContentType(contentType string) string {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == files.DefaultContentType {
+		return ""
+	}
+	return contentType
+}
+
+var handleGet = func(ctx context.Context, request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
 
 	class := strings.Split(request.Res, "/")[1]
 
-	isFileClass := strings.EqualFold(class, "files")
+	bucket, isBucket := FileBuckets.Get(class)
 	isModelActor := len(strings.Split(request.Res, "/")) == 3
 	isCollectionActor := len(strings.Split(request.Res, "/")) == 2
 
 	if isModelActor {
 		id := request.Res[strings.LastIndex(request.Res, "/")+1:]
-		if isFileClass {
-			response.RawBody, err = db.GetFile(id) // get file by id
+		if isBucket {
+			var file files.File
+			if file, err = dataprovider.OpenFile(bucketStorage(ctx, bucket, db), id); err == nil { // get file by id
+				response.File = &file
+			}
 		} else {
-			response.Body, err = db.Get(class, id) // get object by id
+			response, err = handleGetModel(ctx, class, id, request, db) // get object by id
 		}
 	} else if isCollectionActor {
-		response.Body, err = db.Query(class, request.Parameters) // query collection
+		response.Body, err = handleQuery(ctx, class, request.Parameters, db) // query collection
 	}
 
 	if err != nil {
@@ -104,24 +424,481 @@ var handleGet = func(request messages.Message, db dataprovider.Provider) (respon
 	return
 }
 
+// handleGetModel gets the object, or its version at the time in the at parameter, and applies the
+// fields and expand parameters. The entity tag is computed from the whole stored object, so that
+// it can be used in If-Match headers.
+func handleGetModel(ctx context.Context, class, id string, request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	expansions, err := expand.Parse(request.Parameters[expand.Parameter])
+	if err != nil {
+		return
+	}
+
+	// an object read at a point in time is not current, so it has no entity tag
+	if at, hasAt := request.GetParameter(history.AtParameter); hasAt {
+		if response.Body, err = getAt(class, id, at, db); err != nil {
+			return
+		}
+	} else {
+		if response.Body, err = db.Get(class, id); err != nil {
+			return
+		}
+
+		// the tag doesn't cover the embedded objects, so expanded responses are never 304
+		if len(expansions) == 0 {
+			response = conditionalGet(request, response)
+			if response.Status == http.StatusNotModified {
+				return
+			}
+		} else {
+			response.Headers = map[string][]string{etag.ETagHeader: {etag.Compute(response.Body)}}
+		}
+	}
+
+	if err = expand.Documents(db, Relations, class, []map[string]interface{}{response.Body}, expansions, expansionFilter(ctx, db)); err != nil {
+		return
+	}
+	response.Body = projectFields(request, response.Body, expansions)
+	return
+}
+
+// projectFields projects the object to the fields parameter and the expanded fields.
+func projectFields(request messages.Message, object map[string]interface{}, expansions expand.Tree) map[string]interface{} {
+	if fields := query.ParseList(request.GetParameterAlone(query.FieldsParameter)); len(fields) > 0 {
+		return expansions.Project(object, fields)
+	}
+	return object
+}
+
+// expansionFilter returns the filter which executes the interceptors of the GET requests of the
+// collections on their expanded documents, as if the documents were queried at /{collection}.
+// Errors of BEFORE_EXEC interceptors fail the expansion and the results of AFTER_EXEC
+// interceptors are embedded.
+func expansionFilter(ctx context.Context, db dataprovider.Provider) expand.Filter {
+
+	requestScope, hasScope := requestscope.FromContext(ctx)
+	if !hasScope {
+		requestScope = requestscope.Init()
+	}
+
+	return func(collection string, documents []map[string]interface{}) (filtered []map[string]interface{}, err *utils.Error) {
+		request := messages.Message{Res: "/" + collection, Command: methods.Get}
+		if _, _, _, err = executeInterceptors(interceptors.BEFORE_EXEC, requestScope, request, messages.Message{}, db); err != nil {
+			return
+		}
+
+		response := messages.Message{Body: map[string]interface{}{dataprovider.ResultsField: documents}}
+		if _, response, _, err = executeInterceptors(interceptors.AFTER_EXEC, requestScope, request, response, db); err != nil {
+			return
+		}
+		filtered, _ = dataprovider.Results(response.Body)
+		return
+	}
+}
+
+// executeAs executes the request between the BEFORE_EXEC and AFTER_EXEC interceptors of the
+// request with the command, see interceptAs.
+func executeAs(ctx context.Context, command string, request messages.Message, db dataprovider.Provider, execute func(request messages.Message) (messages.Message, *utils.Error)) (response messages.Message, err *utils.Error) {
+
+	if request, response, err = interceptAs(ctx, interceptors.BEFORE_EXEC, command, request, response, db); err != nil || !response.IsEmpty() {
+		return
+	}
+	if response, err = execute(request); err != nil {
+		return
+	}
+	_, response, err = interceptAs(ctx, interceptors.AFTER_EXEC, command, request, response, db)
+	return
+}
+
+// interceptAs executes the interceptors of the request as if it had the command, ex: the ones of
+// PUT /{class}/{id} for PATCH requests, so that the rules of a resource apply to every request
+// which reads or changes it. The edited request is returned with its own command. A response
+// returned by BEFORE_EXEC interceptors answers the request.
+func interceptAs(ctx context.Context, interceptorType interceptors.InterceptorType, command string, request, response messages.Message, db dataprovider.Provider) (editedRequest, editedResponse messages.Message, err *utils.Error) {
+
+	requestScope, hasScope := requestscope.FromContext(ctx)
+	if !hasScope {
+		requestScope = requestscope.Init()
+	}
+
+	equivalent := request
+	equivalent.Command = command
+	if editedRequest, editedResponse, _, err = executeInterceptors(interceptorType, requestScope, equivalent, response, db); err != nil {
+		return
+	}
+
+	if editedRequest.IsEmpty() {
+		editedRequest = request
+	} else {
+		editedRequest.Command = request.Command
+	}
+	if editedResponse.IsEmpty() {
+		editedResponse = response
+	}
+	return
+}
+
+// getAt gets the object as it was at the time, which is in RFC 3339 format.
+func getAt(class, id, at string, db dataprovider.Provider) (object map[string]interface{}, err *utils.Error) {
+
+	policy, isTracked := History.Get(class)
+	if !isTracked {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "History is not kept for '" + class + "'."}
+		return
+	}
+	t, parseErr := time.Parse(time.RFC3339Nano, at)
+	if parseErr != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'at' must be a time in RFC 3339 format."}
+		return
+	}
+	return history.At(db, policy, id, t)
+}
+
+// handleQuery parses the query parameters and rejects malformed queries before they reach the
+// provider. Providers which implement StructuredQuerier receive the parsed query.
+//
+// If a limit or a cursor is given, the results are paged: the response contains the cursor of
+// the next page in the 'next' field, and the total count in the 'total' field if 'count=true'.
+// The results are expanded after paging, so expansions don't change the pages.
+func handleQuery(ctx context.Context, class string, parameters map[string][]string, db dataprovider.Provider) (response map[string]interface{}, err *utils.Error) {
+
+	q, err := query.Parse(parameters)
+	if err != nil {
+		return
+	}
+
+	// paging, expand and search parameters are handled here and not passed to the provider
+	after, hasAfter := q.Extras[pagination.AfterParameter]
+	count, hasCount := q.Extras[pagination.CountParameter]
+	text, isSearch := q.Extras[search.Parameter]
+	expansions, err := expand.Parse(q.Extras[expand.Parameter])
+	if err != nil {
+		return
+	}
+	delete(q.Extras, pagination.AfterParameter)
+	delete(q.Extras, pagination.CountParameter)
+	delete(q.Extras, search.Parameter)
+	delete(q.Extras, expand.Parameter)
+	delete(q.Extras, softdelete.Parameter)
+
+	// search results are ranked by relevance, which cursors cannot point into
+	if isSearch {
+		if strings.TrimSpace(text[0]) == "" {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'search' cannot be empty."}
+			return
+		}
+		if hasAfter {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameters 'after' and 'search' cannot be used together."}
+			return
+		}
+	}
+
+	paged := !isSearch && (hasAfter || q.Limit >= 0)
+	limit := q.Limit
+	where := q.Where
+
+	if paged {
+		if hasAfter && q.Skip > 0 {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameters 'after' and 'skip' cannot be used together."}
+			return
+		}
+
+		if limit < 0 {
+			limit = pagination.DefaultLimit
+		}
+
+		// the id makes the order total, so that a cursor points to a single position
+		if !sortsBy(q.Sort, dataprovider.IdField) {
+			q.Sort = append(q.Sort, query.SortField{Field: dataprovider.IdField})
+		}
+
+		if hasAfter {
+			values, decodeErr := pagination.Decode(after[0], q.Sort)
+			if decodeErr != nil {
+				err = decodeErr
+				return
+			}
+			if where == nil {
+				q.Where = pagination.After(q.Sort, values)
+			} else {
+				q.Where = query.And{where, pagination.After(q.Sort, values)}
+			}
+		}
+
+		// one more document tells whether there is a next page
+		q.Limit = limit + 1
+	}
+
+	// the fields needed to create the cursor and to expand the results are projected too
+	fields := q.Fields
+	if len(fields) > 0 {
+		q.Fields = append(expansions.Own(fields), expansions.Fields()...)
+		if paged {
+			for _, sortField := range q.Sort {
+				q.Fields = append(q.Fields, sortField.Field)
+			}
+		}
+	}
+
+	if isSearch {
+		response, err = dataprovider.Search(db, class, text[0], q)
+	} else {
+		response, err = dataprovider.QueryStructured(db, class, q)
+	}
+	if err != nil || (!paged && len(expansions) == 0) {
+		return
+	}
+
+	results, isList := dataprovider.Results(response)
+	if !isList {
+		return
+	}
+
+	if paged && len(results) > limit {
+		results = results[:limit]
+		if limit > 0 {
+			response[pagination.NextField] = pagination.Encode(q.Sort, results[limit-1])
+		}
+	}
+	if err = expand.Documents(db, Relations, class, results, expansions, expansionFilter(ctx, db)); err != nil {
+		return
+	}
+	if len(fields) > 0 {
+		if isSearch {
+			fields = append(fields, dataprovider.ScoreField, dataprovider.HighlightsField)
+		}
+		for i, result := range results {
+			results[i] = expansions.Project(result, fields)
+		}
+	}
+	response[dataprovider.ResultsField] = results
+
+	if paged && hasCount && strings.EqualFold(count[0], "true") {
+		response[pagination.TotalField], err = dataprovider.Count(db, class, where)
+	}
+	return
+}
+
+func sortsBy(sortFields []query.SortField, field string) bool {
+	for _, sortField := range sortFields {
+		if sortField.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
 var handlePut = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
 
 	class := strings.Split(request.Res, "/")[1]
 	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
+
+	// check the precondition and update in a single modification to avoid lost updates
+	if ifMatch, hasIfMatch := request.GetHeader(etag.IfMatchHeader); hasIfMatch {
+		response.Body, err = dataprovider.Modify(db, class, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+			if err := checkIfMatch(ifMatch, document); err != nil {
+				return nil, err
+			}
+			for key, value := range request.Body {
+				document[key] = value
+			}
+			return document, nil
+		})
+		return
+	}
+
 	response.Body, err = db.Update(class, id, request.Body)
 	return
 }
 
+// handlePatch applies a JSON Patch or a merge patch, depending on the content type, to the
+// current document atomically. JSON Patch documents are read from the raw request body. Other
+// content types return 415 Unsupported Media Type. Patches are executed with the interceptors
+// of PUT requests too, since they change the object the same way.
+var handlePatch = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
+	contentType, _ := request.GetHeader("Content-Type")
+
+	var apply dataprovider.ModifyFunc
+	if patch.IsJSONPatch(contentType) {
+		var operations []interface{}
+		if request.ReqBodyRaw == nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "JSON Patch document is missing."}
+			return
+		}
+		if decodeErr := json.NewDecoder(request.ReqBodyRaw).Decode(&operations); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parsing JSON Patch document failed. Reason: " + decodeErr.Error()}
+			return
+		}
+		apply = func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+			return patch.Apply(document, operations)
+		}
+	} else if patch.IsMergePatch(contentType) {
+		apply = func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+			return patch.Merge(document, request.Body), nil
+		}
+	} else {
+		err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Content type '" + contentType + "' is not supported for patch."}
+		return
+	}
+
+	if ifMatch, hasIfMatch := request.GetHeader(etag.IfMatchHeader); hasIfMatch {
+		patchDocument := apply
+		apply = func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+			if err := checkIfMatch(ifMatch, document); err != nil {
+				return nil, err
+			}
+			return patchDocument(document)
+		}
+	}
+
+	response.Body, err = dataprovider.Modify(db, class, id, apply)
+	return
+}
+
 var handleDelete = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
 
 	if len(strings.Split(request.Res, "/")) == 3 {
 		// delete object
 		class := strings.Split(request.Res, "/")[1]
 		id := request.Res[strings.LastIndex(request.Res, "/")+1:]
-		response.Body, err = db.Delete(class, id)
+
+		// check the precondition and delete in a single call, so that the object cannot change in between
+		if ifMatch, hasIfMatch := request.GetHeader(etag.IfMatchHeader); hasIfMatch {
+			response.Body, err = dataprovider.DeleteIf(db, class, id, func(document map[string]interface{}) *utils.Error {
+				return checkIfMatch(ifMatch, document)
+			})
+		} else {
+			response.Body, err = db.Delete(class, id)
+		}
 		if err == nil {
 			response.Status = http.StatusNoContent
 		}
 	}
 	return
 }
+
+// handleRestore restores a soft deleted object. The provider is the trash view of the collection.
+// Restores are executed with the interceptors of PUT requests too, since they change the object.
+var handleRestore = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
+
+	response.Body, err = softdelete.Restore(db, class, id)
+	return
+}
+
+// handleAggregate groups the objects of a collection and computes the values of the groups.
+// Conditions added to the where parameter by the interceptors of GET /{class} apply to the
+// grouped objects. Returns 501 Not Implemented if the data provider doesn't support aggregations.
+var handleAggregate = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	if !strings.EqualFold(request.Command, methods.Get) {
+		err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed on the resource type."}
+		return
+	}
+
+	aggregation, err := query.ParseAggregation(request.Parameters)
+	if err != nil {
+		return
+	}
+
+	class := strings.Split(request.Res, "/")[1]
+	response.Body, err = dataprovider.Aggregate(db, class, aggregation)
+	return
+}
+
+// handleHistory lists the versions of an object, gets a version or reverts the object to a
+// version. Versions are listed like collections, the latest first unless sorted otherwise.
+var handleHistory = func(ctx context.Context, request messages.Message, version string, store dataprovider.Provider, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
+
+	policy, isTracked := History.Get(class)
+	if !isTracked {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "History is not kept for '" + class + "'."}
+		return
+	}
+
+	if version == "" {
+		if !strings.EqualFold(request.Command, methods.Get) {
+			err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed on the resource type."}
+			return
+		}
+		parameters := make(map[string][]string, len(request.Parameters)+1)
+		for key, values := range request.Parameters {
+			parameters[key] = values
+		}
+		if _, hasSort := parameters[query.SortParameter]; !hasSort {
+			parameters[query.SortParameter] = []string{"-" + history.VersionField}
+		}
+		response.Body, err = handleQuery(ctx, policy.Store(), parameters, history.Versions(store, policy, id))
+		return
+	}
+
+	number, convertErr := strconv.Atoi(version)
+	if convertErr != nil {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Version " + version + " not found."}
+		return
+	}
+
+	if strings.EqualFold(request.Command, methods.Get) {
+		response.Body, err = history.Version(store, policy, id, number)
+	} else if strings.EqualFold(request.Command, methods.Revert) {
+		response.Body, err = history.Revert(db, store, policy, id, number)
+	} else {
+		err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed on the resource type."}
+	}
+	return
+}
+
+// filterSnapshots executes the AFTER_EXEC interceptors of GET /{class}/{id} on the documents of the
+// snapshots in the body, which is a snapshot or a list of them, as if the object was read as it was.
+func filterSnapshots(ctx context.Context, request messages.Message, body map[string]interface{}, db dataprovider.Provider) (err *utils.Error) {
+
+	snapshots, isList := dataprovider.Results(body)
+	if !isList {
+		snapshots = []map[string]interface{}{body}
+	}
+
+	for _, snapshot := range snapshots {
+		document, hasDocument := snapshot[history.DocumentField].(map[string]interface{})
+		if !hasDocument {
+			continue
+		}
+		var response messages.Message
+		if _, response, err = interceptAs(ctx, interceptors.AFTER_EXEC, methods.Get, request, messages.Message{Body: document}, db); err != nil {
+			return
+		}
+		snapshot[history.DocumentField] = response.Body
+	}
+	return
+}
+
+// conditionalGet adds the entity tag of the object to the response, and replaces
+// the response with 304 Not Modified if the If-None-Match header matches the tag.
+func conditionalGet(request messages.Message, response messages.Message) messages.Message {
+
+	tag := etag.Compute(response.Body)
+	headers := map[string][]string{etag.ETagHeader: {tag}}
+
+	if ifNoneMatch, hasIfNoneMatch := request.GetHeader(etag.IfNoneMatchHeader); hasIfNoneMatch && etag.MatchesIfNoneMatch(ifNoneMatch, tag) {
+		return messages.Message{Status: http.StatusNotModified, Headers: headers}
+	}
+
+	response.Headers = headers
+	return response
+}
+
+// checkIfMatch returns 412 Precondition Failed if the value of the If-Match
+// header doesn't match the entity tag of the document.
+func checkIfMatch(ifMatch string, document map[string]interface{}) (err *utils.Error) {
+	if !etag.MatchesIfMatch(ifMatch, etag.Compute(document)) {
+		err = &utils.Error{Code: http.StatusPreconditionFailed, Message: "Object has been modified."}
+	}
+	return
+}
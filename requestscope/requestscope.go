@@ -1,23 +1,35 @@
 package requestscope
 
 import (
+	"sync"
+	"context"
 	"strconv"
 	"github.com/rihtim/core/log"
 )
 
+// contextKey is the key the context of the request is kept with.
+const contextKey = "context"
+
+// RequestScope keeps the data of a request. It is safe for concurrent use, since the data
+// providers of a request may run in other goroutines, ex: to time out.
 type RequestScope struct {
-	data map[string]interface{}
+	data  map[string]interface{}
+	mutex *sync.RWMutex
 }
 
 func Init() RequestScope {
 	requestScope := RequestScope{
-		data: make(map[string]interface{}),
+		data:  make(map[string]interface{}),
+		mutex: &sync.RWMutex{},
 	}
 	return requestScope
 }
 
 func (rs RequestScope) Copy() RequestScope {
-	clone := RequestScope{data: map[string]interface{}{}}
+	rs.rlock()
+	defer rs.runlock()
+
+	clone := RequestScope{data: map[string]interface{}{}, mutex: &sync.RWMutex{}}
 	for k, v := range rs.data {
 		clone.data[k] = v
 	}
@@ -26,25 +38,98 @@ func (rs RequestScope) Copy() RequestScope {
 
 func (rs RequestScope) Set(key string, value interface{}) {
 	log.Debug("RequestScope.Set:", key, value)
+	rs.lock()
+	defer rs.unlock()
 	rs.data[key] = value
 }
 
 func (rs RequestScope) Get(key string) interface{} {
 	log.Debug("RequestScope.Get:", key)
+	rs.rlock()
+	defer rs.runlock()
 	return rs.data[key]
 }
 
 func (rs RequestScope) Delete(key string) {
 	log.Debug("RequestScope.Delete:", key)
+	rs.lock()
+	defer rs.unlock()
 	delete(rs.data, key)
 }
 
 func (rs RequestScope) Contains(key string) bool {
+	rs.rlock()
 	_, contains := rs.data[key]
+	rs.runlock()
 	log.Debug("RequestScope.Has:" + key + " = " + strconv.FormatBool(contains))
 	return contains
 }
 
 func (rs RequestScope) IsEmpty() bool {
+	rs.rlock()
+	defer rs.runlock()
 	return rs.data == nil || len(rs.data) == 0
 }
+
+// SetContext stores the context of the request in the request scope. The data of
+// a zero value request scope is created, so that it can be set afterwards.
+func (rs *RequestScope) SetContext(ctx context.Context) {
+	if rs.data == nil {
+		rs.data = make(map[string]interface{})
+		rs.mutex = &sync.RWMutex{}
+	}
+	rs.lock()
+	defer rs.unlock()
+	rs.data[contextKey] = ctx
+}
+
+// Context returns the context of the request. Returns the background
+// context if no context is set.
+func (rs RequestScope) Context() context.Context {
+	rs.rlock()
+	defer rs.runlock()
+	if ctx, isContext := rs.data[contextKey].(context.Context); isContext {
+		return ctx
+	}
+	return context.Background()
+}
+
+// the locks of zero value request scopes, which have no data, are no-ops
+
+func (rs RequestScope) lock() {
+	if rs.mutex != nil {
+		rs.mutex.Lock()
+	}
+}
+
+func (rs RequestScope) unlock() {
+	if rs.mutex != nil {
+		rs.mutex.Unlock()
+	}
+}
+
+func (rs RequestScope) rlock() {
+	if rs.mutex != nil {
+		rs.mutex.RLock()
+	}
+}
+
+func (rs RequestScope) runlock() {
+	if rs.mutex != nil {
+		rs.mutex.RUnlock()
+	}
+}
+
+type scopeKey struct{}
+
+// NewContext returns a context which carries the request scope, so that the data
+// providers which receive the context of the request can reach its request scope.
+func NewContext(ctx context.Context, rs RequestScope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, rs)
+}
+
+// FromContext returns the request scope the context carries.
+func FromContext(ctx context.Context) (rs RequestScope, exists bool) {
+	rs, exists = ctx.Value(scopeKey{}).(RequestScope)
+	return
+}
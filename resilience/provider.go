@@ -0,0 +1,316 @@
+package resilience
+
+import (
+	"io"
+	"context"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Provider applies the options to every call of the provider it wraps. It is a
+// dataprovider.ContextBinder, so the contexts of the requests are passed to the wrapped
+// provider; retries stop when they are done.
+type Provider struct {
+	provider dataprovider.Provider
+	options  Options
+	breaker  *breaker
+
+	// ctx is the context of the calls which don't take one
+	ctx context.Context
+}
+
+// New returns the provider with retries, timeouts and a circuit breaker. Use NewTransactional
+// to keep the transactions of the provider.
+func New(provider dataprovider.Provider, options Options) *Provider {
+	if options.IsTransient == nil {
+		options.IsTransient = DefaultIsTransient
+	}
+	return &Provider{
+		provider: provider,
+		options:  options,
+		breaker:  &breaker{threshold: options.FailureThreshold, duration: options.OpenDuration},
+		ctx:      context.Background(),
+	}
+}
+
+// State returns the state of the circuit breaker: Closed, Open or HalfOpen.
+func (p *Provider) State() string {
+	return p.breaker.currentState()
+}
+
+// BindContext returns the provider sharing the circuit breaker, whose calls take the context.
+func (p *Provider) BindContext(ctx context.Context) dataprovider.Provider {
+	return &Provider{provider: p.provider, options: p.options, breaker: p.breaker, ctx: ctx}
+}
+
+// call makes the call with the retries, the timeout and the circuit breaker. The call counts
+// once for the breaker, after its last attempt. Errors which are not transient, ex: 404 Not
+// Found, count as successful calls.
+func (p *Provider) call(ctx context.Context, retry bool, call func(provider dataprovider.Provider) (interface{}, *utils.Error)) (result interface{}, err *utils.Error) {
+
+	if !p.breaker.allow() {
+		return nil, unavailable()
+	}
+
+	attempts := 1
+	if retry {
+		attempts += p.options.Retries
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 && !sleep(ctx, p.options.backoff(attempt-1)) {
+			p.breaker.abandoned()
+			return nil, utils.ContextError(ctx.Err())
+		}
+
+		result, err = p.attempt(ctx, call)
+		if ctx.Err() != nil {
+			p.breaker.abandoned()
+			return nil, utils.ContextError(ctx.Err())
+		}
+		if err == nil || !p.options.IsTransient(err) {
+			p.breaker.succeeded()
+			return
+		}
+	}
+	p.breaker.failed()
+	return
+}
+
+// attempt makes the call once, in another goroutine if there is a timeout, so that the calls
+// of providers which don't take a context can be abandoned too.
+func (p *Provider) attempt(ctx context.Context, call func(provider dataprovider.Provider) (interface{}, *utils.Error)) (result interface{}, err *utils.Error) {
+
+	if p.options.Timeout <= 0 {
+		return call(dataprovider.WithContext(ctx, p.provider))
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, p.options.Timeout)
+	defer cancel()
+
+	type outcome struct {
+		result interface{}
+		err    *utils.Error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := call(dataprovider.WithContext(attemptCtx, p.provider))
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		if o.err != nil && attemptCtx.Err() == context.DeadlineExceeded {
+			return nil, timedOut()
+		}
+		return o.result, o.err
+	case <-attemptCtx.Done():
+		// files opened and transactions begun after the timeout are released
+		go func() {
+			switch result := (<-done).result.(type) {
+			case files.File:
+				result.Close()
+			case dataprovider.Transaction:
+				result.Rollback()
+			}
+		}()
+		return nil, timedOut()
+	}
+}
+
+func (p *Provider) document(ctx context.Context, retry bool, call func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error)) (response map[string]interface{}, err *utils.Error) {
+	result, err := p.call(ctx, retry, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return call(provider)
+	})
+	response, _ = result.(map[string]interface{})
+	return
+}
+
+func (p *Provider) Connect() (err *utils.Error) {
+	_, err = p.call(p.ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return nil, provider.Connect()
+	})
+	return
+}
+
+func (p *Provider) EnsureIndexes(indexes []dataprovider.Index) (err *utils.Error) {
+	_, err = p.call(p.ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return nil, dataprovider.EnsureIndexes(provider, indexes)
+	})
+	return
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.CreateContext(p.ctx, collection, data)
+}
+
+func (p *Provider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.GetContext(p.ctx, collection, id)
+}
+
+func (p *Provider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return p.QueryContext(p.ctx, collection, parameters)
+}
+
+func (p *Provider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, true, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.QueryStructured(provider, collection, q)
+	})
+}
+
+func (p *Provider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	result, err := p.call(p.ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return dataprovider.Count(provider, collection, where)
+	})
+	count, _ = result.(int)
+	return
+}
+
+func (p *Provider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, true, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.Aggregate(provider, collection, aggregation)
+	})
+}
+
+func (p *Provider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, true, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.Search(provider, collection, text, q)
+	})
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.UpdateContext(p.ctx, collection, id, data)
+}
+
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, p.options.RetryWrites, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.Modify(provider, collection, id, modify)
+	})
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.DeleteContext(p.ctx, collection, id)
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, p.options.RetryWrites, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.DeleteIf(provider, collection, id, check)
+	})
+}
+
+func (p *Provider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.CreateFileContext(p.ctx, data)
+}
+
+func (p *Provider) GetFile(id string) (response []byte, err *utils.Error) {
+	return p.GetFileContext(p.ctx, id)
+}
+
+func (p *Provider) OpenFile(id string) (file files.File, err *utils.Error) {
+	result, err := p.call(p.ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return dataprovider.OpenFile(provider, id)
+	})
+	file, _ = result.(files.File)
+	return
+}
+
+func (p *Provider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.document(p.ctx, false, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return dataprovider.CreateFileWithInfo(provider, info, data)
+	})
+}
+
+func (p *Provider) CreateContext(ctx context.Context, collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, p.options.RetryWrites, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.Create(collection, data)
+	})
+}
+
+func (p *Provider) GetContext(ctx context.Context, collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, true, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.Get(collection, id)
+	})
+}
+
+func (p *Provider) QueryContext(ctx context.Context, collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, true, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.Query(collection, parameters)
+	})
+}
+
+func (p *Provider) UpdateContext(ctx context.Context, collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, p.options.RetryWrites, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.Update(collection, id, data)
+	})
+}
+
+func (p *Provider) DeleteContext(ctx context.Context, collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, p.options.RetryWrites, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.Delete(collection, id)
+	})
+}
+
+func (p *Provider) CreateFileContext(ctx context.Context, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.document(ctx, false, func(provider dataprovider.Provider) (map[string]interface{}, *utils.Error) {
+		return provider.CreateFile(data)
+	})
+}
+
+func (p *Provider) GetFileContext(ctx context.Context, id string) (response []byte, err *utils.Error) {
+	result, err := p.call(ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return provider.GetFile(id)
+	})
+	response, _ = result.([]byte)
+	return
+}
+
+// TransactionalProvider is a resilient transactional provider. Calls in a transaction have the
+// timeout and share the circuit breaker, but they are not retried since a transaction cannot
+// be continued after an error.
+type TransactionalProvider struct {
+	*Provider
+	transactional dataprovider.TransactionalProvider
+}
+
+// NewTransactional returns the transactional provider with retries, timeouts and a circuit breaker.
+func NewTransactional(provider dataprovider.TransactionalProvider, options Options) *TransactionalProvider {
+	return &TransactionalProvider{New(provider, options), provider}
+}
+
+func (p *TransactionalProvider) Begin(ctx context.Context) (transaction dataprovider.Transaction, err *utils.Error) {
+
+	// the transaction is begun with the context of the request, not of the attempt, which ends
+	result, err := p.call(ctx, true, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return p.transactional.Begin(ctx)
+	})
+	if err != nil {
+		return
+	}
+
+	options := p.options
+	options.Retries = 0
+	begun := result.(dataprovider.Transaction)
+	transaction = &resilientTransaction{&Provider{provider: begun, options: options, breaker: p.breaker, ctx: ctx}, begun}
+	return
+}
+
+type resilientTransaction struct {
+	*Provider
+	transaction dataprovider.Transaction
+}
+
+func (t *resilientTransaction) Commit() (err *utils.Error) {
+	_, err = t.call(context.Background(), false, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return nil, t.transaction.Commit()
+	})
+	return
+}
+
+func (t *resilientTransaction) Rollback() (err *utils.Error) {
+	_, err = t.call(context.Background(), false, func(provider dataprovider.Provider) (interface{}, *utils.Error) {
+		return nil, t.transaction.Rollback()
+	})
+	return
+}
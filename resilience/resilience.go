@@ -0,0 +1,190 @@
+// Package resilience protects the requests from a failing data provider. Calls are retried with
+// exponential backoff on transient errors, every attempt has a timeout, and a circuit breaker
+// fails the calls fast while the provider keeps failing, instead of letting every request wait.
+//
+// Usage:
+//
+//   core.DataProvider = resilience.New(provider, resilience.Options{
+//       Retries:          2,
+//       Backoff:          50 * time.Millisecond,
+//       Timeout:          2 * time.Second,
+//       FailureThreshold: 5,
+//       OpenDuration:     10 * time.Second,
+//   })
+package resilience
+
+import (
+	"sync"
+	"time"
+	"context"
+	"math/rand"
+	"net/http"
+	"github.com/rihtim/core/utils"
+)
+
+type Options struct {
+	// Retries is the number of times a call is retried after a transient error.
+	Retries int
+
+	// Backoff is the wait before the first retry. It doubles for every retry up to MaxBackoff,
+	// and a random jitter of up to the half of it is added.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// RetryWrites enables retrying the writes. Writes which fail with a timeout may be applied
+	// already, so retrying them may, ex: create a document twice. Uploads are never retried.
+	RetryWrites bool
+
+	// Timeout is the maximum duration of an attempt. Zero means no timeout.
+	Timeout time.Duration
+
+	// FailureThreshold is the number of consecutive failed calls which opens the circuit.
+	// Zero disables the circuit breaker.
+	FailureThreshold int
+
+	// OpenDuration is how long the calls fail fast once the circuit opens. After it, a
+	// single call is let through and the circuit closes if it succeeds.
+	OpenDuration time.Duration
+
+	// IsTransient tells whether an error may not happen again if the call is retried.
+	// If nil, DefaultIsTransient is used.
+	IsTransient func(err *utils.Error) bool
+}
+
+// DefaultIsTransient treats the server errors except 501 Not Implemented, 408 Request
+// Timeout and 429 Too Many Requests as transient.
+func DefaultIsTransient(err *utils.Error) bool {
+	switch err.Code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented:
+		return false
+	}
+	return err.Code >= 500 && err.Code < 600
+}
+
+// States of the circuit breaker.
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// breaker counts the consecutive failures and opens the circuit at the threshold.
+type breaker struct {
+	mutex     sync.Mutex
+	threshold int
+	duration  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	probing   bool
+}
+
+// allow tells whether a call can be made. In half-open state only one call is let through.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.duration {
+			return false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *breaker) succeeded() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+	b.state = Closed
+	b.probing = false
+}
+
+func (b *breaker) failed() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.state = Open
+		b.openedAt = time.Now()
+	}
+}
+
+// abandoned ends a call which neither succeeded nor failed, ex: canceled by the client.
+func (b *breaker) abandoned() {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) currentState() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == "" {
+		return Closed
+	}
+	return b.state
+}
+
+func unavailable() *utils.Error {
+	return &utils.Error{Code: http.StatusServiceUnavailable, Message: "Data provider is unavailable."}
+}
+
+func timedOut() *utils.Error {
+	return &utils.Error{Code: http.StatusGatewayTimeout, Message: "Data provider timed out."}
+}
+
+// backoff returns the wait before the retry with the given number, starting from 0.
+func (o Options) backoff(retry int) time.Duration {
+	wait := o.Backoff
+	for i := 0; i < retry && (o.MaxBackoff <= 0 || wait < o.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if o.MaxBackoff > 0 && wait > o.MaxBackoff {
+		wait = o.MaxBackoff
+	}
+	if wait > 0 {
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+	}
+	return wait
+}
+
+// sleep waits for the duration. Returns false if the context is done before.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
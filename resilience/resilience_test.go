@@ -0,0 +1,160 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flaky fails the given number of calls, then delegates to the memory provider.
+type flaky struct {
+	*memory.Provider
+	mutex    sync.Mutex
+	failures int
+	delay    time.Duration
+	calls    int
+}
+
+func (f *flaky) fail() (err *utils.Error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return &utils.Error{Code: http.StatusServiceUnavailable, Message: "Connection refused."}
+	}
+	return
+}
+
+func (f *flaky) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	time.Sleep(f.delay)
+	if err = f.fail(); err != nil {
+		return
+	}
+	return f.Provider.Get(collection, id)
+}
+
+func (f *flaky) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if err = f.fail(); err != nil {
+		return
+	}
+	return f.Provider.Create(collection, data)
+}
+
+func (f *flaky) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	if err = f.fail(); err != nil {
+		return
+	}
+	return dataprovider.Count(f.Provider, collection, where)
+}
+
+func TestResilience(t *testing.T) {
+
+	Convey("Given a flaky provider", t, func() {
+		backend := &flaky{Provider: &memory.Provider{}}
+		created, _ := backend.Provider.Create("users", map[string]interface{}{"name": "alice"})
+		id := created[dataprovider.IdField].(string)
+
+		provider := New(backend, Options{Retries: 2, Backoff: time.Millisecond, Timeout: 50 * time.Millisecond, FailureThreshold: 3, OpenDuration: 20 * time.Millisecond})
+
+		Convey("Reads should be retried on transient errors", func() {
+			backend.failures = 2
+			object, err := provider.Get("users", id)
+			So(err, ShouldBeNil)
+			So(object["name"], ShouldEqual, "alice")
+			So(backend.calls, ShouldEqual, 3)
+		})
+
+		Convey("Errors which are not transient should not be retried", func() {
+			_, err := provider.Get("users", "unknown")
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			So(backend.calls, ShouldEqual, 1)
+			So(provider.State(), ShouldEqual, Closed)
+		})
+
+		Convey("Writes should not be retried by default", func() {
+			backend.failures = 1
+			_, err := provider.Create("users", map[string]interface{}{"name": "bob"})
+			So(err.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(backend.calls, ShouldEqual, 1)
+		})
+
+		Convey("Slow calls should time out", func() {
+			backend.delay = 100 * time.Millisecond
+			provider := New(backend, Options{Timeout: 10 * time.Millisecond})
+			_, err := provider.Get("users", id)
+			So(err.Code, ShouldEqual, http.StatusGatewayTimeout)
+		})
+
+		Convey("The circuit should open after the consecutive failed calls", func() {
+			backend.failures = 9
+
+			// the retries of a call count once
+			_, err := provider.Get("users", id)
+			So(err, ShouldNotBeNil)
+			So(provider.State(), ShouldEqual, Closed)
+
+			provider.Get("users", id)
+			provider.Get("users", id)
+			So(provider.State(), ShouldEqual, Open)
+
+			Convey("Calls should fail fast while it is open", func() {
+				_, err := provider.Get("users", id)
+				So(err.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(err.Message, ShouldEqual, "Data provider is unavailable.")
+				So(backend.calls, ShouldEqual, 9)
+			})
+
+			Convey("A successful call should close it after the open duration", func() {
+				time.Sleep(30 * time.Millisecond)
+				_, err := provider.Get("users", id)
+				So(err, ShouldBeNil)
+				So(provider.State(), ShouldEqual, Closed)
+			})
+		})
+
+		Convey("Retries should stop when the context is done", func() {
+			backend.failures = 10
+			ctx, cancel := context.WithCancel(context.Background())
+			provider := New(backend, Options{Retries: 5, Backoff: time.Second})
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err := provider.GetContext(ctx, "users", id)
+			So(err.Code, ShouldEqual, utils.StatusClientClosedRequest)
+			So(backend.calls, ShouldEqual, 1)
+		})
+
+		Convey("Retries of the calls without a context should stop when the bound context is done", func() {
+			backend.failures = 10
+			ctx, cancel := context.WithCancel(context.Background())
+			provider := New(backend, Options{Retries: 5, Backoff: time.Second})
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err := dataprovider.Count(dataprovider.WithContext(ctx, provider), "users", nil)
+			So(err.Code, ShouldEqual, utils.StatusClientClosedRequest)
+			So(backend.calls, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a resilient transactional provider", t, func() {
+		provider := NewTransactional(&memory.Provider{}, Options{Retries: 2, Timeout: time.Second})
+
+		Convey("Transactions should be committed", func() {
+			transaction, err := provider.Begin(context.Background())
+			So(err, ShouldBeNil)
+			created, err := transaction.Create("users", map[string]interface{}{"name": "alice"})
+			So(err, ShouldBeNil)
+			So(transaction.Commit(), ShouldBeNil)
+
+			_, err = provider.Get("users", created[dataprovider.IdField].(string))
+			So(err, ShouldBeNil)
+		})
+	})
+}
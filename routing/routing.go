@@ -0,0 +1,216 @@
+// Package routing serves the collections from different data providers, ex: logs from an
+// append optimized store and the rest from the primary database.
+//
+// Usage:
+//
+//   core.DataProvider, err = routing.New(primary,
+//       routing.Route{Pattern: "logs", Provider: logStore},
+//       routing.Route{Pattern: "metrics_*", Provider: logStore},
+//   )
+package routing
+
+import (
+	"io"
+	"path"
+	"context"
+	"reflect"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Route serves the collections matching the pattern from the provider. Patterns are matched
+// like path.Match, ex: logs_* matches logs_2020 and logs_2021.
+type Route struct {
+	Pattern  string
+	Provider dataprovider.Provider
+}
+
+// Provider routes every operation to the provider of its collection. A collection is served by
+// the route with the same name, or by the first route whose pattern matches it, or by the
+// default provider. Files are served by the default provider.
+//
+// Transactions are not supported since they cannot span providers, so requests are not executed
+// in transactions even if the providers support them. It is a dataprovider.ContextBinder, so the
+// contexts of the requests are passed to the providers of the routes.
+type Provider struct {
+	defaultProvider dataprovider.Provider
+	routes          []Route
+
+	// ctx is the context the routed providers are bound to, nil if the provider is not bound
+	ctx context.Context
+}
+
+// New returns a provider which routes the collections to the providers of the routes, and the
+// rest to the default provider. Returns an error with code 500 if a pattern is malformed or
+// a provider is nil.
+func New(defaultProvider dataprovider.Provider, routes ...Route) (provider *Provider, err *utils.Error) {
+
+	if defaultProvider == nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Default provider of the routes is missing."}
+		return
+	}
+	for _, route := range routes {
+		if _, matchErr := path.Match(route.Pattern, ""); matchErr != nil {
+			err = &utils.Error{Code: http.StatusInternalServerError, Message: "Invalid route pattern '" + route.Pattern + "'."}
+			return
+		}
+		if route.Provider == nil {
+			err = &utils.Error{Code: http.StatusInternalServerError, Message: "Provider of the route '" + route.Pattern + "' is missing."}
+			return
+		}
+	}
+
+	provider = &Provider{defaultProvider: defaultProvider, routes: append([]Route{}, routes...)}
+	return
+}
+
+// BindContext returns the provider which binds the providers it routes to the context.
+func (p *Provider) BindContext(ctx context.Context) dataprovider.Provider {
+	return &Provider{defaultProvider: p.defaultProvider, routes: p.routes, ctx: ctx}
+}
+
+// For returns the provider which serves the collection, bound to the context of the provider.
+func (p *Provider) For(collection string) dataprovider.Provider {
+	return p.bind(p.route(collection))
+}
+
+func (p *Provider) bind(provider dataprovider.Provider) dataprovider.Provider {
+	if p.ctx == nil {
+		return provider
+	}
+	return dataprovider.WithContext(p.ctx, provider)
+}
+
+func (p *Provider) route(collection string) dataprovider.Provider {
+	for _, route := range p.routes {
+		if route.Pattern == collection {
+			return route.Provider
+		}
+	}
+	for _, route := range p.routes {
+		if matches, _ := path.Match(route.Pattern, collection); matches {
+			return route.Provider
+		}
+	}
+	return p.defaultProvider
+}
+
+// Connect connects every provider once.
+func (p *Provider) Connect() (err *utils.Error) {
+	providers := []dataprovider.Provider{p.defaultProvider}
+	for _, route := range p.routes {
+		providers = append(providers, route.Provider)
+	}
+
+	connected := []dataprovider.Provider{}
+	for _, provider := range providers {
+		if indexOf(connected, provider) >= 0 {
+			continue
+		}
+		if err = provider.Connect(); err != nil {
+			return
+		}
+		connected = append(connected, provider)
+	}
+	return
+}
+
+// EnsureIndexes ensures the indexes on the providers which serve their collections.
+func (p *Provider) EnsureIndexes(indexes []dataprovider.Index) (err *utils.Error) {
+	providers := []dataprovider.Provider{}
+	grouped := [][]dataprovider.Index{}
+	for _, index := range indexes {
+		provider := p.route(index.Collection)
+		i := indexOf(providers, provider)
+		if i < 0 {
+			i = len(providers)
+			providers = append(providers, provider)
+			grouped = append(grouped, nil)
+		}
+		grouped[i] = append(grouped[i], index)
+	}
+
+	for i, provider := range providers {
+		if err = dataprovider.EnsureIndexes(p.bind(provider), grouped[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.For(collection).Create(collection, data)
+}
+
+func (p *Provider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.For(collection).Get(collection, id)
+}
+
+func (p *Provider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return p.For(collection).Query(collection, parameters)
+}
+
+func (p *Provider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.QueryStructured(p.For(collection), collection, q)
+}
+
+func (p *Provider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return dataprovider.Count(p.For(collection), collection, where)
+}
+
+func (p *Provider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Aggregate(p.For(collection), collection, aggregation)
+}
+
+func (p *Provider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Search(p.For(collection), collection, text, q)
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return p.For(collection).Update(collection, id, data)
+}
+
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Modify(p.For(collection), collection, id, modify)
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return p.For(collection).Delete(collection, id)
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.DeleteIf(p.For(collection), collection, id, check)
+}
+
+func (p *Provider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return p.bind(p.defaultProvider).CreateFile(data)
+}
+
+func (p *Provider) GetFile(id string) (response []byte, err *utils.Error) {
+	return p.bind(p.defaultProvider).GetFile(id)
+}
+
+func (p *Provider) OpenFile(id string) (file files.File, err *utils.Error) {
+	return dataprovider.OpenFile(p.bind(p.defaultProvider), id)
+}
+
+func (p *Provider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.CreateFileWithInfo(p.bind(p.defaultProvider), info, data)
+}
+
+// indexOf returns the position of the provider in the list, or -1 if it is not in the list.
+// Providers of types which cannot be compared, ex: structs with maps, are never found.
+func indexOf(list []dataprovider.Provider, provider dataprovider.Provider) int {
+	if !reflect.TypeOf(provider).Comparable() {
+		return -1
+	}
+	for i, item := range list {
+		if reflect.TypeOf(item) == reflect.TypeOf(provider) && item == provider {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"testing"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type connectCounter struct {
+	*memory.Provider
+	connects int
+}
+
+func (c *connectCounter) Connect() (err *utils.Error) {
+	c.connects++
+	return c.Provider.Connect()
+}
+
+type contextKey struct{}
+
+// contextRecorder records the context it is bound to.
+type contextRecorder struct {
+	*memory.Provider
+	bound *context.Context
+}
+
+func (r contextRecorder) BindContext(ctx context.Context) dataprovider.Provider {
+	*r.bound = ctx
+	return r
+}
+
+func TestRouting(t *testing.T) {
+
+	Convey("Given providers with routes", t, func() {
+		primary := &connectCounter{Provider: &memory.Provider{}}
+		logs := &connectCounter{Provider: &memory.Provider{}}
+		archive := &memory.Provider{}
+
+		provider, err := New(primary,
+			Route{Pattern: "logs_*", Provider: archive},
+			Route{Pattern: "logs_*", Provider: logs},
+			Route{Pattern: "logs_today", Provider: logs},
+		)
+		So(err, ShouldBeNil)
+
+		Convey("Collections should be served by their routes", func() {
+			So(provider.For("logs_today"), ShouldEqual, logs)
+			So(provider.For("logs_2020"), ShouldEqual, archive)
+			So(provider.For("users"), ShouldEqual, primary)
+
+			created, err := provider.Create("logs_today", map[string]interface{}{"message": "started"})
+			So(err, ShouldBeNil)
+			id := created[dataprovider.IdField].(string)
+
+			_, err = logs.Get("logs_today", id)
+			So(err, ShouldBeNil)
+			_, err = primary.Get("logs_today", id)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			count, err := provider.Count("logs_today", nil)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("The context the provider is bound to should reach the providers of the routes", func() {
+			var bound context.Context
+			provider, _ := New(primary, Route{Pattern: "logs_today", Provider: contextRecorder{logs.Provider, &bound}})
+			ctx := context.WithValue(context.Background(), contextKey{}, "request")
+
+			_, err := dataprovider.Count(dataprovider.WithContext(ctx, provider), "logs_today", nil)
+			So(err, ShouldBeNil)
+			So(bound, ShouldEqual, ctx)
+		})
+
+		Convey("Every provider should be connected once", func() {
+			So(provider.Connect(), ShouldBeNil)
+			So(primary.connects, ShouldEqual, 1)
+			So(logs.connects, ShouldEqual, 1)
+		})
+
+		Convey("Malformed patterns should be rejected", func() {
+			_, err := New(primary, Route{Pattern: "logs_[", Provider: logs})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package schema
+
+import (
+	"io"
+	"net/http"
+	"github.com/rihtim/core/files"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Enforce returns a provider which validates the documents of the collections which have a
+// schema in the registry before they are created or updated. Updated documents are validated
+// as a whole, after the changes are applied. Generated fields and the deletion time of soft
+// deleted documents are not validated, and soft deleting or restoring a document doesn't
+// validate it.
+//
+// Violations are returned as an error with code 422 and the list of violations as details.
+func Enforce(provider dataprovider.Provider, registry *Registry) dataprovider.Provider {
+	return &enforcingProvider{provider, registry}
+}
+
+type enforcingProvider struct {
+	provider dataprovider.Provider
+	registry *Registry
+}
+
+func (ep *enforcingProvider) Connect() (err *utils.Error) {
+	return ep.provider.Connect()
+}
+
+func (ep *enforcingProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if err = ep.check(collection, data); err != nil {
+		return
+	}
+	return ep.provider.Create(collection, data)
+}
+
+func (ep *enforcingProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Get(collection, id)
+}
+
+func (ep *enforcingProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Query(collection, parameters)
+}
+
+func (ep *enforcingProvider) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.QueryStructured(ep.provider, collection, q)
+}
+
+func (ep *enforcingProvider) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return dataprovider.Count(ep.provider, collection, where)
+}
+
+func (ep *enforcingProvider) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Aggregate(ep.provider, collection, aggregation)
+}
+
+func (ep *enforcingProvider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Search(ep.provider, collection, text, q)
+}
+
+// Update applies the changes in a modification, so that the resulting document is validated.
+func (ep *enforcingProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if _, hasSchema := ep.registry.Get(collection); !hasSchema {
+		return ep.provider.Update(collection, id, data)
+	}
+	return ep.Modify(collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		for key, value := range data {
+			if key != dataprovider.IdField && key != dataprovider.CreatedAtField {
+				document[key] = value
+			}
+		}
+		return document, nil
+	})
+}
+
+func (ep *enforcingProvider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if _, hasSchema := ep.registry.Get(collection); !hasSchema {
+		return dataprovider.Modify(ep.provider, collection, id, modify)
+	}
+	return dataprovider.Modify(ep.provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		original := utils.CopyDocument(document)
+		modified, err := modify(document)
+		if err != nil {
+			return nil, err
+		}
+		// soft deleting and restoring don't change the content, so documents which don't match
+		// a schema which was changed after they were written can still be deleted and restored
+		if dataprovider.OnlyDeletedAtChanged(original, modified) {
+			return modified, nil
+		}
+		if err = ep.check(collection, modified); err != nil {
+			return nil, err
+		}
+		return modified, nil
+	})
+}
+
+func (ep *enforcingProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.Delete(collection, id)
+}
+
+func (ep *enforcingProvider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.DeleteIf(ep.provider, collection, id, check)
+}
+
+func (ep *enforcingProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return ep.provider.CreateFile(data)
+}
+
+func (ep *enforcingProvider) GetFile(id string) (response []byte, err *utils.Error) {
+	return ep.provider.GetFile(id)
+}
+
+func (ep *enforcingProvider) OpenFile(id string) (file files.File, err *utils.Error) {
+	return dataprovider.OpenFile(ep.provider, id)
+}
+
+func (ep *enforcingProvider) CreateFileWithInfo(info files.Info, data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.CreateFileWithInfo(ep.provider, info, data)
+}
+
+func (ep *enforcingProvider) check(collection string, document map[string]interface{}) (err *utils.Error) {
+
+	schema, hasSchema := ep.registry.Get(collection)
+	if !hasSchema {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(document))
+	for key, value := range document {
+		fields[key] = value
+	}
+	for _, generated := range []string{dataprovider.IdField, dataprovider.CreatedAtField, dataprovider.UpdatedAtField, dataprovider.DeletedAtField} {
+		delete(fields, generated)
+	}
+
+	if violations := schema.Validate(fields); len(violations) > 0 {
+		err = &utils.Error{Code: http.StatusUnprocessableEntity, Message: "Document does not match the schema of '" + collection + "'.", Details: violations}
+	}
+	return
+}
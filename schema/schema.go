@@ -0,0 +1,181 @@
+// Package schema validates documents against JSON Schemas. It supports a subset of
+// draft 2020-12 which covers the documents of a collection:
+//
+//   type, enum, const, $ref (to the same schema), $defs, allOf, anyOf, oneOf, not,
+//   properties, patternProperties, additionalProperties, required,
+//   minProperties, maxProperties, items, prefixItems, minItems, maxItems, uniqueItems,
+//   minLength, maxLength, pattern, format (date-time, date, email, uri, uuid),
+//   minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//
+// Other keywords are ignored.
+package schema
+
+import (
+	"sync"
+	"errors"
+	"regexp"
+	"strings"
+	"encoding/json"
+)
+
+// Violation is a failed constraint. Path is the JSON Pointer of the failing value
+// in the document, ex: /address/city, and is empty for the document itself.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// Parse compiles the JSON encoded schema.
+func Parse(data []byte) (schema *Schema, err error) {
+	var root interface{}
+	if err = json.Unmarshal(data, &root); err != nil {
+		return
+	}
+	return compile(root)
+}
+
+// Compile compiles the schema decoded from JSON.
+func Compile(root map[string]interface{}) (schema *Schema, err error) {
+	return compile(root)
+}
+
+func compile(root interface{}) (schema *Schema, err error) {
+	root = normalize(root)
+	schema = &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err = schema.check(root); err != nil {
+		schema = nil
+	}
+	return
+}
+
+// check walks the subschemas, compiles the patterns and resolves the references
+// in advance, so that validation doesn't fail because of the schema itself.
+func (s *Schema) check(node interface{}) error {
+
+	if _, isBool := node.(bool); isBool {
+		return nil
+	}
+	object, isObject := node.(map[string]interface{})
+	if !isObject {
+		return errors.New("schema must be an object or a boolean")
+	}
+
+	if pattern, hasPattern := object["pattern"].(string); hasPattern {
+		if err := s.compilePattern(pattern); err != nil {
+			return err
+		}
+	}
+	if ref, hasRef := object["$ref"].(string); hasRef {
+		if _, err := s.resolve(ref); err != nil {
+			return err
+		}
+	}
+
+	for _, keyword := range []string{"additionalProperties", "items", "not"} {
+		if subschema, exists := object[keyword]; exists {
+			if err := s.check(subschema); err != nil {
+				return errors.New(keyword + ": " + err.Error())
+			}
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "$defs", "definitions"} {
+		subschemas, _ := object[keyword].(map[string]interface{})
+		for name, subschema := range subschemas {
+			if keyword == "patternProperties" {
+				if err := s.compilePattern(name); err != nil {
+					return err
+				}
+			}
+			if err := s.check(subschema); err != nil {
+				return errors.New(keyword + "/" + name + ": " + err.Error())
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems"} {
+		subschemas, _ := object[keyword].([]interface{})
+		for _, subschema := range subschemas {
+			if err := s.check(subschema); err != nil {
+				return errors.New(keyword + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(pattern string) error {
+	if _, compiled := s.patterns[pattern]; compiled {
+		return nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.New("invalid pattern '" + pattern + "': " + err.Error())
+	}
+	s.patterns[pattern] = compiled
+	return nil
+}
+
+// resolve returns the subschema referenced by a JSON Pointer in the same schema, ex: #/$defs/address
+func (s *Schema) resolve(ref string) (node interface{}, err error) {
+
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, errors.New("only references in the same schema are supported: " + ref)
+	}
+
+	node = s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[token]
+		case []interface{}:
+			index := -1
+			json.Unmarshal([]byte(token), &index)
+			if index < 0 || index >= len(n) {
+				return nil, errors.New("unresolvable reference: " + ref)
+			}
+			node = n[index]
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, errors.New("unresolvable reference: " + ref)
+		}
+	}
+	return
+}
+
+// Registry keeps the schemas of the collections. It is safe for concurrent use and
+// its zero value is an empty registry.
+type Registry struct {
+	mutex   sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// Add sets the schema of the collection, replacing the previous one.
+func (r *Registry) Add(collection string, schema *Schema) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.schemas == nil {
+		r.schemas = make(map[string]*Schema)
+	}
+	r.schemas[collection] = schema
+}
+
+func (r *Registry) Remove(collection string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.schemas, collection)
+}
+
+func (r *Registry) Get(collection string) (schema *Schema, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	schema, exists = r.schemas[collection]
+	return
+}
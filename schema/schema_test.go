@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "email"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 10},
+		"email": {"type": "string", "format": "email"},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"role": {"enum": ["admin", "member"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"$defs": {
+		"address": {
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}, "zip": {"type": "string", "pattern": "^[0-9]{5}$"}}
+		}
+	}
+}`
+
+func paths(violations []Violation) []string {
+	list := make([]string, len(violations))
+	for i, violation := range violations {
+		list[i] = violation.Path
+	}
+	return list
+}
+
+func TestValidate(t *testing.T) {
+
+	Convey("Given a schema", t, func() {
+		schema, err := Parse([]byte(userSchema))
+		So(err, ShouldBeNil)
+
+		Convey("A valid document should have no violations", func() {
+			violations := schema.Validate(map[string]interface{}{
+				"name": "alice", "email": "alice@example.com", "age": 30, "role": "admin",
+				"tags": []interface{}{"a", "b"}, "address": map[string]interface{}{"city": "istanbul", "zip": "34000"},
+			})
+			So(violations, ShouldBeEmpty)
+		})
+
+		Convey("Every violation should be listed with its path", func() {
+			violations := schema.Validate(map[string]interface{}{
+				"name": "a", "age": 30.5, "role": "owner", "extra": true,
+				"tags": []interface{}{"a", "a", 1}, "address": map[string]interface{}{"zip": "abc"},
+			})
+			So(paths(violations), ShouldResemble, []string{
+				"/address/city", "/address/zip", "/age", "/email", "/extra", "/name", "/role", "/tags", "/tags/2",
+			})
+		})
+
+		Convey("A document of another type should fail at the root", func() {
+			violations := schema.Validate([]interface{}{})
+			So(len(violations), ShouldEqual, 1)
+			So(violations[0].Path, ShouldEqual, "")
+			So(violations[0].Message, ShouldEqual, "Must be of type object.")
+		})
+	})
+
+	Convey("Given a schema with combinators", t, func() {
+		schema, err := Parse([]byte(`{
+			"properties": {
+				"id": {"anyOf": [{"type": "string"}, {"type": "integer"}]},
+				"kind": {"oneOf": [{"const": "a"}, {"enum": ["a", "b"]}]},
+				"code": {"allOf": [{"type": "string"}, {"not": {"const": "root"}}]}
+			}
+		}`))
+		So(err, ShouldBeNil)
+
+		Convey("Matching values should have no violations", func() {
+			So(schema.Validate(map[string]interface{}{"id": 1, "kind": "b", "code": "user"}), ShouldBeEmpty)
+		})
+
+		Convey("Values which don't match should be listed", func() {
+			violations := schema.Validate(map[string]interface{}{"id": true, "kind": "a", "code": "root"})
+			So(paths(violations), ShouldResemble, []string{"/code", "/id", "/kind"})
+		})
+	})
+
+	Convey("Invalid schemas should not compile", t, func() {
+		_, err := Parse([]byte(`{"properties": {"a": {"pattern": "("}}}`))
+		So(err, ShouldNotBeNil)
+
+		_, err = Parse([]byte(`{"$ref": "#/$defs/missing"}`))
+		So(err, ShouldNotBeNil)
+
+		_, err = Compile(map[string]interface{}{"items": "string"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Recursive references should not recurse forever", t, func() {
+		schema, err := Parse([]byte(`{"$ref": "#"}`))
+		So(err, ShouldBeNil)
+		So(schema.Validate(map[string]interface{}{}), ShouldNotBeEmpty)
+	})
+}
+
+func TestEnforce(t *testing.T) {
+
+	Convey("Given a provider enforcing a schema", t, func() {
+		schema, _ := Parse([]byte(`{"required": ["name"], "additionalProperties": false, "properties": {"name": {"type": "string"}, "age": {"type": "number"}}}`))
+		registry := &Registry{}
+		registry.Add("users", schema)
+
+		provider := Enforce(&memory.Provider{}, registry)
+		created, err := provider.Create("users", map[string]interface{}{"name": "alice"})
+		So(err, ShouldBeNil)
+		id := created[dataprovider.IdField].(string)
+
+		Convey("Creating an invalid document should return the violations", func() {
+			_, err := provider.Create("users", map[string]interface{}{"age": "old"})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(paths(err.Details.([]Violation)), ShouldResemble, []string{"/age", "/name"})
+		})
+
+		Convey("Updates should be validated with the rest of the document", func() {
+			_, err := provider.Update("users", id, map[string]interface{}{"age": 30})
+			So(err, ShouldBeNil)
+
+			_, err = provider.Update("users", id, map[string]interface{}{"name": nil})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			user, _ := provider.Get("users", id)
+			So(user["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Modifications should be validated", func() {
+			_, err := dataprovider.Modify(provider, "users", id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+				document["extra"] = true
+				return document, nil
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Collections without a schema should not be validated", func() {
+			_, err := provider.Create("books", map[string]interface{}{"anything": true})
+			So(err, ShouldBeNil)
+		})
+	})
+}
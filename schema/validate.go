@@ -0,0 +1,390 @@
+package schema
+
+import (
+	"math"
+	"time"
+	"sort"
+	"regexp"
+	"strings"
+	"strconv"
+	"net/url"
+	"unicode/utf8"
+	"encoding/json"
+	"github.com/rihtim/core/query"
+)
+
+// maxDepth limits the nesting of references, which could otherwise recurse forever.
+const maxDepth = 64
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Validate returns every violation of the schema in the document, ordered by path.
+// Returns nil if the document is valid.
+func (s *Schema) Validate(document interface{}) (violations []Violation) {
+	s.validate(s.root, normalize(document), "", 0, &violations)
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return
+}
+
+func (s *Schema) validate(node interface{}, value interface{}, path string, depth int, violations *[]Violation) {
+
+	fail := func(message string) {
+		*violations = append(*violations, Violation{Path: path, Message: message})
+	}
+
+	if allowed, isBool := node.(bool); isBool {
+		if !allowed {
+			fail("Value is not allowed.")
+		}
+		return
+	}
+	object, _ := node.(map[string]interface{})
+
+	if ref, hasRef := object["$ref"].(string); hasRef {
+		if depth >= maxDepth {
+			fail("Schema references are nested too deep.")
+			return
+		}
+		resolved, _ := s.resolve(ref)
+		s.validate(resolved, value, path, depth+1, violations)
+	}
+
+	// stop at type mismatches, the other keywords would only repeat the error
+	if types, hasType := object["type"]; hasType && !matchesType(types, value) {
+		fail("Must be of type " + typeNames(types) + ".")
+		return
+	}
+
+	if enum, hasEnum := object["enum"].([]interface{}); hasEnum {
+		found := false
+		for _, item := range enum {
+			if found = equal(value, item); found {
+				break
+			}
+		}
+		if !found {
+			fail("Must be one of " + encode(enum) + ".")
+		}
+	}
+	if constant, hasConst := object["const"]; hasConst && !equal(value, constant) {
+		fail("Must be " + encode(constant) + ".")
+	}
+
+	s.validateCombinators(object, value, path, depth, fail, violations)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(object, v, path, depth, fail, violations)
+	case []interface{}:
+		s.validateArray(object, v, path, depth, fail, violations)
+	case string:
+		s.validateString(object, v, fail)
+	case float64:
+		validateNumber(object, v, fail)
+	}
+}
+
+func (s *Schema) validateCombinators(object map[string]interface{}, value interface{}, path string, depth int, fail func(string), violations *[]Violation) {
+
+	if subschemas, hasAllOf := object["allOf"].([]interface{}); hasAllOf {
+		for _, subschema := range subschemas {
+			s.validate(subschema, value, path, depth, violations)
+		}
+	}
+
+	if subschemas, hasAnyOf := object["anyOf"].([]interface{}); hasAnyOf {
+		if s.countValid(subschemas, value, path, depth) == 0 {
+			fail("Must match at least one of the schemas in anyOf.")
+		}
+	}
+
+	if subschemas, hasOneOf := object["oneOf"].([]interface{}); hasOneOf {
+		if s.countValid(subschemas, value, path, depth) != 1 {
+			fail("Must match exactly one of the schemas in oneOf.")
+		}
+	}
+
+	if subschema, hasNot := object["not"]; hasNot {
+		if s.countValid([]interface{}{subschema}, value, path, depth) == 1 {
+			fail("Must not match the schema in not.")
+		}
+	}
+}
+
+func (s *Schema) countValid(subschemas []interface{}, value interface{}, path string, depth int) (valid int) {
+	for _, subschema := range subschemas {
+		var subViolations []Violation
+		if s.validate(subschema, value, path, depth, &subViolations); len(subViolations) == 0 {
+			valid++
+		}
+	}
+	return
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, value map[string]interface{}, path string, depth int, fail func(string), violations *[]Violation) {
+
+	if required, hasRequired := object["required"].([]interface{}); hasRequired {
+		for _, field := range required {
+			if name, isString := field.(string); isString {
+				if _, exists := value[name]; !exists {
+					*violations = append(*violations, Violation{Path: path + "/" + escape(name), Message: "Is required."})
+				}
+			}
+		}
+	}
+
+	if minimum, hasMinimum := number(object["minProperties"]); hasMinimum && float64(len(value)) < minimum {
+		fail("Must have at least " + format(minimum) + " fields.")
+	}
+	if maximum, hasMaximum := number(object["maxProperties"]); hasMaximum && float64(len(value)) > maximum {
+		fail("Must have at most " + format(maximum) + " fields.")
+	}
+
+	properties, _ := object["properties"].(map[string]interface{})
+	patternProperties, _ := object["patternProperties"].(map[string]interface{})
+	additionalProperties, hasAdditional := object["additionalProperties"]
+
+	// validate the fields in name order, so that the violations are deterministic
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fieldPath := path + "/" + escape(name)
+		matched := false
+
+		if subschema, isProperty := properties[name]; isProperty {
+			matched = true
+			s.validate(subschema, value[name], fieldPath, depth, violations)
+		}
+		for pattern, subschema := range patternProperties {
+			if s.patterns[pattern].MatchString(name) {
+				matched = true
+				s.validate(subschema, value[name], fieldPath, depth, violations)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, isBool := additionalProperties.(bool); isBool && !allowed {
+				*violations = append(*violations, Violation{Path: fieldPath, Message: "Is not allowed."})
+			} else {
+				s.validate(additionalProperties, value[name], fieldPath, depth, violations)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(object map[string]interface{}, value []interface{}, path string, depth int, fail func(string), violations *[]Violation) {
+
+	if minimum, hasMinimum := number(object["minItems"]); hasMinimum && float64(len(value)) < minimum {
+		fail("Must have at least " + format(minimum) + " items.")
+	}
+	if maximum, hasMaximum := number(object["maxItems"]); hasMaximum && float64(len(value)) > maximum {
+		fail("Must have at most " + format(maximum) + " items.")
+	}
+
+	if unique, _ := object["uniqueItems"].(bool); unique {
+	unique:
+		for i := range value {
+			for j := 0; j < i; j++ {
+				if equal(value[i], value[j]) {
+					fail("Items must be unique.")
+					break unique
+				}
+			}
+		}
+	}
+
+	prefixItems, _ := object["prefixItems"].([]interface{})
+	items, hasItems := object["items"]
+	for i, item := range value {
+		itemPath := path + "/" + strconv.Itoa(i)
+		if i < len(prefixItems) {
+			s.validate(prefixItems[i], item, itemPath, depth, violations)
+		} else if hasItems {
+			s.validate(items, item, itemPath, depth, violations)
+		}
+	}
+}
+
+func (s *Schema) validateString(object map[string]interface{}, value string, fail func(string)) {
+
+	length := float64(utf8.RuneCountInString(value))
+	if minimum, hasMinimum := number(object["minLength"]); hasMinimum && length < minimum {
+		fail("Must be at least " + format(minimum) + " characters long.")
+	}
+	if maximum, hasMaximum := number(object["maxLength"]); hasMaximum && length > maximum {
+		fail("Must be at most " + format(maximum) + " characters long.")
+	}
+	if pattern, hasPattern := object["pattern"].(string); hasPattern && !s.patterns[pattern].MatchString(value) {
+		fail("Must match the pattern '" + pattern + "'.")
+	}
+	if formatName, hasFormat := object["format"].(string); hasFormat && !matchesFormat(formatName, value) {
+		fail("Must be a valid " + formatName + ".")
+	}
+}
+
+func validateNumber(object map[string]interface{}, value float64, fail func(string)) {
+
+	if minimum, hasMinimum := number(object["minimum"]); hasMinimum && value < minimum {
+		fail("Must be greater than or equal to " + format(minimum) + ".")
+	}
+	if maximum, hasMaximum := number(object["maximum"]); hasMaximum && value > maximum {
+		fail("Must be less than or equal to " + format(maximum) + ".")
+	}
+	if minimum, hasMinimum := number(object["exclusiveMinimum"]); hasMinimum && value <= minimum {
+		fail("Must be greater than " + format(minimum) + ".")
+	}
+	if maximum, hasMaximum := number(object["exclusiveMaximum"]); hasMaximum && value >= maximum {
+		fail("Must be less than " + format(maximum) + ".")
+	}
+	if divisor, hasMultipleOf := number(object["multipleOf"]); hasMultipleOf && divisor > 0 {
+		if quotient := value / divisor; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			fail("Must be a multiple of " + format(divisor) + ".")
+		}
+	}
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return isType(t, value)
+	case []interface{}:
+		for _, item := range t {
+			if name, isString := item.(string); isString && isType(name, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isType(name string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case float64:
+		return name == "number" || (name == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+func typeNames(types interface{}) string {
+	if list, isList := types.([]interface{}); isList {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			if name, isString := item.(string); isString {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, " or ")
+	}
+	name, _ := types.(string)
+	return name
+}
+
+func matchesFormat(name, value string) bool {
+	switch name {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "email":
+		return emailPattern.MatchString(value)
+	case "uri":
+		parsed, err := url.Parse(value)
+		return err == nil && parsed.Scheme != ""
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	switch aValue := a.(type) {
+	case map[string]interface{}:
+		bValue, isObject := b.(map[string]interface{})
+		if !isObject || len(aValue) != len(bValue) {
+			return false
+		}
+		for key, item := range aValue {
+			if other, exists := bValue[key]; !exists || !equal(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bValue, isArray := b.([]interface{})
+		if !isArray || len(aValue) != len(bValue) {
+			return false
+		}
+		for i := range aValue {
+			if !equal(aValue[i], bValue[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return query.Equals(a, b)
+}
+
+// normalize converts the document to the types it would have if decoded from JSON,
+// ex: ints to float64 and time.Time to string, so that documents built in Go validate too.
+func normalize(document interface{}) interface{} {
+	switch document.(type) {
+	case nil, bool, string, float64:
+		return document
+	}
+	if object, isObject := document.(map[string]interface{}); isObject {
+		normalized := make(map[string]interface{}, len(object))
+		for key, value := range object {
+			normalized[key] = normalize(value)
+		}
+		return normalized
+	}
+	if list, isList := document.([]interface{}); isList {
+		normalized := make([]interface{}, len(list))
+		for i, value := range list {
+			normalized[i] = normalize(value)
+		}
+		return normalized
+	}
+
+	var normalized interface{}
+	if data, err := json.Marshal(document); err == nil {
+		json.Unmarshal(data, &normalized)
+	}
+	return normalized
+}
+
+func number(value interface{}) (float64, bool) {
+	n, isNumber := value.(float64)
+	return n, isNumber
+}
+
+func format(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func encode(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func escape(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,154 @@
+package search
+
+import (
+	"sort"
+	"sync"
+	"math"
+)
+
+// Parameters of BM25: K1 saturates the frequency of the terms and B normalizes
+// the length of the documents.
+var (
+	K1 = 1.2
+	B  = 0.75
+)
+
+// Hit is a document matching a search with its relevance.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Index is an inverted index of the documents of a collection. It is safe for concurrent use.
+type Index struct {
+	fields []string
+
+	mutex     sync.RWMutex
+	postings  map[string]map[string]int // term -> id -> frequency
+	documents map[string]map[string]int // id -> term -> frequency
+	lengths   map[string]int            // id -> number of terms
+	total     int
+}
+
+// NewIndex returns an index of the given fields. Every string field is indexed if no fields
+// are given. Fields of embedded objects are separated with dots, ex: address.city
+func NewIndex(fields ...string) *Index {
+	return &Index{
+		fields:    fields,
+		postings:  make(map[string]map[string]int),
+		documents: make(map[string]map[string]int),
+		lengths:   make(map[string]int),
+	}
+}
+
+// Len returns the number of the documents in the index.
+func (i *Index) Len() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return len(i.documents)
+}
+
+// Add indexes the document by its id, replacing the document indexed with the same id.
+func (i *Index) Add(id string, document map[string]interface{}) {
+
+	frequencies := make(map[string]int)
+	length := 0
+	for _, values := range texts(document, i.fields) {
+		for _, value := range values {
+			for _, term := range Analyze(value) {
+				frequencies[term]++
+				length++
+			}
+		}
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(id)
+	for term, frequency := range frequencies {
+		if i.postings[term] == nil {
+			i.postings[term] = make(map[string]int)
+		}
+		i.postings[term][id] = frequency
+	}
+	i.documents[id] = frequencies
+	i.lengths[id] = length
+	i.total += length
+}
+
+// Remove removes the document from the index.
+func (i *Index) Remove(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.remove(id)
+}
+
+func (i *Index) remove(id string) {
+	for term := range i.documents[id] {
+		delete(i.postings[term], id)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+	i.total -= i.lengths[id]
+	delete(i.documents, id)
+	delete(i.lengths, id)
+}
+
+// Search returns the documents containing any of the terms of the text, the most
+// relevant first. Documents with the same score are ordered by their ids.
+func (i *Index) Search(text string) (hits []Hit) {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	hits = make([]Hit, 0)
+	if len(i.documents) == 0 {
+		return
+	}
+
+	count := float64(len(i.documents))
+	averageLength := float64(i.total) / count
+	scores := make(map[string]float64)
+	for term := range terms(text) {
+		postings := i.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (count-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for id, frequency := range postings {
+			f := float64(frequency)
+			norm := 1 - B
+			if averageLength > 0 {
+				norm += B * float64(i.lengths[id]) / averageLength
+			}
+			scores[id] += idf * f * (K1 + 1) / (f + K1*norm)
+		}
+	}
+
+	for id, score := range scores {
+		hits = append(hits, Hit{id, score})
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].ID < hits[b].ID
+	})
+	return
+}
+
+// Highlight returns the fragments of the indexed fields of the document which contain
+// the terms of the text by the fields, with the matching words marked.
+func (i *Index) Highlight(document map[string]interface{}, text string) map[string][]string {
+	return highlight(document, i.fields, terms(text))
+}
+
+func terms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, term := range Analyze(text) {
+		terms[term] = true
+	}
+	return terms
+}
@@ -0,0 +1,207 @@
+package search
+
+import (
+	"sync"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Provider adds full-text search to the provider it wraps. The index of a collection is built
+// from its documents on the first search and kept up to date with the writes made through the
+// Provider. Changes made by other processes are not seen until Rebuild is called.
+type Provider struct {
+	dataprovider.Delegate
+	indexes *indexes
+}
+
+type indexes struct {
+	fields map[string][]string
+
+	mutex sync.Mutex
+	built map[string]*Index
+}
+
+// New returns a provider which searches the given collections by the fields to index. Every
+// string field of a collection is indexed if no fields are given for it. Searching the other
+// collections fails. Use NewTransactional to keep the transactions of the provider.
+func New(provider dataprovider.Provider, collections map[string][]string) *Provider {
+	return &Provider{
+		Delegate: dataprovider.Delegate{Provider: provider},
+		indexes:  &indexes{fields: collections, built: make(map[string]*Index)},
+	}
+}
+
+// Rebuild drops the indexes of the collections, so that they are built again on the next search.
+func (p *Provider) Rebuild(collections ...string) {
+	p.indexes.mutex.Lock()
+	defer p.indexes.mutex.Unlock()
+	for _, collection := range collections {
+		delete(p.indexes.built, collection)
+	}
+}
+
+// BindContext returns the provider passing the context to the provider it wraps.
+func (p *Provider) BindContext(ctx context.Context) dataprovider.Provider {
+	return &Provider{Delegate: p.Delegate.Bind(ctx), indexes: p.indexes}
+}
+
+func (p *Provider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if response, err = p.Provider.Create(collection, data); err == nil {
+		id, _ := response[dataprovider.IdField].(string)
+		p.refresh(collection, id)
+	}
+	return
+}
+
+func (p *Provider) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return p.search(p.Provider, collection, text, q)
+}
+
+func (p *Provider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if response, err = p.Provider.Update(collection, id, data); err == nil {
+		p.refresh(collection, id)
+	}
+	return
+}
+
+func (p *Provider) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if response, err = dataprovider.Modify(p.Provider, collection, id, modify); err == nil {
+		p.refresh(collection, id)
+	}
+	return
+}
+
+func (p *Provider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if response, err = p.Provider.Delete(collection, id); err == nil {
+		p.refresh(collection, id)
+	}
+	return
+}
+
+func (p *Provider) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if response, err = dataprovider.DeleteIf(p.Provider, collection, id, check); err == nil {
+		p.refresh(collection, id)
+	}
+	return
+}
+
+// search finds the documents in the index and fetches them from the given provider, which
+// applies the where condition of the query.
+func (p *Provider) search(provider dataprovider.Provider, collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+
+	index, err := p.index(collection)
+	if err != nil {
+		return
+	}
+
+	hits := index.Search(text)
+	results := make([]map[string]interface{}, 0, len(hits))
+	if len(hits) > 0 {
+		ids := make([]interface{}, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.ID
+		}
+
+		matching := query.New()
+		matching.Where = query.Comparison{Field: dataprovider.IdField, Operator: query.In, Value: ids}
+		if q.Where != nil {
+			matching.Where = query.And{q.Where, matching.Where}
+		}
+
+		var fetched map[string]interface{}
+		if fetched, err = dataprovider.QueryStructured(provider, collection, matching); err != nil {
+			return
+		}
+		documents, _ := dataprovider.Results(fetched)
+		byId := make(map[string]map[string]interface{}, len(documents))
+		for _, document := range documents {
+			id, _ := document[dataprovider.IdField].(string)
+			byId[id] = document
+		}
+		for _, hit := range hits {
+			if document, found := byId[hit.ID]; found {
+				results = append(results, document)
+			}
+		}
+	}
+
+	// the documents may be shared with the provider, so the fields are added to copies
+	scores := make(map[string]float64, len(hits))
+	for _, hit := range hits {
+		scores[hit.ID] = hit.Score
+	}
+	results = query.Query{Sort: q.Sort, Limit: q.Limit, Skip: q.Skip}.Apply(results)
+	for i, document := range results {
+		fields := q.Fields
+		if len(fields) == 0 {
+			fields = make([]string, 0, len(document))
+			for field := range document {
+				fields = append(fields, field)
+			}
+		}
+		id, _ := document[dataprovider.IdField].(string)
+		results[i] = query.Project(document, fields)
+		results[i][dataprovider.ScoreField] = scores[id]
+		results[i][dataprovider.HighlightsField] = index.Highlight(document, text)
+	}
+
+	response = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+// index returns the index of the collection, building it if it is not built yet.
+func (p *Provider) index(collection string) (index *Index, err *utils.Error) {
+
+	fields, isSearchable := p.indexes.fields[collection]
+	if !isSearchable {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Collection '" + collection + "' is not searchable."}
+		return
+	}
+
+	p.indexes.mutex.Lock()
+	defer p.indexes.mutex.Unlock()
+
+	if index = p.indexes.built[collection]; index != nil {
+		return
+	}
+
+	response, err := dataprovider.QueryStructured(p.Provider, collection, query.New())
+	if err != nil {
+		return
+	}
+	documents, _ := dataprovider.Results(response)
+
+	index = NewIndex(fields...)
+	for _, document := range documents {
+		id, _ := document[dataprovider.IdField].(string)
+		index.Add(id, document)
+	}
+	p.indexes.built[collection] = index
+	return
+}
+
+// refresh indexes the document again after it is written, if the index of its collection is
+// built. The index is dropped if the document cannot be read. The lock is held while reading,
+// so that the index is not built without the write and concurrent writes are applied in order.
+func (p *Provider) refresh(collection string, id string) {
+
+	p.indexes.mutex.Lock()
+	defer p.indexes.mutex.Unlock()
+
+	index := p.indexes.built[collection]
+	if index == nil {
+		return
+	}
+
+	document, err := p.Provider.Get(collection, id)
+	if err == nil {
+		index.Add(id, document)
+	} else if err.Code == http.StatusNotFound {
+		index.Remove(id)
+	} else {
+		delete(p.indexes.built, collection)
+	}
+}
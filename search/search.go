@@ -0,0 +1,195 @@
+// Package search implements full-text search for the data providers which don't have a native one.
+// Texts are split into lower case words, English stop words are dropped and the rest is reduced
+// to stems, so that a search for "connecting" finds "connections". Matching documents are ranked
+// with BM25.
+//
+// Provider wraps a data provider with in-memory indexes of the searchable collections and
+// implements dataprovider.Searcher:
+//
+//   core.DataProvider = search.New(provider, map[string][]string{
+//       "posts": {"title", "body"}, // only these fields are indexed
+//       "users": nil,               // every string field is indexed
+//   })
+//
+// Collections are searched with the search parameter, ex: /posts?search=go+generics
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"html"
+	"github.com/rihtim/core/query"
+)
+
+// Parameter is the parameter of the text to search in a collection.
+const Parameter = "search"
+
+// Marks around the matching words in the highlights.
+var (
+	HighlightPre  = "<em>"
+	HighlightPost = "</em>"
+)
+
+// FragmentSize is the approximate length of the highlighted fragments of long texts in bytes.
+var FragmentSize = 120
+
+// fragmentContext is the number of words shown before the first match of a fragment.
+const fragmentContext = 4
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true, "this": true, "to": true,
+	"was": true, "will": true, "with": true,
+}
+
+// token is a word of a text with its position in the text.
+type token struct {
+	word  string
+	start int
+	end   int
+}
+
+// tokenize splits the text into lower case words of letters and digits.
+func tokenize(text string) (tokens []token) {
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return
+}
+
+// term returns the indexed term of the word, or an empty string for stop words.
+func term(word string) string {
+	if stopWords[word] {
+		return ""
+	}
+	return Stem(word)
+}
+
+// Analyze returns the terms of the text in their order, the way they are indexed and searched.
+func Analyze(text string) (terms []string) {
+	for _, token := range tokenize(text) {
+		if term := term(token.word); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return
+}
+
+// texts returns the strings of the fields of the document by their paths. If no fields are
+// given, every string field is returned except the ones starting with an underscore, which
+// are the fields set by the providers.
+func texts(document map[string]interface{}, fields []string) map[string][]string {
+	texts := make(map[string][]string)
+	if len(fields) == 0 {
+		for name, value := range document {
+			if !strings.HasPrefix(name, "_") {
+				collect(texts, name, value)
+			}
+		}
+		return texts
+	}
+	for _, field := range fields {
+		if value, exists := query.Lookup(document, field); exists {
+			collect(texts, field, value)
+		}
+	}
+	return texts
+}
+
+func collect(texts map[string][]string, path string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		texts[path] = append(texts[path], v)
+	case []interface{}:
+		for _, item := range v {
+			collect(texts, path, item)
+		}
+	case []string:
+		texts[path] = append(texts[path], v...)
+	case map[string]interface{}:
+		for name, item := range v {
+			collect(texts, path+"."+name, item)
+		}
+	}
+}
+
+// highlight returns the fragments of the texts of the fields which contain the terms,
+// with the matching words between HighlightPre and HighlightPost.
+func highlight(document map[string]interface{}, fields []string, terms map[string]bool) map[string][]string {
+	highlights := make(map[string][]string)
+	for field, values := range texts(document, fields) {
+		for _, value := range values {
+			if fragment, matches := fragment(value, terms); matches {
+				highlights[field] = append(highlights[field], fragment)
+			}
+		}
+	}
+	for _, fragments := range highlights {
+		sort.Strings(fragments)
+	}
+	return highlights
+}
+
+// fragment highlights the matching words of the text. Long texts are cut around the first
+// match to about FragmentSize bytes. The text is HTML escaped, so that only the marks are markup.
+func fragment(text string, terms map[string]bool) (fragment string, matches bool) {
+
+	tokens := tokenize(text)
+	first := -1
+	matching := make([]bool, len(tokens))
+	for i, token := range tokens {
+		if matching[i] = terms[term(token.word)]; matching[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	start, end := 0, len(text)
+	if len(text) > FragmentSize {
+		if first > fragmentContext {
+			start = tokens[first-fragmentContext].start
+		}
+		for _, token := range tokens[first:] {
+			if token.end >= start+FragmentSize {
+				end = token.end
+				break
+			}
+		}
+	}
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+	position := start
+	for i, token := range tokens {
+		if !matching[i] || token.start < start || token.end > end {
+			continue
+		}
+		builder.WriteString(html.EscapeString(text[position:token.start]))
+		builder.WriteString(HighlightPre)
+		builder.WriteString(html.EscapeString(text[token.start:token.end]))
+		builder.WriteString(HighlightPost)
+		position = token.end
+	}
+	builder.WriteString(html.EscapeString(text[position:end]))
+	if end < len(text) {
+		builder.WriteString("…")
+	}
+	return builder.String(), true
+}
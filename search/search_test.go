@@ -0,0 +1,214 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"context"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnalysis(t *testing.T) {
+
+	Convey("Stem should reduce English words to their stems", t, func() {
+		stems := map[string]string{
+			"caresses": "caress", "ponies": "poni", "cats": "cat", "feed": "feed", "agreed": "agre",
+			"plastered": "plaster", "motoring": "motor", "sing": "sing", "conflated": "conflat",
+			"hopping": "hop", "falling": "fall", "filing": "file", "happy": "happi",
+			"relational": "relat", "generalization": "gener", "running": "run", "hopeful": "hope",
+			"connection": "connect", "connections": "connect", "connected": "connect", "connecting": "connect",
+			"adjustment": "adjust", "probate": "probat", "controlling": "control", "go": "go",
+		}
+		for word, stem := range stems {
+			So(Stem(word), ShouldEqual, stem)
+		}
+	})
+
+	Convey("Words with other characters should not be stemmed", t, func() {
+		So(Stem("running2"), ShouldEqual, "running2")
+		So(Stem("çalışmalar"), ShouldEqual, "çalışmalar")
+	})
+
+	Convey("Analyze should lower case, drop stop words and stem", t, func() {
+		So(Analyze("The Connections of a Network, 2020!"), ShouldResemble, []string{"connect", "network", "2020"})
+		So(Analyze("to be or not to be"), ShouldBeEmpty)
+	})
+}
+
+func TestIndex(t *testing.T) {
+
+	Convey("Given an index of documents", t, func() {
+		index := NewIndex("title", "body")
+		index.Add("1", map[string]interface{}{"title": "Go generics", "body": "Generics arrived in Go."})
+		index.Add("2", map[string]interface{}{"title": "Rust traits", "body": "Traits are like interfaces in Go."})
+		index.Add("3", map[string]interface{}{"title": "Cooking", "body": "Pasta and sauce.", "tags": "go"})
+
+		Convey("Search should rank the documents containing the terms more often higher", func() {
+			hits := index.Search("go generic")
+			So(len(hits), ShouldEqual, 2)
+			So(hits[0].ID, ShouldEqual, "1")
+			So(hits[1].ID, ShouldEqual, "2")
+			So(hits[0].Score, ShouldBeGreaterThan, hits[1].Score)
+		})
+
+		Convey("Rarer terms should weigh more", func() {
+			hits := index.Search("go traits")
+			So(hits[0].ID, ShouldEqual, "2")
+		})
+
+		Convey("Only the given fields should be indexed", func() {
+			So(index.Search("pasta"), ShouldHaveLength, 1)
+			So(index.Search("go"), ShouldHaveLength, 2)
+		})
+
+		Convey("Adding a document with the same id should replace it", func() {
+			index.Add("3", map[string]interface{}{"title": "Go cooking"})
+			So(index.Search("pasta"), ShouldBeEmpty)
+			So(index.Search("go"), ShouldHaveLength, 3)
+			So(index.Len(), ShouldEqual, 3)
+		})
+
+		Convey("Removed documents should not be found", func() {
+			index.Remove("1")
+			So(index.Search("generics"), ShouldBeEmpty)
+			So(index.Len(), ShouldEqual, 2)
+		})
+
+		Convey("Texts without terms should find nothing", func() {
+			So(index.Search("the"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an index of every string field", t, func() {
+		index := NewIndex()
+		index.Add("1", map[string]interface{}{"_id": "1", "name": "alice", "address": map[string]interface{}{"city": "istanbul"}, "tags": []interface{}{"admin", 3.0}})
+
+		Convey("Strings of embedded objects and lists should be indexed", func() {
+			So(index.Search("istanbul"), ShouldHaveLength, 1)
+			So(index.Search("admin"), ShouldHaveLength, 1)
+		})
+
+		Convey("Fields of the providers should not be indexed", func() {
+			So(index.Search("1"), ShouldBeEmpty)
+		})
+
+		Convey("Highlight should mark the matching words by the field paths", func() {
+			So(index.Highlight(map[string]interface{}{"address": map[string]interface{}{"city": "Istanbul"}}, "istanbul"), ShouldResemble,
+				map[string][]string{"address.city": {"<em>Istanbul</em>"}})
+		})
+	})
+
+	Convey("Highlights should escape the text and cut long texts around the first match", t, func() {
+		index := NewIndex("body")
+		body := strings.Repeat("filler words here ", 20) + "the <connected> graph " + strings.Repeat("more words ", 20)
+		highlights := index.Highlight(map[string]interface{}{"body": body}, "connections")
+
+		fragment := highlights["body"][0]
+		So(fragment, ShouldStartWith, "…")
+		So(fragment, ShouldEndWith, "…")
+		So(fragment, ShouldContainSubstring, "the &lt;<em>connected</em>&gt; graph")
+		So(len(fragment), ShouldBeLessThan, FragmentSize+40)
+	})
+}
+
+func TestProvider(t *testing.T) {
+
+	Convey("Given a search provider", t, func() {
+		provider := New(&memory.Provider{}, map[string][]string{"posts": {"title", "body"}})
+		create := func(data map[string]interface{}) string {
+			response, err := provider.Create("posts", data)
+			So(err, ShouldBeNil)
+			return response[dataprovider.IdField].(string)
+		}
+		search := func(text string, q query.Query) []map[string]interface{} {
+			response, err := dataprovider.Search(provider, "posts", text, q)
+			So(err, ShouldBeNil)
+			results, _ := dataprovider.Results(response)
+			return results
+		}
+
+		first := create(map[string]interface{}{"title": "Searching documents", "body": "Search ranks documents.", "year": 2020.0})
+		second := create(map[string]interface{}{"title": "Ranking", "body": "Documents are ranked with BM25.", "year": 2021.0})
+
+		Convey("Search should return the matching documents with scores and highlights", func() {
+			results := search("ranking", query.New())
+			So(results, ShouldHaveLength, 2)
+			So(results[0][dataprovider.IdField], ShouldEqual, second)
+			So(results[0][dataprovider.ScoreField], ShouldBeGreaterThan, results[1][dataprovider.ScoreField])
+			So(results[0][dataprovider.HighlightsField], ShouldResemble, map[string][]string{
+				"title": {"<em>Ranking</em>"},
+				"body":  {"Documents are <em>ranked</em> with BM25."},
+			})
+		})
+
+		Convey("The where condition, sort, skip, limit and fields of the query should be applied", func() {
+			q := query.New()
+			q.Where = query.Comparison{Field: "year", Operator: query.LessThan, Value: 2021.0}
+			results := search("documents", q)
+			So(results, ShouldHaveLength, 1)
+			So(results[0][dataprovider.IdField], ShouldEqual, first)
+
+			q = query.New()
+			q.Sort = []query.SortField{{Field: "year", Descending: true}}
+			q.Limit = 1
+			q.Fields = []string{"title"}
+			results = search("documents", q)
+			So(results, ShouldHaveLength, 1)
+			So(results[0]["title"], ShouldEqual, "Ranking")
+			So(results[0], ShouldNotContainKey, "body")
+			So(results[0], ShouldContainKey, dataprovider.ScoreField)
+		})
+
+		Convey("Writes through the provider should update the index", func() {
+			search("documents", query.New())
+
+			third := create(map[string]interface{}{"title": "Indexing"})
+			So(search("index", query.New()), ShouldHaveLength, 1)
+
+			provider.Update("posts", third, map[string]interface{}{"title": "Stemming"})
+			So(search("index", query.New()), ShouldBeEmpty)
+			So(search("stems", query.New()), ShouldHaveLength, 1)
+
+			provider.Delete("posts", first)
+			results := search("documents", query.New())
+			So(results, ShouldHaveLength, 1)
+			So(results[0][dataprovider.IdField], ShouldEqual, second)
+		})
+
+		Convey("Searching a collection which is not searchable should fail", func() {
+			_, err := provider.Search("users", "alice", query.New())
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("Given a search provider of a transactional provider", t, func() {
+		provider := NewTransactional(&memory.Provider{}, map[string][]string{"posts": nil})
+		provider.Create("posts", map[string]interface{}{"title": "Committed"})
+		provider.Search("posts", "committed", query.New())
+
+		Convey("Documents written in a transaction should be indexed when it is committed", func() {
+			transaction, err := provider.Begin(context.Background())
+			So(err, ShouldBeNil)
+			transaction.Create("posts", map[string]interface{}{"title": "Pending"})
+
+			response, _ := provider.Search("posts", "pending", query.New())
+			results, _ := dataprovider.Results(response)
+			So(results, ShouldBeEmpty)
+
+			So(transaction.Commit(), ShouldBeNil)
+			response, _ = provider.Search("posts", "pending", query.New())
+			results, _ = dataprovider.Results(response)
+			So(results, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Providers which don't implement Searcher should return not implemented", t, func() {
+		_, err := dataprovider.Search(&memory.Provider{}, "posts", "text", query.New())
+		So(err, ShouldNotBeNil)
+		So(err.Code, ShouldEqual, http.StatusNotImplemented)
+	})
+}
@@ -0,0 +1,244 @@
+package search
+
+// Stem reduces the English word to its stem with the Porter stemming algorithm, ex: connections,
+// connected and connecting become connect. The word must be in lower case; words with characters
+// other than a-z are returned as they are.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &stemmer{b: []byte(word), k: len(word) - 1}
+	s.step1ab()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+	return string(s.b[:s.k+1])
+}
+
+// stemmer keeps the word being stemmed in b[0:k+1]. j is the end of the stem
+// which is left when the suffix matched by ends is removed.
+type stemmer struct {
+	b []byte
+	k int
+	j int
+}
+
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[0:j+1]. For [C](VC)^m[V], where
+// C and V are sequences of consonants and vowels, it is m, ex: tree 0, trouble 1, private 2.
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for ; i <= s.j && s.cons(i); i++ {
+	}
+	for {
+		for ; i <= s.j && !s.cons(i); i++ {
+		}
+		if i > s.j {
+			return n
+		}
+		n++
+		for ; i <= s.j && s.cons(i); i++ {
+		}
+		if i > s.j {
+			return n
+		}
+	}
+}
+
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stemmer) doubleConsonant(i int) bool {
+	return i >= 1 && s.b[i] == s.b[i-1] && s.cons(i)
+}
+
+// cvc tells whether b[i-2:i+1] is consonant-vowel-consonant and the last consonant
+// is not w, x or y, ex: hop, but not snow or box.
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	return s.b[i] != 'w' && s.b[i] != 'x' && s.b[i] != 'y'
+}
+
+// ends tells whether the word ends with the suffix and sets j to the end of the stem if so.
+func (s *stemmer) ends(suffix string) bool {
+	length := len(suffix)
+	if length > s.k+1 || string(s.b[s.k-length+1:s.k+1]) != suffix {
+		return false
+	}
+	s.j = s.k - length
+	return true
+}
+
+// setTo replaces the suffix after j with the given one.
+func (s *stemmer) setTo(suffix string) {
+	s.b = append(s.b[:s.j+1], suffix...)
+	s.k = s.j + len(suffix)
+}
+
+// replace replaces the first suffix of the rules the word ends with, if the stem has a measure
+// greater than zero. Rules are pairs of suffixes and their replacements.
+func (s *stemmer) replace(rules ...string) {
+	for i := 0; i < len(rules); i += 2 {
+		if s.ends(rules[i]) {
+			if s.m() > 0 {
+				s.setTo(rules[i+1])
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing, ex: caresses -> caress, ponies -> poni,
+// agreed -> agree, hopping -> hop, filing -> file.
+func (s *stemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setTo("i")
+		} else if s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+		if s.ends("at") {
+			s.setTo("ate")
+		} else if s.ends("bl") {
+			s.setTo("ble")
+		} else if s.ends("iz") {
+			s.setTo("ize")
+		} else if s.doubleConsonant(s.k) {
+			if c := s.b[s.k]; c != 'l' && c != 's' && c != 'z' {
+				s.k--
+			}
+		} else if s.m() == 1 && s.cvc(s.k) {
+			s.setTo("e")
+		}
+	}
+}
+
+// step1c turns a terminal y to i when there is another vowel in the stem, ex: happy -> happi.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, ex: relational -> relate, digitizer -> digitize.
+func (s *stemmer) step2() {
+	if s.k < 1 {
+		return
+	}
+	switch s.b[s.k-1] {
+	case 'a':
+		s.replace("ational", "ate", "tional", "tion")
+	case 'c':
+		s.replace("enci", "ence", "anci", "ance")
+	case 'e':
+		s.replace("izer", "ize")
+	case 'l':
+		s.replace("bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous")
+	case 'o':
+		s.replace("ization", "ize", "ation", "ate", "ator", "ate")
+	case 's':
+		s.replace("alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous")
+	case 't':
+		s.replace("aliti", "al", "iviti", "ive", "biliti", "ble")
+	case 'g':
+		s.replace("logi", "log")
+	}
+}
+
+// step3 handles -ic-, -full, -ness etc., ex: triplicate -> triplic, hopeful -> hope.
+func (s *stemmer) step3() {
+	switch s.b[s.k] {
+	case 'e':
+		s.replace("icate", "ic", "ative", "", "alize", "al")
+	case 'i':
+		s.replace("iciti", "ic")
+	case 'l':
+		s.replace("ical", "ic", "ful", "")
+	case 's':
+		s.replace("ness", "")
+	}
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step4 removes the suffixes of stems with a measure greater than one, ex: revival -> reviv,
+// adjustment -> adjust.
+func (s *stemmer) step4() {
+	if s.k < 1 {
+		return
+	}
+	for _, suffix := range step4Suffixes[s.b[s.k-1]] {
+		if !s.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (s.j < 0 || (s.b[s.j] != 's' && s.b[s.j] != 't')) {
+			continue
+		}
+		if s.m() > 1 {
+			s.k = s.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and reduces -ll to -l on stems with a measure greater
+// than one, ex: probate -> probat, controll -> control.
+func (s *stemmer) step5() {
+	s.j = s.k
+	if s.b[s.k] == 'e' {
+		if m := s.m(); m > 1 || (m == 1 && !s.cvc(s.k-1)) {
+			s.k--
+		}
+	}
+	if s.b[s.k] == 'l' && s.doubleConsonant(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
@@ -0,0 +1,118 @@
+package search
+
+import (
+	"sync"
+	"context"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// TransactionalProvider adds full-text search to a transactional provider. Searches in a transaction
+// use the indexes of the committed documents and fetch the documents from the transaction, so its
+// changes apply to the where condition but not to the text. The documents written in a transaction
+// are indexed again when it is committed.
+type TransactionalProvider struct {
+	*Provider
+	transactional dataprovider.TransactionalProvider
+}
+
+// NewTransactional returns a provider which searches the collections of the transactional provider like New.
+func NewTransactional(provider dataprovider.TransactionalProvider, collections map[string][]string) *TransactionalProvider {
+	return &TransactionalProvider{New(provider, collections), provider}
+}
+
+func (p *TransactionalProvider) Begin(ctx context.Context) (transaction dataprovider.Transaction, err *utils.Error) {
+	if transaction, err = p.transactional.Begin(ctx); err != nil {
+		return
+	}
+	transaction = &searchTransaction{
+		Delegate:    dataprovider.Delegate{Provider: transaction},
+		transaction: transaction,
+		searcher:    p.Provider,
+		written:     make(map[written]bool),
+	}
+	return
+}
+
+type written struct {
+	collection string
+	id         string
+}
+
+type searchTransaction struct {
+	dataprovider.Delegate
+	transaction dataprovider.Transaction
+	searcher    *Provider
+
+	mutex   sync.Mutex
+	written map[written]bool
+}
+
+func (t *searchTransaction) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if response, err = t.transaction.Create(collection, data); err == nil {
+		id, _ := response[dataprovider.IdField].(string)
+		t.write(collection, id)
+	}
+	return
+}
+
+func (t *searchTransaction) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	return t.searcher.search(t.transaction, collection, text, q)
+}
+
+func (t *searchTransaction) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if response, err = t.transaction.Update(collection, id, data); err == nil {
+		t.write(collection, id)
+	}
+	return
+}
+
+func (t *searchTransaction) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if response, err = dataprovider.Modify(t.transaction, collection, id, modify); err == nil {
+		t.write(collection, id)
+	}
+	return
+}
+
+func (t *searchTransaction) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if response, err = t.transaction.Delete(collection, id); err == nil {
+		t.write(collection, id)
+	}
+	return
+}
+
+func (t *searchTransaction) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if response, err = dataprovider.DeleteIf(t.transaction, collection, id, check); err == nil {
+		t.write(collection, id)
+	}
+	return
+}
+
+// Commit commits the transaction and indexes the documents it wrote to again. They are
+// indexed even if committing fails, since the changes may be partially applied.
+func (t *searchTransaction) Commit() (err *utils.Error) {
+	defer t.refresh()
+	return t.transaction.Commit()
+}
+
+func (t *searchTransaction) Rollback() (err *utils.Error) {
+	return t.transaction.Rollback()
+}
+
+func (t *searchTransaction) write(collection string, id string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.written[written{collection, id}] = true
+}
+
+func (t *searchTransaction) refresh() {
+	t.mutex.Lock()
+	documents := t.written
+	t.written = make(map[written]bool)
+	t.mutex.Unlock()
+
+	for document := range documents {
+		t.searcher.refresh(document.collection, document.id)
+	}
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"sync"
+	"context"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/changes"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/websocket"
+	"github.com/rihtim/core/requestscope"
+)
+
+// WebSocketMaxRequests is the number of the requests of a socket which are handled concurrently.
+// The frames which follow are read once one of the requests is answered.
+var WebSocketMaxRequests = 16
+
+// WebSocketCheckOrigin tells whether a socket can be opened from the origin of the request.
+// If nil, only the pages of the same host can open sockets.
+var WebSocketCheckOrigin func(r *http.Request) bool
+
+// socketResponse is the frame sent for a request, correlated with it by the rid.
+type socketResponse struct {
+	Rid     int                    `json:"rid"`
+	Status  int                    `json:"status"`
+	Headers map[string][]string    `json:"headers,omitempty"`
+	Body    map[string]interface{} `json:"body,omitempty"`
+}
+
+// socketEvent is the frame sent for a change, with the rid of the subscribe request.
+type socketEvent struct {
+	Rid   int           `json:"rid"`
+	Event changes.Event `json:"event"`
+}
+
+// HandleWebSocket upgrades the request to a WebSocket and handles the Message frames sent
+// over it like HandleRequest does, concurrently. Responses are sent with the rid of their
+// requests, up to WebSocketMaxRequests at a time. The headers of the upgrade request, ex:
+// Authorization, are used for the frames which don't have them.
+//
+// The subscribe command reads the resource like GET and sends the changes of the objects it
+// reads afterwards, as {"rid": ..., "event": {"type": "created", ...}} frames with the rid of
+// the subscribe request. The documents of the events go through the fields parameter and the
+// AFTER_EXEC interceptors of the subscribe request like its response does. The unsubscribe
+// command ends the subscription whose rid is given in the body, ex: {"rid": 2, "method":
+// "unsubscribe", "body": {"rid": 1}}. A subscription which falls behind the changes is ended
+// with a 410 Gone response.
+func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+
+	conn, err := websocket.Upgrade(w, r, WebSocketCheckOrigin)
+	if err != nil {
+		printError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &socket{
+		conn:          conn,
+		header:        r.Header,
+		requests:      make(chan struct{}, WebSocketMaxRequests),
+		subscriptions: make(map[int]*changes.Subscription),
+	}
+	s.ip, _ = utils.GetClientIPHelper(r)
+
+	defer func() {
+		cancel()
+		s.closeSubscriptions()
+		conn.Close()
+	}()
+
+	for {
+		_, data, readErr := conn.ReadMessage()
+		if readErr != nil {
+			return
+		}
+
+		var request messages.Message
+		if decodeErr := json.Unmarshal(data, &request); decodeErr != nil {
+			s.send(socketResponse{Status: http.StatusBadRequest, Body: errorBody(&utils.Error{Code: http.StatusBadRequest, Message: "Parsing message failed. Reason: " + decodeErr.Error()})})
+			continue
+		}
+		s.requests <- struct{}{}
+		go s.handle(ctx, request)
+	}
+}
+
+type socket struct {
+	conn   *websocket.Conn
+	ip     string
+	header http.Header
+
+	// requests holds a slot for every request being handled
+	requests chan struct{}
+
+	mutex         sync.Mutex
+	subscriptions map[int]*changes.Subscription
+}
+
+// handle handles the request and frees its slot once it is answered. The events of a
+// subscription are forwarded afterwards.
+func (s *socket) handle(ctx context.Context, request messages.Message) {
+
+	request.IP = s.ip
+	for key, values := range s.header {
+		if _, exists := request.Headers[key]; !exists {
+			if request.Headers == nil {
+				request.Headers = make(map[string][]string)
+			}
+			request.Headers[key] = values
+		}
+	}
+
+	var response messages.Message
+	var subscription *changes.Subscription
+	var filter eventFilter
+	var err *utils.Error
+
+	if strings.EqualFold(request.Command, methods.Subscribe) {
+		if response, subscription, filter, err = subscribe(ctx, request, requestscope.Init()); err == nil && subscription != nil {
+			if err = s.addSubscription(request.Rid, subscription); err != nil {
+				subscription = nil
+			}
+		}
+	} else if strings.EqualFold(request.Command, methods.Unsubscribe) {
+		err = s.unsubscribe(request)
+	} else {
+		response, _, err = HandleRequestContext(ctx, request, requestscope.Init())
+		if response.File != nil {
+			response.File.Close()
+			response.Body = nil
+			err = &utils.Error{Code: http.StatusNotAcceptable, Message: "Files cannot be downloaded over sockets."}
+		}
+	}
+
+	s.sendResponse(request.Rid, response, err)
+	<-s.requests
+
+	if subscription != nil {
+		s.forward(request.Rid, subscription, filter)
+	}
+}
+
+// forward sends the events of the subscription which pass the filter until it is closed.
+func (s *socket) forward(rid int, subscription *changes.Subscription, filter eventFilter) {
+
+	for event := range subscription.Events() {
+		if event, send := filter(event); send {
+			s.send(socketEvent{Rid: rid, Event: event})
+		}
+	}
+
+	// the subscription is still registered if the feed closed it
+	s.mutex.Lock()
+	current, exists := s.subscriptions[rid]
+	if exists && current == subscription {
+		delete(s.subscriptions, rid)
+	}
+	s.mutex.Unlock()
+
+	if exists && current == subscription {
+		s.sendResponse(rid, messages.Message{}, &utils.Error{Code: http.StatusGone, Message: "Subscription fell behind the changes and is ended."})
+	}
+}
+
+func (s *socket) addSubscription(rid int, subscription *changes.Subscription) (err *utils.Error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.subscriptions[rid]; exists {
+		subscription.Close()
+		err = &utils.Error{Code: http.StatusConflict, Message: "There is a subscription with the same rid."}
+		return
+	}
+	s.subscriptions[rid] = subscription
+	return
+}
+
+func (s *socket) unsubscribe(request messages.Message) (err *utils.Error) {
+
+	rid, isNumber := request.Body["rid"].(float64)
+	if !isNumber {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Rid of the subscription is missing."}
+		return
+	}
+
+	s.mutex.Lock()
+	subscription, exists := s.subscriptions[int(rid)]
+	delete(s.subscriptions, int(rid))
+	s.mutex.Unlock()
+
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Subscription not found."}
+		return
+	}
+	subscription.Close()
+	return
+}
+
+func (s *socket) closeSubscriptions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for rid, subscription := range s.subscriptions {
+		subscription.Close()
+		delete(s.subscriptions, rid)
+	}
+}
+
+func (s *socket) sendResponse(rid int, response messages.Message, err *utils.Error) {
+
+	frame := socketResponse{Rid: rid, Status: response.Status, Headers: response.Headers, Body: response.Body}
+	if err != nil {
+		if frame.Status == 0 {
+			frame.Status = err.Code
+		}
+		if frame.Body == nil {
+			frame.Body = errorBody(err)
+		}
+	}
+	if frame.Status == 0 {
+		frame.Status = http.StatusOK
+	}
+	s.send(frame)
+}
+
+func (s *socket) send(frame interface{}) {
+	data, marshalErr := json.Marshal(frame)
+	if marshalErr != nil {
+		log.Error("Generating socket frame failed. Reason: " + marshalErr.Error())
+		return
+	}
+	s.conn.WriteMessage(websocket.TextMessage, data)
+}
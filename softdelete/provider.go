@@ -0,0 +1,219 @@
+package softdelete
+
+import (
+	"time"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// Hide returns a provider which soft deletes the documents of the collections in the registry
+// and hides the deleted documents from reads and updates. Other collections are not changed.
+func Hide(provider dataprovider.Provider, registry *Registry) dataprovider.Provider {
+	return &view{dataprovider.Delegate{Provider: provider}, registry, ""}
+}
+
+// Trash returns a provider like Hide, except that only the deleted documents of the given
+// collection are visible. Deleting a document in the trash deletes it permanently, and
+// removing its deletion time, ex: with Restore, restores it. Documents cannot be created
+// in the trash and the deletion time of a document cannot be changed.
+func Trash(provider dataprovider.Provider, registry *Registry, collection string) dataprovider.Provider {
+	return &view{dataprovider.Delegate{Provider: provider}, registry, collection}
+}
+
+// Restore restores the deleted document. The provider must be the trash view of the collection.
+func Restore(trash dataprovider.Provider, collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	return dataprovider.Modify(trash, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		delete(document, dataprovider.DeletedAtField)
+		return document, nil
+	})
+}
+
+type view struct {
+	dataprovider.Delegate
+	registry *Registry
+	trash    string
+}
+
+func (v *view) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return v.Provider.Create(collection, data)
+	}
+	if collection == v.trash {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Objects cannot be created in the trash."}
+		return
+	}
+	return v.Provider.Create(collection, withoutDeletedAt(data))
+}
+
+func (v *view) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	response, err = v.Provider.Get(collection, id)
+	if err == nil && !v.visible(collection, response) {
+		response, err = nil, notFound()
+	}
+	return
+}
+
+func (v *view) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return v.Provider.Query(collection, parameters)
+	}
+	q, err := query.Parse(parameters)
+	if err != nil {
+		return
+	}
+	return v.QueryStructured(collection, q)
+}
+
+func (v *view) QueryStructured(collection string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	q.Where = v.where(collection, q.Where)
+	return dataprovider.QueryStructured(v.Provider, collection, q)
+}
+
+func (v *view) Count(collection string, where query.Condition) (count int, err *utils.Error) {
+	return dataprovider.Count(v.Provider, collection, v.where(collection, where))
+}
+
+func (v *view) Aggregate(collection string, aggregation query.Aggregation) (response map[string]interface{}, err *utils.Error) {
+	aggregation.Where = v.where(collection, aggregation.Where)
+	return dataprovider.Aggregate(v.Provider, collection, aggregation)
+}
+
+func (v *view) Search(collection string, text string, q query.Query) (response map[string]interface{}, err *utils.Error) {
+	q.Where = v.where(collection, q.Where)
+	return dataprovider.Search(v.Provider, collection, text, q)
+}
+
+func (v *view) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return v.Provider.Update(collection, id, data)
+	}
+	if _, err = v.Get(collection, id); err != nil {
+		return
+	}
+	return v.Provider.Update(collection, id, withoutDeletedAt(data))
+}
+
+func (v *view) Modify(collection string, id string, modify dataprovider.ModifyFunc) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return dataprovider.Modify(v.Provider, collection, id, modify)
+	}
+	return dataprovider.Modify(v.Provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		if !v.visible(collection, document) {
+			return nil, notFound()
+		}
+		deletedAt := document[dataprovider.DeletedAtField]
+		modified, err := modify(document)
+		if err != nil {
+			return nil, err
+		}
+		// the deletion time can only be removed, which restores the document
+		if _, keepsDeletedAt := modified[dataprovider.DeletedAtField]; keepsDeletedAt && collection == v.trash {
+			modified[dataprovider.DeletedAtField] = deletedAt
+		} else {
+			delete(modified, dataprovider.DeletedAtField)
+		}
+		return modified, nil
+	})
+}
+
+func (v *view) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return v.Provider.Delete(collection, id)
+	}
+	if collection == v.trash {
+		if _, err = v.Get(collection, id); err != nil {
+			return
+		}
+		return v.Provider.Delete(collection, id)
+	}
+	_, err = dataprovider.Modify(v.Provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		if isDeleted(document) {
+			return nil, notFound()
+		}
+		document[dataprovider.DeletedAtField] = time.Now()
+		return document, nil
+	})
+	return
+}
+
+// DeleteIf checks the document in the same modification which moves it to the trash.
+func (v *view) DeleteIf(collection string, id string, check dataprovider.CheckFunc) (response map[string]interface{}, err *utils.Error) {
+	if !v.tracks(collection) {
+		return dataprovider.DeleteIf(v.Provider, collection, id, check)
+	}
+	if collection == v.trash {
+		return dataprovider.DeleteIf(v.Provider, collection, id, func(document map[string]interface{}) *utils.Error {
+			if !v.visible(collection, document) {
+				return notFound()
+			}
+			return check(document)
+		})
+	}
+	_, err = dataprovider.Modify(v.Provider, collection, id, func(document map[string]interface{}) (map[string]interface{}, *utils.Error) {
+		if isDeleted(document) {
+			return nil, notFound()
+		}
+		if err := check(document); err != nil {
+			return nil, err
+		}
+		document[dataprovider.DeletedAtField] = time.Now()
+		return document, nil
+	})
+	return
+}
+
+func (v *view) tracks(collection string) bool {
+	_, tracks := v.registry.Get(collection)
+	return tracks
+}
+
+// visible tells whether the document can be seen in the view: deleted documents
+// are visible only in the trash, and the others only outside of it.
+func (v *view) visible(collection string, document map[string]interface{}) bool {
+	if !v.tracks(collection) {
+		return true
+	}
+	return isDeleted(document) == (collection == v.trash)
+}
+
+func (v *view) where(collection string, where query.Condition) query.Condition {
+	if !v.tracks(collection) {
+		return where
+	}
+
+	// a nil deletion time is treated as not deleted, since it's what
+	// removing a field leaves on providers which don't implement Modifier
+	operator := query.Equal
+	if collection == v.trash {
+		operator = query.NotEqual
+	}
+	deleted := query.Comparison{Field: dataprovider.DeletedAtField, Operator: operator, Value: nil}
+
+	if where == nil {
+		return deleted
+	}
+	return query.And{deleted, where}
+}
+
+func isDeleted(document map[string]interface{}) bool {
+	return document[dataprovider.DeletedAtField] != nil
+}
+
+func withoutDeletedAt(data map[string]interface{}) map[string]interface{} {
+	if _, exists := data[dataprovider.DeletedAtField]; !exists {
+		return data
+	}
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != dataprovider.DeletedAtField {
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+func notFound() *utils.Error {
+	return &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+}
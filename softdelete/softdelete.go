@@ -0,0 +1,126 @@
+// Package softdelete keeps the deleted documents of collections in a trash instead of
+// deleting them. Deleted documents have the deletion time in the deletedAt field; they
+// are hidden from reads and can be listed, restored or purged through the trash view.
+package softdelete
+
+import (
+	"sync"
+	"time"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+const (
+	// Parameter selects the trash view, ex: GET /users?deleted=true
+	Parameter = "deleted"
+
+	// RestoreSegment is the path segment which restores a deleted object with POST,
+	// ex: POST /users/{id}/_restore. It is the same as the restore command.
+	RestoreSegment = "_restore"
+)
+
+type Policy struct {
+	Collection string
+
+	// Retention is how long deleted documents are kept before they are purged.
+	// Zero means they are kept until they are deleted from the trash.
+	Retention time.Duration
+}
+
+// Registry keeps the collections whose documents are soft deleted. It is safe for
+// concurrent use and its zero value is an empty registry.
+type Registry struct {
+	mutex    sync.RWMutex
+	policies map[string]Policy
+}
+
+// Add enables soft delete for the collection of the policy, replacing the previous policy.
+func (r *Registry) Add(policy Policy) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.policies == nil {
+		r.policies = make(map[string]Policy)
+	}
+	r.policies[policy.Collection] = policy
+}
+
+func (r *Registry) Remove(collection string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.policies, collection)
+}
+
+func (r *Registry) Get(collection string) (policy Policy, exists bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	policy, exists = r.policies[collection]
+	return
+}
+
+// Policies returns the registered policies.
+func (r *Registry) Policies() (policies []Policy) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, policy := range r.policies {
+		policies = append(policies, policy)
+	}
+	return
+}
+
+// Purge permanently deletes the documents which have been deleted longer than the retention
+// of their collection. Returns the number of purged documents.
+func Purge(provider dataprovider.Provider, registry *Registry) (purged int, err *utils.Error) {
+
+	for _, policy := range registry.Policies() {
+		if policy.Retention <= 0 {
+			continue
+		}
+
+		q := query.New()
+		q.Where = query.Comparison{Field: dataprovider.DeletedAtField, Operator: query.LessThan, Value: time.Now().Add(-policy.Retention)}
+		q.Fields = []string{dataprovider.IdField}
+
+		var response map[string]interface{}
+		if response, err = dataprovider.QueryStructured(provider, policy.Collection, q); err != nil {
+			return
+		}
+		results, _ := dataprovider.Results(response)
+		for _, result := range results {
+			id, _ := result[dataprovider.IdField].(string)
+			if _, err = provider.Delete(policy.Collection, id); err != nil {
+				return
+			}
+			purged++
+		}
+	}
+	return
+}
+
+// StartPurging purges the expired documents periodically until stop is called.
+func StartPurging(provider dataprovider.Provider, registry *Registry, interval time.Duration) (stop func()) {
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := Purge(provider, registry); err != nil {
+					log.Error("Purging deleted objects failed. Reason: " + err.Error())
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,115 @@
+package softdelete
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/dataprovider/memory"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func ids(provider dataprovider.Provider, collection string) (list []string) {
+	response, err := provider.Query(collection, nil)
+	So(err, ShouldBeNil)
+	results, _ := dataprovider.Results(response)
+	for _, result := range results {
+		list = append(list, result[dataprovider.IdField].(string))
+	}
+	return
+}
+
+func TestSoftDelete(t *testing.T) {
+
+	Convey("Given a collection with soft delete", t, func() {
+		provider := &memory.Provider{}
+		registry := &Registry{}
+		registry.Add(Policy{Collection: "users", Retention: time.Hour})
+
+		hidden := Hide(provider, registry)
+		trash := Trash(provider, registry, "users")
+
+		created, _ := hidden.Create("users", map[string]interface{}{"name": "alice"})
+		alice := created[dataprovider.IdField].(string)
+		created, _ = hidden.Create("users", map[string]interface{}{"name": "bob"})
+		bob := created[dataprovider.IdField].(string)
+
+		Convey("When an object is deleted", func() {
+			_, err := hidden.Delete("users", alice)
+			So(err, ShouldBeNil)
+
+			Convey("It should be kept with its deletion time", func() {
+				user, err := provider.Get("users", alice)
+				So(err, ShouldBeNil)
+				So(user, ShouldContainKey, dataprovider.DeletedAtField)
+			})
+
+			Convey("It should be hidden from reads, updates and deletes", func() {
+				_, err := hidden.Get("users", alice)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+				So(ids(hidden, "users"), ShouldResemble, []string{bob})
+
+				_, err = hidden.Update("users", alice, map[string]interface{}{"name": "x"})
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+
+				_, err = hidden.Delete("users", alice)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("It should be the only object in the trash", func() {
+				So(ids(trash, "users"), ShouldResemble, []string{alice})
+				_, err := trash.Get("users", bob)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("Restore should make it visible again", func() {
+				_, err := Restore(trash, "users", alice)
+				So(err, ShouldBeNil)
+				user, err := hidden.Get("users", alice)
+				So(err, ShouldBeNil)
+				So(user, ShouldNotContainKey, dataprovider.DeletedAtField)
+			})
+
+			Convey("Restore of an object which is not deleted should return not found", func() {
+				_, err := Restore(trash, "users", bob)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("Deleting it from the trash should delete it permanently", func() {
+				_, err := trash.Delete("users", alice)
+				So(err, ShouldBeNil)
+				_, err = provider.Get("users", alice)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("Purge should delete it only after the retention", func() {
+				purged, err := Purge(provider, registry)
+				So(err, ShouldBeNil)
+				So(purged, ShouldEqual, 0)
+
+				registry.Add(Policy{Collection: "users", Retention: time.Nanosecond})
+				purged, err = Purge(provider, registry)
+				So(err, ShouldBeNil)
+				So(purged, ShouldEqual, 1)
+				So(ids(provider, "users"), ShouldResemble, []string{bob})
+			})
+		})
+
+		Convey("The deletion time should not be set by clients", func() {
+			created, err := hidden.Create("users", map[string]interface{}{"name": "carol", dataprovider.DeletedAtField: time.Now()})
+			So(err, ShouldBeNil)
+			_, err = hidden.Get("users", created[dataprovider.IdField].(string))
+			So(err, ShouldBeNil)
+
+			_, err = trash.Create("users", map[string]interface{}{"name": "dave"})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Other collections should be deleted permanently", func() {
+			created, _ := hidden.Create("books", map[string]interface{}{"title": "dune"})
+			_, err := hidden.Delete("books", created[dataprovider.IdField].(string))
+			So(err, ShouldBeNil)
+			So(ids(provider, "books"), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/query"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/changes"
+	"github.com/rihtim/core/history"
+	"github.com/rihtim/core/search"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/softdelete"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+// subscribe subscribes to the changes of the documents the GET request reads and executes the
+// request for the initial response. The request goes through the interceptors like any GET, so
+// subscribing is authorized like reading, and the subscription is filtered by the request edited
+// by the BEFORE_EXEC interceptors. The events are sent through the returned filter.
+//
+// The subscription is made before the request is executed, so an event of a change made
+// meanwhile may describe a document the initial response contains already. If the interceptors
+// answer the request, no subscription is made.
+func subscribe(ctx context.Context, request messages.Message, requestScope requestscope.RequestScope) (response messages.Message, subscription *changes.Subscription, filter eventFilter, err *utils.Error) {
+
+	var subscribed messages.Message
+	var subscribedScope requestscope.RequestScope
+
+	request.Command = methods.Get
+	response, _, err = handleRequest(ctx, request, requestScope, func(request messages.Message, requestScope requestscope.RequestScope) (err *utils.Error) {
+		collection, where, err := subscriptionFilter(request)
+		if err != nil {
+			return
+		}
+		subscription = Changes.Subscribe(collection, where)
+		subscribed, subscribedScope = request, requestScope
+		return
+	})
+	if err != nil {
+		if subscription != nil {
+			subscription.Close()
+			subscription = nil
+		}
+		return
+	}
+	if response.File != nil {
+		response.File.Close()
+		response.File = nil
+	}
+	if subscription != nil {
+		_, isList := dataprovider.Results(response.Body)
+		filter = newEventFilter(ctx, subscribed, subscribedScope, isList)
+	}
+	return
+}
+
+// eventFilter returns the event to send for a change, or false if it must not be sent.
+type eventFilter func(event changes.Event) (filtered changes.Event, send bool)
+
+// newEventFilter returns the filter which projects the documents of the events to the fields
+// parameter of the request and executes its AFTER_EXEC interceptors on them, as if the documents
+// were read by the request. Events whose documents the interceptors remove or reject are not sent.
+func newEventFilter(ctx context.Context, request messages.Message, requestScope requestscope.RequestScope, isList bool) eventFilter {
+
+	// the scope of the request carries its context, which may defer the changes of the
+	// request until its transaction is committed
+	requestScope = requestScope.Copy()
+	requestScope.SetContext(ctx)
+	ctx = requestscope.NewContext(ctx, requestScope)
+	requestScope.SetContext(ctx)
+	db := dataprovider.WithContext(ctx, DataProvider)
+
+	return func(event changes.Event) (filtered changes.Event, send bool) {
+		if event.Document == nil {
+			return event, true
+		}
+
+		// the document of the event is shared by the subscriptions
+		document := projectFields(request, utils.CopyDocument(event.Document), nil)
+		response := messages.Message{Body: document}
+		if isList {
+			response.Body = map[string]interface{}{dataprovider.ResultsField: []map[string]interface{}{document}}
+		}
+
+		_, editedResponse, _, err := executeInterceptors(interceptors.AFTER_EXEC, requestScope, request, response, db)
+		if err != nil {
+			return
+		}
+		if !editedResponse.IsEmpty() {
+			response = editedResponse
+		}
+
+		if isList {
+			results, _ := dataprovider.Results(response.Body)
+			if len(results) == 0 {
+				return
+			}
+			document = results[0]
+		} else {
+			document = response.Body
+		}
+		if document == nil {
+			return
+		}
+
+		event.Document = document
+		return event, true
+	}
+}
+
+// subscriptionFilter returns the collection and the condition of the documents the request reads,
+// ex: the books whose userId is {id} for /users/{id}/books?where={"year":2020}.
+func subscriptionFilter(request messages.Message) (collection string, where query.Condition, err *utils.Error) {
+
+	if _, _, isHistory := splitHistory(request.Res); isHistory {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "History cannot be subscribed to."}
+		return
+	}
+	if strings.HasSuffix(request.Res, "/"+AggregateSegment) {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Aggregations cannot be subscribed to."}
+		return
+	}
+	if _, deleted := request.GetParameter(softdelete.Parameter); deleted {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Trash cannot be subscribed to."}
+		return
+	}
+	if _, at := request.GetParameter(history.AtParameter); at {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Past versions cannot be subscribed to."}
+		return
+	}
+	if _, isSearch := request.GetParameter(search.Parameter); isSearch {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Searches cannot be subscribed to."}
+		return
+	}
+
+	parts := strings.Split(strings.TrimRight(request.Res, "/"), "/")[1:]
+	if len(parts) == 0 || parts[0] == "" {
+		err = invalidResourceSchema()
+		return
+	}
+
+	var conditions query.And
+	collection = parts[0]
+	for i := 2; i < len(parts); i += 2 {
+		relation, exists := Relations.Get(collection, parts[i])
+		if !exists {
+			err = invalidResourceSchema()
+			return
+		}
+		if i == len(parts)-1 || i == len(parts)-2 {
+			conditions = append(conditions, query.Comparison{Field: relation.ForeignKey, Operator: query.Equal, Value: parts[i-1]})
+		}
+		collection = relation.ChildCollection()
+	}
+
+	if len(parts)%2 == 0 {
+		conditions = append(conditions, query.Comparison{Field: dataprovider.IdField, Operator: query.Equal, Value: parts[len(parts)-1]})
+	} else {
+		q, parseErr := query.Parse(request.Parameters)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		if q.Where != nil {
+			conditions = append(conditions, q.Where)
+		}
+	}
+
+	if len(conditions) > 0 {
+		where = conditions
+	}
+	return
+}
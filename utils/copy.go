@@ -0,0 +1,41 @@
+package utils
+
+// CopyDocument deep copies the maps and lists of the document, so that the copy can be
+// modified without modifying the original. Returns nil if the document is nil.
+func CopyDocument(document map[string]interface{}) map[string]interface{} {
+	if document == nil {
+		return nil
+	}
+	clone := make(map[string]interface{}, len(document))
+	for key, value := range document {
+		clone[key] = CopyValue(value)
+	}
+	return clone
+}
+
+// CopyValue deep copies maps and lists. Other values are returned as they are.
+func CopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return CopyDocument(v)
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make([]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = CopyValue(item)
+		}
+		return clone
+	case []map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = CopyDocument(item)
+		}
+		return clone
+	}
+	return value
+}
@@ -1,12 +1,36 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"context"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the non-standard status code used when
+// the client closes the connection before the response is ready.
+const StatusClientClosedRequest = 499
 
 type Error struct {
-	Code    int    `json:"code,omitempty"`
-	Message string `json:"message,omitempty"`
+	Code    int         `json:"code,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Details interface{} `json:"details,omitempty"` // added to the response body if not nil
 }
 
 func (e *Error) Error() string {
 	return fmt.Sprintf("%d - %s", e.Code, e.Message)
 }
+
+// ContextError converts the error of a done context to an Error.
+// Returns nil if the given error is nil.
+func ContextError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if err == context.DeadlineExceeded {
+		return &Error{Code: http.StatusGatewayTimeout, Message: "Request timed out."}
+	}
+	if err == context.Canceled {
+		return &Error{Code: StatusClientClosedRequest, Message: "Request canceled by the client."}
+	}
+	return &Error{Code: http.StatusInternalServerError, Message: err.Error()}
+}
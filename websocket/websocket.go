@@ -0,0 +1,366 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC 6455) which
+// the socket transport needs: the opening handshake, text and binary messages, fragmented
+// messages, ping, pong and close. Extensions and subprotocols are not supported.
+//
+// Connections are pinged periodically and closed when nothing is received from them for
+// longer than their read timeout, so that connections of unresponsive clients are released.
+package websocket
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+	"bufio"
+	"errors"
+	"strings"
+	"unicode/utf8"
+	"net/url"
+	"net/http"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/base64"
+	"github.com/rihtim/core/utils"
+)
+
+// Opcodes of the frames.
+const (
+	continuationFrame = 0x0
+	TextMessage       = 0x1
+	BinaryMessage     = 0x2
+	closeFrame        = 0x8
+	pingFrame         = 0x9
+	pongFrame         = 0xA
+)
+
+// Status codes of the close frames.
+const (
+	CloseNormal         = 1000
+	CloseProtocolError  = 1002
+	CloseInvalidPayload = 1007
+	CloseTooLarge       = 1009
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize is the default maximum size of a received message in bytes.
+var MaxMessageSize int64 = 1 << 20
+
+// ReadTimeout is the default time a connection may stay without receiving a frame, including
+// the pongs answering the pings. PingInterval is the default interval of the pings, which must
+// be shorter than the read timeout. WriteTimeout is the time a frame may take to be written.
+var ReadTimeout = 60 * time.Second
+var PingInterval = 50 * time.Second
+var WriteTimeout = 10 * time.Second
+
+var ErrTooLarge = errors.New("websocket: message is too large")
+var errProtocol = errors.New("websocket: protocol error")
+var errInvalidPayload = errors.New("websocket: text is not valid UTF-8")
+
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// MaxMessageSize is the maximum size of a received message. Larger
+	// messages close the connection with the status code 1009.
+	MaxMessageSize int64
+
+	// ReadTimeout is the time the connection may stay without receiving a frame and
+	// PingInterval is the interval of the pings sent to it. Zero disables them. They
+	// must be set before the first read.
+	ReadTimeout  time.Duration
+	PingInterval time.Duration
+
+	writeMutex sync.Mutex
+	pingOnce   sync.Once
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// Upgrade completes the opening handshake and takes over the connection of the request.
+// If checkOrigin is nil, only requests without an Origin header or with an origin of the
+// same host are accepted, which prevents other sites from using the cookies of the user.
+func Upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(r *http.Request) bool) (conn *Conn, err *utils.Error) {
+
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Request is not a WebSocket handshake."}
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		err = &utils.Error{Code: http.StatusUpgradeRequired, Message: "Unsupported WebSocket version."}
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, decodeErr := base64.StdEncoding.DecodeString(key); decodeErr != nil || len(decoded) != 16 {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Invalid WebSocket key."}
+		return
+	}
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		err = &utils.Error{Code: http.StatusForbidden, Message: "Origin is not allowed."}
+		return
+	}
+
+	hijacker, isHijacker := w.(http.Hijacker)
+	if !isHijacker {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Connection cannot be upgraded."}
+		return
+	}
+	netConn, buffered, hijackErr := hijacker.Hijack()
+	if hijackErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Upgrading connection failed. Reason: " + hijackErr.Error()}
+		return
+	}
+
+	netConn.SetDeadline(time.Time{})
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, writeErr := netConn.Write([]byte(response)); writeErr != nil {
+		netConn.Close()
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Upgrading connection failed. Reason: " + writeErr.Error()}
+		return
+	}
+
+	conn = &Conn{
+		conn:           netConn,
+		reader:         buffered.Reader,
+		MaxMessageSize: MaxMessageSize,
+		ReadTimeout:    ReadTimeout,
+		PingInterval:   PingInterval,
+		closed:         make(chan struct{}),
+	}
+	return
+}
+
+// ReadMessage reads the next text or binary message. Pings are answered while reading.
+// Returns io.EOF when the peer closes the connection, and a timeout error when nothing is
+// received within the read timeout.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+
+	c.pingOnce.Do(func() {
+		if c.PingInterval > 0 {
+			go c.ping(c.PingInterval)
+		}
+	})
+
+	for {
+		if c.ReadTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		}
+		final, opcode, payload, readErr := c.readFrame()
+		if readErr != nil {
+			if readErr == ErrTooLarge {
+				c.closeWith(CloseTooLarge)
+			} else if readErr == errProtocol {
+				c.closeWith(CloseProtocolError)
+			}
+			return 0, nil, readErr
+		}
+
+		switch opcode {
+		case pingFrame:
+			if err = c.writeFrame(pongFrame, payload); err != nil {
+				return
+			}
+			continue
+		case pongFrame:
+			continue
+		case closeFrame:
+			// the status code is echoed; a reason which is not valid UTF-8 is a protocol error
+			if len(payload) == 1 {
+				c.closeWith(CloseProtocolError)
+				return 0, nil, errProtocol
+			}
+			if len(payload) > 2 && !utf8.Valid(payload[2:]) {
+				c.closeWith(CloseInvalidPayload)
+				return 0, nil, errInvalidPayload
+			}
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.closeWithPayload(payload)
+			return 0, nil, io.EOF
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				c.closeWith(CloseProtocolError)
+				return 0, nil, errProtocol
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == 0 {
+				c.closeWith(CloseProtocolError)
+				return 0, nil, errProtocol
+			}
+		default:
+			c.closeWith(CloseProtocolError)
+			return 0, nil, errProtocol
+		}
+
+		if int64(len(data)+len(payload)) > c.MaxMessageSize {
+			c.closeWith(CloseTooLarge)
+			return 0, nil, ErrTooLarge
+		}
+		data = append(data, payload...)
+		if final {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				c.closeWith(CloseInvalidPayload)
+				return 0, nil, errInvalidPayload
+			}
+			return
+		}
+	}
+}
+
+// WriteMessage writes a text or binary message. It is safe to call concurrently.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+// Close sends a close frame and closes the connection.
+func (c *Conn) Close() error {
+	return c.closeWith(CloseNormal)
+}
+
+func (c *Conn) closeWith(code int) (err error) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.closeWithPayload(payload)
+}
+
+func (c *Conn) closeWithPayload(payload []byte) (err error) {
+	c.closeOnce.Do(func() {
+		if c.closed != nil {
+			close(c.closed)
+		}
+		c.writeFrame(closeFrame, payload)
+		err = c.conn.Close()
+	})
+	return
+}
+
+// ping pings the connection until it is closed or a ping cannot be written.
+func (c *Conn) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if c.writeFrame(pingFrame, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads a frame and unmasks its payload. Frames of clients must be masked.
+func (c *Conn) readFrame() (final bool, opcode int, payload []byte, err error) {
+
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+	final = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	if header[0]&0x70 != 0 || !masked {
+		err = errProtocol
+		return
+	}
+	if opcode >= closeFrame && (!final || length > 125) {
+		err = errProtocol
+		return
+	}
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err = io.ReadFull(c.reader, extended); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err = io.ReadFull(c.reader, extended); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(extended))
+	}
+	if length < 0 || length > c.MaxMessageSize {
+		err = ErrTooLarge
+		return
+	}
+
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(c.reader, mask); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame writes an unmasked final frame, as servers do.
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+
+	frame := []byte{0x80 | byte(opcode)}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		extended := make([]byte, 8)
+		binary.BigEndian.PutUint64(extended, uint64(length))
+		frame = append(append(frame, 127), extended...)
+	}
+	frame = append(frame, payload...)
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// headerContains tells whether the comma separated values of the header contain the token.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
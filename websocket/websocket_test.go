@@ -0,0 +1,221 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"time"
+	"bufio"
+	"testing"
+	"net/http"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/base64"
+	"net/http/httptest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// client is a minimal client which writes masked frames.
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(server *httptest.Server, origin string) (c *client, response *http.Response) {
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	So(err, ShouldBeNil)
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	So(request.Write(conn), ShouldBeNil)
+
+	reader := bufio.NewReader(conn)
+	response, err = http.ReadResponse(reader, request)
+	So(err, ShouldBeNil)
+	if response.StatusCode == http.StatusSwitchingProtocols {
+		So(response.Header.Get("Sec-WebSocket-Accept"), ShouldEqual, acceptKey(request.Header.Get("Sec-WebSocket-Key")))
+	}
+	return &client{conn, reader}, response
+}
+
+func (c *client) write(final bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if final {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	if len(payload) <= 125 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *client) read() (opcode int, payload []byte) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.reader, header)
+	So(err, ShouldBeNil)
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(c.reader, extended)
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload = make([]byte, length)
+	io.ReadFull(c.reader, payload)
+	return int(header[0] & 0x0F), payload
+}
+
+func TestWebSocket(t *testing.T) {
+
+	Convey("Given a server echoing the messages", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(w, r, nil)
+			if err != nil {
+				http.Error(w, err.Message, err.Code)
+				return
+			}
+			conn.MaxMessageSize = 1024
+			defer conn.Close()
+			for {
+				messageType, data, readErr := conn.ReadMessage()
+				if readErr != nil {
+					return
+				}
+				conn.WriteMessage(messageType, data)
+			}
+		}))
+		defer server.Close()
+
+		Convey("Messages should be echoed", func() {
+			c, response := dial(server, "")
+			So(response.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+
+			c.write(true, TextMessage, []byte("hello"))
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, TextMessage)
+			So(string(payload), ShouldEqual, "hello")
+		})
+
+		Convey("Fragmented messages should be joined and pings answered in between", func() {
+			c, _ := dial(server, "")
+			c.write(false, BinaryMessage, []byte("hel"))
+			c.write(true, pingFrame, []byte("p"))
+			c.write(true, continuationFrame, []byte("lo"))
+
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, pongFrame)
+			So(string(payload), ShouldEqual, "p")
+
+			opcode, payload = c.read()
+			So(opcode, ShouldEqual, BinaryMessage)
+			So(string(payload), ShouldEqual, "hello")
+		})
+
+		Convey("Too large messages should close the connection", func() {
+			c, _ := dial(server, "")
+			c.write(true, TextMessage, make([]byte, 2048))
+
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, closeFrame)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, CloseTooLarge)
+		})
+
+		Convey("Close frames should be answered with their status code", func() {
+			c, _ := dial(server, "")
+			c.write(true, closeFrame, append([]byte{0x03, 0xE9}, "going away"...))
+
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, closeFrame)
+			So(payload, ShouldResemble, []byte{0x03, 0xE9})
+		})
+
+		Convey("Close frames with invalid payloads should be rejected", func() {
+			c, _ := dial(server, "")
+			c.write(true, closeFrame, []byte{0x03})
+
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, closeFrame)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, CloseProtocolError)
+		})
+
+		Convey("Text which is not valid UTF-8 should close the connection", func() {
+			c, _ := dial(server, "")
+			c.write(false, TextMessage, []byte{'a', 0xE2, 0x82})
+			c.write(true, continuationFrame, []byte{0xAC})
+			opcode, payload := c.read()
+			So(opcode, ShouldEqual, TextMessage)
+			So(string(payload), ShouldEqual, "a€")
+
+			c.write(true, TextMessage, []byte{0xFF})
+			opcode, payload = c.read()
+			So(opcode, ShouldEqual, closeFrame)
+			So(binary.BigEndian.Uint16(payload), ShouldEqual, CloseInvalidPayload)
+		})
+
+		Convey("Other origins should be rejected", func() {
+			_, response := dial(server, "http://example.com")
+			So(response.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Same origin should be accepted", func() {
+			_, response := dial(server, server.URL)
+			So(response.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		})
+	})
+	Convey("Given a server with short timeouts", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			conn.ReadTimeout = 200 * time.Millisecond
+			conn.PingInterval = 50 * time.Millisecond
+			defer conn.Close()
+			for {
+				if _, _, readErr := conn.ReadMessage(); readErr != nil {
+					return
+				}
+			}
+		}))
+		defer server.Close()
+
+		Convey("Connections should be pinged", func() {
+			c, _ := dial(server, "")
+			opcode, _ := c.read()
+			So(opcode, ShouldEqual, pingFrame)
+		})
+
+		Convey("Connections answering the pings should stay open", func() {
+			c, _ := dial(server, "")
+			for i := 0; i < 6; i++ {
+				opcode, payload := c.read()
+				So(opcode, ShouldEqual, pingFrame)
+				c.write(true, pongFrame, payload)
+			}
+		})
+
+		Convey("Idle connections should be closed", func() {
+			c, _ := dial(server, "")
+			opcode := pingFrame
+			for opcode == pingFrame {
+				opcode, _ = c.read()
+			}
+			So(opcode, ShouldEqual, closeFrame)
+		})
+	})
+}